@@ -3,18 +3,96 @@ package common
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/context"
+	"github.com/garyburd/redigo/redis"
 )
 
 const AuthExpireDuration = 10 * time.Minute
 
+// Versions of Signature-Version header.
+const (
+	// HMAC-SHA1 over Date and URL only.
+	SignatureV1 = "1"
+	// HMAC-SHA256 over method, path, query, body hash, Date and Nonce,
+	// see StringToSignV2.
+	SignatureV2 = "2"
+)
+
+const signatureV2Algorithm = "MAB2-HMAC-SHA256"
+
+// Keys of ctx.Input data set by AuthWithKey for requests signed with a host key.
+const (
+	AuthHostIdKey   = "AuthHostId"
+	AuthHostNameKey = "AuthHostName"
+)
+
+var (
+	ErrorNoHostKeyResolver = errors.New("Host keys are not supported")
+	ErrorLoginKeyDisabled  = errors.New("Shared login key is disabled, KeyId is required")
+	ErrorHostNotMatch      = errors.New("Key does not belong to this host")
+	ErrorSignV1Disabled    = errors.New("Signature version 1 is disabled")
+	ErrorBadSignVersion    = errors.New("Unknown signature version")
+	ErrorNoNonce           = errors.New("Nonce is required")
+	ErrorNonceUsed         = errors.New("Nonce has been used")
+)
+
+// HostKeyResolver finds the host and the secret of a key id.
+// It lives here so common does not import models, models sets it in init.
+var HostKeyResolver func(keyId string) (hostId, hostName, secret string, err error)
+
+// CheckNonce returns false if nonce has been seen within ttl.
+// It is a variable so tests can run without redis.
+var CheckNonce = checkNonceRedis
+
+func checkNonceRedis(nonce string, ttl time.Duration) (bool, error) {
+	c := RedisPool.Get()
+	defer c.Close()
+	_, err := redis.String(c.Do(
+		"SET", RedisKey("nonce:"+nonce), 1,
+		"EX", int64(ttl/time.Second), "NX",
+	))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// AuthWithKey checks the Signature header. With a KeyId header it is
+// signed by the secret of that host key, and the :name of URL must be the
+// host which owns the key. Without KeyId the shared loginkey is used if
+// security::allowloginkey is true.
+// Signature-Version selects how it is signed, version 1 is only accepted
+// if security::allowsignv1 is true.
 func AuthWithKey(ctx *context.Context) error {
-	key := beego.AppConfig.String("loginkey")
+	var key, hostId, hostName string
+	keyId := ctx.Input.Header("KeyId")
+	if keyId != "" {
+		if HostKeyResolver == nil {
+			return ErrorNoHostKeyResolver
+		}
+		var err error
+		hostId, hostName, key, err = HostKeyResolver(keyId)
+		if err != nil {
+			return err
+		}
+	} else {
+		if !beego.AppConfig.DefaultBool("security::allowloginkey", false) {
+			return ErrorLoginKeyDisabled
+		}
+		key = beego.AppConfig.String("loginkey")
+	}
+
 	sTime := ctx.Input.Header("Date")
 	pTime, err := time.Parse(time.RFC1123, sTime)
 	if err != nil {
@@ -24,22 +102,113 @@ func AuthWithKey(ctx *context.Context) error {
 		time.Now().UTC().Sub(pTime) < -AuthExpireDuration {
 		return fmt.Errorf("Client time is out of server time")
 	}
-	sign := ctx.Input.Header("Signature")
-	h := hmac.New(sha1.New, []byte(key))
 	beego.Debug("Got URL:", ctx.Input.URL())
 	beego.Debug("Got date:", sTime)
-	h.Write(
-		[]byte(
-			fmt.Sprintf(
-				"%s%s",
-				sTime,
-				ctx.Input.URL(),
+
+	sign := ctx.Input.Header("Signature")
+	switch ctx.Input.Header("Signature-Version") {
+	case "", SignatureV1:
+		if !beego.AppConfig.DefaultBool("security::allowsignv1", false) {
+			return ErrorSignV1Disabled
+		}
+		h := hmac.New(sha1.New, []byte(key))
+		h.Write(
+			[]byte(
+				fmt.Sprintf(
+					"%s%s",
+					sTime,
+					ctx.Input.URL(),
+				),
 			),
-		),
-	)
-	b := base64.StdEncoding.EncodeToString(h.Sum(nil))
-	if sign != b {
-		return fmt.Errorf("Bad signature.")
+		)
+		b := base64.StdEncoding.EncodeToString(h.Sum(nil))
+		if !hmac.Equal([]byte(sign), []byte(b)) {
+			return fmt.Errorf("Bad signature.")
+		}
+	case SignatureV2:
+		nonce := ctx.Input.Header("Nonce")
+		if nonce == "" {
+			return ErrorNoNonce
+		}
+		b := SignV2(key, StringToSignV2(
+			ctx.Input.Method(),
+			ctx.Input.URL(),
+			ctx.Request.URL.Query(),
+			ctx.Input.RequestBody,
+			sTime,
+			nonce,
+		))
+		if !hmac.Equal([]byte(sign), []byte(b)) {
+			return fmt.Errorf("Bad signature.")
+		}
+		// Date is checked above, so remembering nonce a bit longer
+		// than AuthExpireDuration is enough to refuse every replay.
+		ok, err := CheckNonce(keyId+":"+nonce, 2*AuthExpireDuration)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrorNonceUsed
+		}
+	default:
+		return ErrorBadSignVersion
+	}
+
+	if keyId != "" {
+		if name := ctx.Input.Param(":name"); name != "" && name != hostName {
+			return ErrorHostNotMatch
+		}
+		ctx.Input.SetData(AuthHostIdKey, hostId)
+		ctx.Input.SetData(AuthHostNameKey, hostName)
 	}
 	return nil
 }
+
+// StringToSignV2 is what signature version 2 signs, every part on its own line:
+//
+//	MAB2-HMAC-SHA256
+//	method
+//	path
+//	query, sorted and url encoded
+//	hex sha256 of body
+//	Date header
+//	Nonce header
+func StringToSignV2(method, path string, query url.Values, body []byte, date, nonce string) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{
+		signatureV2Algorithm,
+		strings.ToUpper(method),
+		path,
+		strings.Join(parts, "&"),
+		hex.EncodeToString(bodyHash[:]),
+		date,
+		nonce,
+	}, "\n")
+}
+
+func SignV2(key, stringToSign string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// AuthedHost returns the host authenticated by its key,
+// both are empty for sessions and the shared loginkey.
+func AuthedHost(ctx *context.Context) (id, name string) {
+	id, _ = ctx.Input.GetData(AuthHostIdKey).(string)
+	name, _ = ctx.Input.GetData(AuthHostNameKey).(string)
+	return id, name
+}
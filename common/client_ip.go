@@ -0,0 +1,73 @@
+package common
+
+import (
+	"net"
+	"strings"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+)
+
+// ClientIP is the IP a request comes from, which login limits and logs
+// are keyed on. Anyone can send X-Forwarded-For, so it is only honoured
+// from proxies in security::trustedproxies, IPs or CIDRs separated by
+// commas, and the first address in it which is not such a proxy is used.
+func ClientIP(ctx *context.Context) string {
+	ip := ctx.Request.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	proxies := trustedProxies()
+	if !isTrustedProxy(proxies, ip) {
+		return ip
+	}
+	forwarded := strings.Split(ctx.Input.Header("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(proxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func trustedProxies() []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, s := range strings.Split(beego.AppConfig.String("security::trustedproxies"), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			beego.Warn("[C] Bad security::trustedproxies:", s, err)
+			continue
+		}
+		proxies = append(proxies, n)
+	}
+	return proxies
+}
+
+func isTrustedProxy(proxies []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range proxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/astaxie/beego"
+)
+
+// Secrets encrypted by EncryptSecret look like "enc:v1:<base64>".
+const encryptedSecretPrefix = "enc:v1:"
+
+var (
+	ErrorNoMasterKey     = errors.New("security::masterkey is not set")
+	ErrorBadSecretFormat = errors.New("Bad encrypted secret")
+)
+
+func masterCipher() (cipher.AEAD, error) {
+	key := beego.AppConfig.String("security::masterkey")
+	if key == "" {
+		return nil, ErrorNoMasterKey
+	}
+	// Any length of master key is ok, AES-256 needs exactly 32 bytes.
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret encrypts s with the server master key (AES-GCM),
+// so secrets are never stored in database as plain text. s is always
+// encrypted, even if it looks encrypted, callers must not pass secrets
+// they read back from database.
+func EncryptSecret(s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+	aead, err := masterCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", err
+	}
+	b := aead.Seal(nonce, nonce, []byte(s), nil)
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+func DecryptSecret(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	if !IsEncryptedSecret(s) {
+		return "", ErrorBadSecretFormat
+	}
+	b, err := base64.StdEncoding.DecodeString(
+		strings.TrimPrefix(s, encryptedSecretPrefix),
+	)
+	if err != nil {
+		return "", err
+	}
+	aead, err := masterCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(b) < aead.NonceSize() {
+		return "", ErrorBadSecretFormat
+	}
+	p, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(p), nil
+}
+
+func IsEncryptedSecret(s string) bool {
+	return strings.HasPrefix(s, encryptedSecretPrefix)
+}
@@ -0,0 +1,158 @@
+package common
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/astaxie/beego"
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	ErrorLdapNotConfigured = errors.New("ldap::url is not set")
+	ErrorLdapBadUser       = errors.New("Bad LDAP user name or password")
+)
+
+// LdapConfig describes a directory, it is usually made by NewLdapConfig.
+type LdapConfig struct {
+	// ldap://host:389 or ldaps://host:636
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	// Account to search users with, anonymous if empty.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// Filter to find a user, %s is replaced with the escaped login name.
+	UserFilter string
+	NameAttr   string
+	GroupAttr  string
+	// Group DN to role name, groups not in it are ignored.
+	GroupMap    map[string]string
+	DefaultRole string
+}
+
+// LdapEntry is a user found and authenticated in directory.
+type LdapEntry struct {
+	DN       string
+	ShowName string
+	Groups   []string
+}
+
+// NewLdapConfig reads section ldap of app.conf, groupmap there
+// looks like "cn=admins,ou=groups,dc=example,dc=com:Administrator;...".
+func NewLdapConfig() *LdapConfig {
+	conf := &LdapConfig{
+		URL:                beego.AppConfig.String("ldap::url"),
+		StartTLS:           beego.AppConfig.DefaultBool("ldap::starttls", false),
+		InsecureSkipVerify: beego.AppConfig.DefaultBool("ldap::insecureskipverify", false),
+		BindDN:             beego.AppConfig.String("ldap::binddn"),
+		BindPassword:       beego.AppConfig.String("ldap::bindpassword"),
+		BaseDN:             beego.AppConfig.String("ldap::basedn"),
+		UserFilter:         beego.AppConfig.DefaultString("ldap::userfilter", "(uid=%s)"),
+		NameAttr:           beego.AppConfig.DefaultString("ldap::nameattr", "cn"),
+		GroupAttr:          beego.AppConfig.DefaultString("ldap::groupattr", "memberOf"),
+		GroupMap:           make(map[string]string),
+		DefaultRole:        beego.AppConfig.String("ldap::defaultrole"),
+	}
+	// beego splits values by ";" itself.
+	for _, v := range beego.AppConfig.Strings("ldap::groupmap") {
+		i := strings.LastIndex(v, ":")
+		if i <= 0 {
+			continue
+		}
+		conf.GroupMap[strings.ToLower(strings.TrimSpace(v[:i]))] = strings.TrimSpace(v[i+1:])
+	}
+	return conf
+}
+
+// RoleNames maps groups of a user to names of roles,
+// DefaultRole is used if no group is mapped.
+func (c *LdapConfig) RoleNames(groups []string) []string {
+	r := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, g := range groups {
+		role, ok := c.GroupMap[strings.ToLower(g)]
+		if ok && !seen[role] {
+			seen[role] = true
+			r = append(r, role)
+		}
+	}
+	if len(r) == 0 && c.DefaultRole != "" {
+		r = append(r, c.DefaultRole)
+	}
+	return r
+}
+
+func (c *LdapConfig) dial() (*ldap.Conn, error) {
+	if c.URL == "" {
+		return nil, ErrorLdapNotConfigured
+	}
+	tlsConf := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	conn, err := ldap.DialURL(c.URL, ldap.DialWithTLSConfig(tlsConf))
+	if err != nil {
+		return nil, err
+	}
+	if c.StartTLS {
+		if i := strings.Index(c.URL, "://"); i >= 0 {
+			tlsConf.ServerName = strings.Split(c.URL[i+3:], ":")[0]
+		}
+		err = conn.StartTLS(tlsConf)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// LdapAuthenticate finds name with the search account, then binds
+// as it with password. ErrorLdapBadUser is returned if user is not
+// found or password is wrong.
+func LdapAuthenticate(c *LdapConfig, name, password string) (*LdapEntry, error) {
+	// Empty password would be an unauthenticated bind, which always works.
+	if name == "" || password == "" {
+		return nil, ErrorLdapBadUser
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if c.BindDN != "" {
+		err = conn.Bind(c.BindDN, c.BindPassword)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to bind search account: %s", err)
+		}
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		c.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 10, false,
+		fmt.Sprintf(c.UserFilter, ldap.EscapeFilter(name)),
+		[]string{c.NameAttr, c.GroupAttr},
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	if result == nil || len(result.Entries) != 1 {
+		// Nobody, or more than one, are both not the user.
+		return nil, ErrorLdapBadUser
+	}
+	entry := result.Entries[0]
+
+	err = conn.Bind(entry.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrorLdapBadUser
+	} else if err != nil {
+		return nil, err
+	}
+	return &LdapEntry{
+		DN:       entry.DN,
+		ShowName: entry.GetAttributeValue(c.NameAttr),
+		Groups:   entry.GetAttributeValues(c.GroupAttr),
+	}, nil
+}
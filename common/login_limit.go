@@ -0,0 +1,123 @@
+package common
+
+import (
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/garyburd/redigo/redis"
+)
+
+// Failures of login are counted per user name and per IP in redis,
+// after security::loginmaxfailures of them in security::loginwindow
+// seconds the user or IP is locked, for longer after every new failure.
+
+func loginMaxFailures() int {
+	return beego.AppConfig.DefaultInt("security::loginmaxfailures", 5)
+}
+
+func loginWindow() time.Duration {
+	return time.Duration(
+		beego.AppConfig.DefaultInt64("security::loginwindow", 900),
+	) * time.Second
+}
+
+// LockoutDuration is how long to lock after failures times, 0 if not yet.
+// It doubles from security::lockoutbase up to security::lockoutmax seconds.
+func LockoutDuration(failures int) time.Duration {
+	over := failures - loginMaxFailures()
+	if over < 0 {
+		return 0
+	}
+	base := time.Duration(
+		beego.AppConfig.DefaultInt64("security::lockoutbase", 30),
+	) * time.Second
+	max := time.Duration(
+		beego.AppConfig.DefaultInt64("security::lockoutmax", 3600),
+	) * time.Second
+	d := base
+	for i := 0; i < over && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+func loginKeys(kind, name, ip string) []string {
+	keys := make([]string, 0, 2)
+	if name != "" {
+		keys = append(keys, RedisKey("login:"+kind+":user:"+name))
+	}
+	if ip != "" {
+		keys = append(keys, RedisKey("login:"+kind+":ip:"+ip))
+	}
+	return keys
+}
+
+// LoginLockedFor returns how long name or ip is still locked, 0 if not.
+func LoginLockedFor(name, ip string) (time.Duration, error) {
+	c := RedisPool.Get()
+	defer c.Close()
+	var locked time.Duration
+	for _, k := range loginKeys("lock", name, ip) {
+		ttl, err := redis.Int64(c.Do("TTL", k))
+		if err != nil {
+			return 0, err
+		}
+		if d := time.Duration(ttl) * time.Second; d > locked {
+			locked = d
+		}
+	}
+	return locked, nil
+}
+
+// LoginFailed counts a failure of name from ip,
+// and returns how long they are locked because of it.
+func LoginFailed(name, ip string) (time.Duration, error) {
+	c := RedisPool.Get()
+	defer c.Close()
+	var locked time.Duration
+	for _, k := range loginKeys("fail", name, ip) {
+		n, err := redis.Int(c.Do("INCR", k))
+		if err != nil {
+			return 0, err
+		}
+		_, err = c.Do("EXPIRE", k, int64(loginWindow()/time.Second))
+		if err != nil {
+			return 0, err
+		}
+		if d := LockoutDuration(n); d > locked {
+			locked = d
+		}
+	}
+	if locked > 0 {
+		for _, k := range loginKeys("lock", name, ip) {
+			_, err := c.Do("SET", k, 1, "EX", int64(locked/time.Second))
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return locked, nil
+}
+
+// LoginSucceeded forgets failures of name, those of IP are kept
+// so one good account does not reset guessing of others.
+func LoginSucceeded(name string) error {
+	c := RedisPool.Get()
+	defer c.Close()
+	_, err := c.Do("DEL", loginKeys("fail", name, "")[0])
+	return err
+}
+
+// UnlockLogin forgets failures and lock of user name.
+func UnlockLogin(name string) error {
+	c := RedisPool.Get()
+	defer c.Close()
+	_, err := c.Do("DEL",
+		loginKeys("fail", name, "")[0],
+		loginKeys("lock", name, "")[0],
+	)
+	return err
+}
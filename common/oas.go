@@ -32,14 +32,20 @@ func (o *OasClient) GetOasVaultId(name string) (string, error) {
 	}
 }
 
-func NewOasClient(endpoint string) (*OasClient, error) {
+// NewOasClient connects to endpoint with key and secret,
+// the global aliapi ones are used if key is empty.
+func NewOasClient(endpoint, key, secret string) (*OasClient, error) {
 	oasPort := beego.AppConfig.DefaultInt("aliapi::oasport", 80)
 	oasUseSSL := beego.AppConfig.DefaultBool("aliapi::oasusessl", false)
+	if key == "" {
+		key = beego.AppConfig.String("aliapi::apikey")
+		secret = beego.AppConfig.String("aliapi::secret")
+	}
 	o := new(OasClient)
 	o.OasClient = oas.NewOasClient(
 		endpoint,
-		beego.AppConfig.String("aliapi::apikey"),
-		beego.AppConfig.String("aliapi::secret"),
+		key,
+		secret,
 		oasPort,
 		oasUseSSL,
 	)
@@ -0,0 +1,341 @@
+package common
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+var (
+	ErrorOidcNotConfigured = errors.New("oidc::issuer is not set")
+	ErrorOidcBadToken      = errors.New("Bad id token")
+	ErrorOidcKeyNotFound   = errors.New("Key of id token not found")
+	ErrorOidcNoUser        = errors.New("No user name in id token")
+	ErrorOidcNoSubject     = errors.New("No subject in id token")
+)
+
+// OidcConfig describes an OpenID provider, it is usually made by NewOidcConfig.
+type OidcConfig struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// Claims of id token for login name, show name and roles.
+	UserClaim  string
+	NameClaim  string
+	RolesClaim string
+	// Value of roles claim to role name, values not in it are ignored.
+	RoleMap     map[string]string
+	DefaultRole string
+}
+
+// OidcProvider is the discovery document of an issuer.
+type OidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// NewOidcConfig reads section oidc of app.conf, rolemap there
+// looks like "backup-admins:Administrator;backup-ops:Operator".
+func NewOidcConfig() *OidcConfig {
+	conf := &OidcConfig{
+		Issuer:       strings.TrimSuffix(beego.AppConfig.String("oidc::issuer"), "/"),
+		ClientId:     beego.AppConfig.String("oidc::clientid"),
+		ClientSecret: beego.AppConfig.String("oidc::clientsecret"),
+		RedirectURL:  beego.AppConfig.String("oidc::redirecturl"),
+		Scopes:       strings.Fields(beego.AppConfig.DefaultString("oidc::scopes", "openid profile")),
+		UserClaim:    beego.AppConfig.DefaultString("oidc::userclaim", "preferred_username"),
+		NameClaim:    beego.AppConfig.DefaultString("oidc::nameclaim", "name"),
+		RolesClaim:   beego.AppConfig.DefaultString("oidc::rolesclaim", "groups"),
+		RoleMap:      make(map[string]string),
+		DefaultRole:  beego.AppConfig.String("oidc::defaultrole"),
+	}
+	for _, v := range beego.AppConfig.Strings("oidc::rolemap") {
+		i := strings.LastIndex(v, ":")
+		if i <= 0 {
+			continue
+		}
+		conf.RoleMap[strings.TrimSpace(v[:i])] = strings.TrimSpace(v[i+1:])
+	}
+	return conf
+}
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+func oidcGetJSON(u string, v interface{}) error {
+	resp, err := oidcClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to get %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// OidcDiscover gets the discovery document of issuer.
+func OidcDiscover(issuer string) (*OidcProvider, error) {
+	if issuer == "" {
+		return nil, ErrorOidcNotConfigured
+	}
+	p := new(OidcProvider)
+	err := oidcGetJSON(issuer+"/.well-known/openid-configuration", p)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("Issuer of discovery is %s, not %s", p.Issuer, issuer)
+	}
+	return p, nil
+}
+
+// NewOidcRandom makes state, nonce and PKCE code verifier.
+func NewOidcRandom() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PkceChallenge is the S256 code challenge of verifier, RFC 7636.
+func PkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where the browser is sent to login.
+func (c *OidcConfig) AuthCodeURL(p *OidcProvider, state, nonce, verifier string) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", c.ClientId)
+	q.Set("redirect_uri", c.RedirectURL)
+	q.Set("scope", strings.Join(c.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", PkceChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange trades code for the id token at token endpoint.
+func (c *OidcConfig) Exchange(p *OidcProvider, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", c.ClientId)
+	req, err := http.NewRequest(http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.ClientId), url.QueryEscape(c.ClientSecret))
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	r := struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || r.Error != "" {
+		return "", fmt.Errorf("Token endpoint: %s %s %s", resp.Status, r.Error, r.ErrorDescription)
+	}
+	if r.IdToken == "" {
+		return "", ErrorOidcBadToken
+	}
+	return r.IdToken, nil
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// Keys of providers, refreshed when a kid is not found.
+var (
+	oidcKeys     = make(map[string]map[string]*rsa.PublicKey)
+	oidcKeysLock sync.Mutex
+)
+
+func oidcKey(p *OidcProvider, kid string) (*rsa.PublicKey, error) {
+	oidcKeysLock.Lock()
+	defer oidcKeysLock.Unlock()
+	if k, ok := oidcKeys[p.JwksURI][kid]; ok {
+		return k, nil
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	err := oidcGetJSON(p.JwksURI, &set)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, v := range set.Keys {
+		if v.Kty != "RSA" {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(v.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(v.E)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		keys[v.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	oidcKeys[p.JwksURI] = keys
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, ErrorOidcKeyNotFound
+}
+
+// VerifyIdToken checks signature (RS256), issuer, audience, expiry
+// and nonce of an id token, and returns its claims.
+func (c *OidcConfig) VerifyIdToken(p *OidcProvider, token, nonce string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrorOidcBadToken
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(b, &header) != nil {
+		return nil, ErrorOidcBadToken
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%s: alg %s is not supported", ErrorOidcBadToken, header.Alg)
+	}
+	key, err := oidcKey(p, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrorOidcBadToken
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig)
+	if err != nil {
+		return nil, ErrorOidcBadToken
+	}
+
+	claims := make(map[string]interface{})
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(b, &claims) != nil {
+		return nil, ErrorOidcBadToken
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != c.Issuer {
+		return nil, fmt.Errorf("%s: bad issuer", ErrorOidcBadToken)
+	}
+	if !containsString(claimStrings(claims["aud"]), c.ClientId) {
+		return nil, fmt.Errorf("%s: bad audience", ErrorOidcBadToken)
+	}
+	// A minute of clock skew is allowed.
+	exp, _ := claims["exp"].(float64)
+	if now.Add(-time.Minute).Unix() > int64(exp) {
+		return nil, fmt.Errorf("%s: expired", ErrorOidcBadToken)
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("%s: bad nonce", ErrorOidcBadToken)
+	}
+	return claims, nil
+}
+
+// UserOf gets login name, show name and role names from claims.
+func (c *OidcConfig) UserOf(claims map[string]interface{}) (string, string, []string, error) {
+	name, _ := claims[c.UserClaim].(string)
+	if name == "" {
+		return "", "", nil, ErrorOidcNoUser
+	}
+	showName, _ := claims[c.NameClaim].(string)
+	roles := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, v := range claimStrings(claims[c.RolesClaim]) {
+		role, ok := c.RoleMap[v]
+		if ok && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 && c.DefaultRole != "" {
+		roles = append(roles, c.DefaultRole)
+	}
+	return name, showName, roles, nil
+}
+
+// SubjectOf is the account of claims at the issuer, "iss sub". Users are
+// found by it, since unlike UserClaim it is never reused or changed.
+func (c *OidcConfig) SubjectOf(claims map[string]interface{}) (string, error) {
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return "", ErrorOidcNoSubject
+	}
+	return c.Issuer + " " + sub, nil
+}
+
+// claimStrings reads a claim which may be a string or a list of them.
+func claimStrings(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		r := make([]string, 0, len(t))
+		for _, s := range t {
+			if s, ok := s.(string); ok {
+				r = append(r, s)
+			}
+		}
+		return r
+	}
+	return nil
+}
+
+func containsString(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -13,7 +13,9 @@ type OssClient struct {
 	*oss.Client
 }
 
-func NewOssClient(endpoint string) (*OssClient, error) {
+// NewOssClient connects to endpoint with key and secret,
+// the global aliapi ones are used if key is empty.
+func NewOssClient(endpoint, key, secret string) (*OssClient, error) {
 	if !strings.HasPrefix(
 		"http://",
 		strings.ToLower(endpoint),
@@ -21,13 +23,14 @@ func NewOssClient(endpoint string) (*OssClient, error) {
 		endpoint = fmt.Sprintf("http://%s", endpoint)
 	}
 
+	if key == "" {
+		key = beego.AppConfig.String("aliapi::apikey")
+		secret = beego.AppConfig.String("aliapi::secret")
+	}
+
 	var err error
 	o := new(OssClient)
-	o.Client, err = oss.New(
-		endpoint,
-		beego.AppConfig.String("aliapi::apikey"),
-		beego.AppConfig.String("aliapi::secret"),
-	)
+	o.Client, err = oss.New(endpoint, key, secret)
 	return o, err
 }
 
@@ -2,11 +2,56 @@ package common
 
 import (
 	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/base64"
 	"strings"
+
+	"github.com/astaxie/beego"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func EncryptPassword(password string) string {
+// EncryptPassword hashes password with bcrypt, which has its own salt.
+// Cost is security::bcryptcost, bcrypt.DefaultCost if not set.
+func EncryptPassword(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost())
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// VerifyPassword compares password with hash in constant time.
+// rehash is true if hash should be replaced with EncryptPassword(password),
+// i.e. it is a legacy SHA1 hash or its bcrypt cost is lower than configured.
+func VerifyPassword(hash, password string) (ok, rehash bool) {
+	if !IsLegacyPassword(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err != nil {
+			return false, false
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return true, err != nil || cost < passwordCost()
+	}
+	ok = subtle.ConstantTimeCompare(
+		[]byte(legacyPassword(password)),
+		[]byte(hash),
+	) == 1
+	return ok, ok
+}
+
+// IsLegacyPassword tells unsalted SHA1 hashes from bcrypt ones,
+// which always start with "$2".
+func IsLegacyPassword(hash string) bool {
+	return !strings.HasPrefix(hash, "$2")
+}
+
+func passwordCost() int {
+	return beego.AppConfig.DefaultInt("security::bcryptcost", bcrypt.DefaultCost)
+}
+
+// legacyPassword is how passwords were hashed before bcrypt,
+// only used to verify and rehash them.
+func legacyPassword(password string) string {
 	hash := sha1.New()
 	b := strings.NewReader(password)
 	b.WriteTo(hash)
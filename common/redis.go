@@ -2,16 +2,22 @@ package common
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/cache"
 	_ "github.com/astaxie/beego/cache/redis"
+	"github.com/garyburd/redigo/redis"
 )
 
 const DefaultRedisKey = "ModuleAB"
 
 var DefaultRedisClient cache.Cache
 
+// RedisPool is for what cache.Cache cannot do, e.g. SET NX.
+// Keys should be prefixed with RedisKey().
+var RedisPool *redis.Pool
+
 func init() {
 	var err error
 	redisConf := make(map[string]string)
@@ -23,4 +29,28 @@ func init() {
 	if err != nil {
 		beego.Alert("Connect to redis failed:", err)
 	}
+
+	RedisPool = &redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 180 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("tcp", beego.AppConfig.String("redis::host"))
+			if err != nil {
+				return nil, err
+			}
+			if password := beego.AppConfig.String("redis::password"); password != "" {
+				_, err = c.Do("AUTH", password)
+				if err != nil {
+					c.Close()
+					return nil, err
+				}
+			}
+			return c, nil
+		},
+	}
+}
+
+// RedisKey prefixes name with redis::key like DefaultRedisClient does.
+func RedisKey(name string) string {
+	return beego.AppConfig.DefaultString("redis::key", DefaultRedisKey) + ":" + name
 }
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP of RFC 6238 with what authenticator apps expect by default:
+// SHA1, 6 digits and 30 seconds period.
+const (
+	TotpPeriod = 30
+	TotpDigits = 6
+	// Codes of steps next to now are accepted too, for clock drift.
+	TotpSkew = 1
+)
+
+// NewTotpSecret is 160 bits of random, in base32 without padding.
+func NewTotpSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+func TotpStep(t time.Time) int64 {
+	return t.Unix() / TotpPeriod
+}
+
+// TotpCode is the code of secret at step.
+func TotpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(
+		strings.ToUpper(strings.TrimRight(secret, "=")),
+	)
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
+
+// VerifyTotp returns the step code matches, or 0 if it matches none
+// around t. Steps not after lastStep are refused, so a code can't be
+// used twice.
+func VerifyTotp(secret, code string, t time.Time, lastStep int64) int64 {
+	code = strings.TrimSpace(code)
+	if len(code) != TotpDigits {
+		return 0
+	}
+	now := TotpStep(t)
+	for step := now - TotpSkew; step <= now+TotpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expect, err := TotpCode(secret, step)
+		if err != nil {
+			return 0
+		}
+		if subtle.ConstantTimeCompare([]byte(expect), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return 0
+}
+
+// TotpURI is what authenticator apps scan from a QR code.
+func TotpURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(TotpDigits))
+	q.Set("period", fmt.Sprint(TotpPeriod))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"moduleab_server/models"
+	"net/http"
+
+	"github.com/astaxie/beego"
+)
+
+type AccessTokensController struct {
+	beego.Controller
+}
+
+// @Title createAccessToken
+// @Description create an access token of yourself, token is only shown this time
+// @Param	token	body	models.AccessTokens	true	"name, scopes and expires_time"
+// @Success 201
+// @router / [post]
+func (h *AccessTokensController) Post() {
+	token := new(models.AccessTokens)
+	defer h.ServeJSON()
+	err := json.Unmarshal(h.Ctx.Input.RequestBody, token)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	token.User = &models.Users{Id: h.GetSession("id").(string)}
+	raw, err := models.AddAccessToken(token)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Failed to add new access token",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	beego.Debug("[C] Got id:", token.Id)
+	h.Data["json"] = map[string]interface{}{
+		"id":           token.Id,
+		"token":        raw,
+		"scopes":       token.Scopes,
+		"expires_time": token.ExpiresTime,
+	}
+	h.Ctx.Output.SetStatus(http.StatusCreated)
+}
+
+// @Title listAccessTokens
+// @Description list access tokens of yourself, admins can give user id
+// @Param	user	query	string	false	"user id, only for admins"
+// @Success 200 {object} []models.AccessTokens
+// @router / [get]
+func (h *AccessTokensController) GetAll() {
+	limit, _ := h.GetInt("limit", 0)
+	index, _ := h.GetInt("index", 0)
+
+	defer h.ServeJSON()
+
+	id := h.GetSession("id").(string)
+	if user := h.GetString("user"); user != "" && IsAdmin(id) {
+		id = user
+	}
+	tokens, err := models.GetAccessTokens(&models.AccessTokens{
+		User: &models.Users{Id: id},
+	}, limit, index)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	h.Data["json"] = tokens
+	if len(tokens) == 0 {
+		beego.Debug("[C] Got nothing")
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title revokeAccessToken
+// @Description revoke an access token, admins can revoke anyone's
+// @Success 204
+// @Failure 404
+// @router /:id [delete]
+func (h *AccessTokensController) Delete() {
+	id := h.GetString(":id")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	tokens, err := models.GetAccessTokens(&models.AccessTokens{Id: id}, 1, 0)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	userId := h.GetSession("id").(string)
+	if len(tokens) == 0 ||
+		(tokens[0].User.Id != userId && !IsAdmin(userId)) {
+		beego.Debug("[C] Got nothing with id:", id)
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	err = models.RevokeAccessToken(tokens[0])
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to revoke with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	h.Ctx.Output.SetStatus(http.StatusNoContent)
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"fmt"
+	"moduleab_server/models"
+	"net/http"
+
+	"github.com/astaxie/beego"
+)
+
+type AuthEventsController struct {
+	beego.Controller
+}
+
+// @Title listAuthEvents
+// @Description list auth events, newest first
+// @Param	name	query	string	false	"login name"
+// @Param	ip	query	string	false	"client ip"
+// @Param	type	query	string	false	"login_success, login_failure, locked or unlocked"
+// @Success 200 {object} []models.AuthEvents
+// @router / [get]
+func (h *AuthEventsController) GetAll() {
+	limit, _ := h.GetInt("limit", 0)
+	index, _ := h.GetInt("index", 0)
+
+	defer h.ServeJSON()
+
+	event := &models.AuthEvents{
+		UserName: h.GetString("name"),
+		Ip:       h.GetString("ip"),
+		Type:     h.GetString("type"),
+	}
+	events, err := models.GetAuthEvents(event, limit, index)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	h.Data["json"] = events
+	if len(events) == 0 {
+		beego.Debug("[C] Got nothing")
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
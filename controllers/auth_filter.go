@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"moduleab_server/common"
+	"moduleab_server/models"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+)
+
+const (
+	PrincipalHost = "host"
+	PrincipalUser = "user"
+)
+
+// Data key of the principal of a request.
+const PrincipalKey = "Principal"
+
+// Principal is who a request is from, AuthFilter puts it into ctx.
+type Principal struct {
+	Type string
+	// Id of host or user, both Id and Name of a host
+	// signing with the shared loginkey are empty.
+	Id   string
+	Name string
+	// Token the user is authenticated with, nil for a session.
+	Token *models.AccessTokens
+}
+
+// PublicPatterns are URLs anyone can request without login.
+var PublicPatterns = []*regexp.Regexp{
+	regexp.MustCompile("^/api/v1/auth(/|$)"),
+	regexp.MustCompile("^/api/v1/version(/|$)"),
+	regexp.MustCompile("^/api/v1/enroll(/|$)"),
+}
+
+// HostPatterns are the only requests hosts can make, "METHOD URL",
+// which is what agents need. Everything else is refused.
+var HostPatterns = []*regexp.Regexp{
+	regexp.MustCompile("^[A-Z]+ /api/v1/client/"),
+	regexp.MustCompile("^POST /api/v1/records/?$"),
+}
+
+// AgentPatterns are URLs only hosts can request, since they hand out
+// storage credentials and signals of a host.
+var AgentPatterns = []*regexp.Regexp{
+	regexp.MustCompile("^/api/v1/client/"),
+}
+
+// UserPatterns are URLs of AgentPatterns for users, not hosts.
+var UserPatterns = []*regexp.Regexp{
+	regexp.MustCompile("^/api/v1/client/config/status$"),
+	regexp.MustCompile("^/api/v1/client/signal/[^/]+/[^/]+/requeue$"),
+}
+
+// clientHostPattern finds the host name in URLs of agents.
+var clientHostPattern = regexp.MustCompile("^/api/v1/client/(?:config|token|signal|run)/([^/]+)")
+
+// SessionPatterns are URLs users can only request with a session,
+// so a leaked token cannot make more of itself.
+var SessionPatterns = []*regexp.Regexp{
+	regexp.MustCompile("^/api/v1/accessTokens(/|$)"),
+	regexp.MustCompile("^/api/v1/users/[^/]+/profile$"),
+}
+
+// Lookups of AuthFilter which need database, replaced in tests.
+var (
+	AccessTokenResolver = models.AuthenticateAccessToken
+	PermissionChecker   = models.HasPermission
+)
+
+func matchAny(patterns []*regexp.Regexp, url string) bool {
+	for _, v := range patterns {
+		if v.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// BearerToken is the token in "Authorization: Bearer <token>".
+func BearerToken(ctx *context.Context) string {
+	auth := ctx.Input.Header("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// Authenticate finds out who ctx is from, by signature of hosts, bearer
+// token or session of users, in this order. It returns nil and no error
+// for anonymous requests. The result is kept in ctx, so it's only
+// done once for a request.
+func Authenticate(ctx *context.Context) (*Principal, error) {
+	if p, ok := ctx.Input.GetData(PrincipalKey).(*Principal); ok {
+		return p, nil
+	}
+	var p *Principal
+	if ctx.Input.Header("Signature") != "" {
+		err := common.AuthWithKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		id, name := common.AuthedHost(ctx)
+		p = &Principal{Type: PrincipalHost, Id: id, Name: name}
+	} else if token := BearerToken(ctx); token != "" {
+		t, err := AccessTokenResolver(token)
+		if err != nil {
+			return nil, err
+		}
+		p = &Principal{Type: PrincipalUser, Id: t.User.Id, Token: t}
+	} else {
+		if ctx.Input.CruSession == nil {
+			return nil, nil
+		}
+		id, _ := ctx.Input.Session("id").(string)
+		if id == "" {
+			return nil, nil
+		}
+		p = &Principal{Type: PrincipalUser, Id: id}
+	}
+	ctx.Input.SetData(PrincipalKey, p)
+	return p, nil
+}
+
+// CurrentUserId is the user a request is from, empty for hosts
+// and anonymous requests.
+func CurrentUserId(ctx *context.Context) string {
+	p, err := Authenticate(ctx)
+	if err != nil || p == nil || p.Type != PrincipalUser {
+		return ""
+	}
+	return p.Id
+}
+
+func abortWithError(ctx *context.Context, status int, err string) {
+	ctx.Output.SetStatus(status)
+	ctx.Output.JSON(map[string]string{
+		"error": err,
+	}, false, false)
+}
+
+// AuthFilter authenticates and authorizes every API before its
+// controller runs. Failed requests are answered here, and beego stops
+// a request once a filter has written the response.
+//
+// Users need the permission of RequiredPermission. Hosts can only make
+// requests of HostPatterns, for themselves: the host name in URLs of
+// agents must be theirs, and what else a host may touch depends on the
+// data, e.g. records of its own, which is checked by controllers.
+func AuthFilter(ctx *context.Context) {
+	url := ctx.Input.URL()
+	if matchAny(PublicPatterns, url) {
+		return
+	}
+	p, err := Authenticate(ctx)
+	if err != nil {
+		beego.Debug("[C] Authentication failed:", err)
+		status := http.StatusUnauthorized
+		if ctx.Input.Header("Signature") != "" {
+			status = http.StatusForbidden
+		}
+		abortWithError(ctx, status, err.Error())
+		return
+	}
+	if p == nil {
+		abortWithError(ctx, http.StatusUnauthorized, "You need login first.")
+		return
+	}
+	if p.Type == PrincipalHost {
+		if !matchAny(HostPatterns, ctx.Input.Method()+" "+url) || matchAny(UserPatterns, url) {
+			abortWithError(ctx, http.StatusForbidden, "No privileges.")
+			return
+		}
+		// Hosts signing with the shared loginkey are nobody in particular.
+		if m := clientHostPattern.FindStringSubmatch(url); m != nil && p.Id != "" && m[1] != p.Name {
+			abortWithError(ctx, http.StatusForbidden, common.ErrorHostNotMatch.Error())
+		}
+		return
+	}
+	if matchAny(AgentPatterns, url) && !matchAny(UserPatterns, url) {
+		abortWithError(ctx, http.StatusForbidden, "Only hosts can request this.")
+		return
+	}
+	if p.Token != nil && matchAny(SessionPatterns, url) {
+		abortWithError(ctx, http.StatusForbidden, "Access tokens are not allowed here.")
+		return
+	}
+	if !CheckPrivileges(p, ctx) {
+		abortWithError(ctx, http.StatusForbidden, "No privileges.")
+	}
+}
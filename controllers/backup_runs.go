@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"fmt"
+	"moduleab_server/models"
+	"net/http"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+type BackupRunsController struct {
+	beego.Controller
+}
+
+// @Title listBackupRuns
+// @Description every backup attempt of agents, newest first
+// @Param	host	query	string	false	"host name"
+// @Param	path	query	string	false	"path"
+// @Param	job	query	string	false	"client job id"
+// @Param	status	query	string	false	"running, succeeded or failed"
+// @Param	since	query	string	false	"started since, RFC3339"
+// @Param	until	query	string	false	"started until, RFC3339"
+// @Success 200 {object} []models.BackupRuns
+// @router / [get]
+func (h *BackupRunsController) GetAll() {
+	limit, _ := h.GetInt("limit", 50)
+	index, _ := h.GetInt("index", 0)
+	since, _ := time.Parse(time.RFC3339, h.GetString("since"))
+	until, _ := time.Parse(time.RFC3339, h.GetString("until"))
+
+	defer h.ServeJSON()
+	scope, ok := getScope(&h.Controller)
+	if !ok {
+		return
+	}
+	run := &models.BackupRuns{Status: h.GetString("status")}
+	if job := h.GetString("job"); job != "" {
+		run.Job = &models.ClientJobs{Id: job}
+	}
+	if name := h.GetString("host"); name != "" {
+		hosts, err := models.GetHostsInScope(scope, &models.Hosts{Name: name}, 1, 0)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(hosts) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		run.Host = hosts[0]
+	}
+	if path := h.GetString("path"); path != "" {
+		paths, err := models.GetPathsInScope(scope, &models.Paths{Path: path}, 1, 0)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with path:", path),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(paths) == 0 {
+			beego.Debug("[C] Got nothing with path:", path)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		run.Path = paths[0]
+	}
+	runs, err := models.GetBackupRunsInScope(scope, run, limit, index, since, until)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	h.Data["json"] = runs
+	if len(runs) == 0 {
+		beego.Debug("[C] Got nothing")
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title getBackupRun
+// @Success 200 {object} models.BackupRuns
+// @Failure 404
+// @router /:id [get]
+func (h *BackupRunsController) Get() {
+	id := h.GetString(":id")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	scope, ok := getScope(&h.Controller)
+	if !ok {
+		return
+	}
+	runs, err := models.GetBackupRunsInScope(scope, &models.BackupRuns{Id: id}, 1, 0)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(runs) == 0 {
+		beego.Debug("[C] Got nothing with id:", id)
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	h.Data["json"] = runs[0]
+	h.Ctx.Output.SetStatus(http.StatusOK)
+}
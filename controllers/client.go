@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"moduleab_server/common"
 	"moduleab_server/models"
+	"moduleab_server/notify"
+	"moduleab_server/protocol"
 	"net/http"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/astaxie/beego"
@@ -17,87 +17,153 @@ import (
 const (
 	ClientWebSocketReplyGot  = "GOT"
 	ClientWebSocketReplyDone = "DONE"
+	ClientWebSocketReplyFail = "FAIL"
 	ClientWebSocketReplyBye  = "BYE"
 )
 
-const (
-	ClientRunStatusAll = iota
-	ClientRunStatusRunning
-	ClientRunStatusStopped
-)
-
-type ClientStatusMsg struct {
-	HostId string
-	Status int
-}
-
-var (
-	ClientStatus     map[string]int
-	ChanClientStatus chan ClientStatusMsg
-)
-
-func init() {
-	ClientStatus = make(map[string]int)
-	ChanClientStatus = make(chan ClientStatusMsg, 2<<10)
-	go clientStatus()
-	AddPrivilege("GET", "^/api/v1/client/signal/(.+)/ws$", models.RoleFlagNone)
-}
-
-func clientStatus() {
-	for {
-		select {
-		case s := <-ChanClientStatus:
-			ClientStatus[s.HostId] = s.Status
-		}
-	}
-}
-
 type ClientController struct {
 	beego.Controller
 }
 
-func (c *ClientController) Prepare() {
-	if c.Ctx.Input.Header("Signature") != "" {
-		err := common.AuthWithKey(c.Ctx)
+// @Title getClientConf
+// @Description Hot storage of every backup set the host belongs to, use token API for credentials.
+// @Param	name		path 	string	true		"host name"
+// @Success 200
+// @Failure 404 host not found
+// @router /config/:name [get]
+func (c *ClientController) GetConfig() {
+	name := c.GetString(":name")
+	defer c.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		host := &models.Hosts{
+			Name: name,
+		}
+		hosts, err := models.GetHosts(host, 1, 0)
 		if err != nil {
 			c.Data["json"] = map[string]string{
-				"error": err.Error(),
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
 			}
-			c.Ctx.Output.SetStatus(http.StatusForbidden)
-			c.ServeJSON()
+			beego.Warn("[C] Got error:", err)
+			c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
 		}
-	} else {
-		id := c.GetSession("id")
-		if id == nil {
-			c.Data["json"] = map[string]string{
-				"error": "You need login first.",
+		if len(hosts) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			c.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+
+		configs := make(map[string]map[string]interface{})
+		for _, p := range hosts[0].Paths {
+			if p.BackupSet == nil {
+				continue
 			}
-			c.Ctx.Output.SetStatus(http.StatusUnauthorized)
-			c.ServeJSON()
-		} else {
-			if !CheckPrivileges(id.(string), c.Ctx) {
+			if _, ok := configs[p.BackupSet.Id]; ok {
+				continue
+			}
+			sets, err := models.GetBackupSets(
+				&models.BackupSets{Id: p.BackupSet.Id}, 1, 0,
+			)
+			if err != nil {
+				c.Data["json"] = map[string]string{
+					"message": fmt.Sprint("Failed to get backup set:", p.BackupSet.Id),
+					"error":   err.Error(),
+				}
+				beego.Warn("[C] Got error:", err)
+				c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+				return
+			}
+			if len(sets) == 0 || sets[0].Oss == nil {
+				continue
+			}
+			conf, err := sets[0].Oss.StorageConfig()
+			if err != nil {
 				c.Data["json"] = map[string]string{
-					"error": "No privileges.",
+					"message": fmt.Sprint("Bad hot storage config of:", sets[0].Name),
+					"error":   err.Error(),
 				}
-				c.Ctx.Output.SetStatus(http.StatusForbidden)
-				c.ServeJSON()
+				beego.Warn("[C] Got error:", err)
+				c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+				return
+			}
+			configs[sets[0].Id] = map[string]interface{}{
+				"backup_set": sets[0].Name,
+				"storage":    conf.Type,
+				"endpoint":   conf.Endpoint,
+				"bucket":     conf.Bucket,
+				"region":     conf.Region,
+				"path_style": conf.PathStyle,
 			}
 		}
+		r := make([]map[string]interface{}, 0, len(configs))
+		for _, v := range configs {
+			r = append(r, v)
+		}
+		c.Data["json"] = r
+		c.Ctx.Output.SetStatus(http.StatusOK)
 	}
 }
 
-// @Title getClientConf
-// @Description getClientConf
-// @Param	body		body 	models.Hosts	true		"body for host content"
-// @Success 200
-// @Failure 403 body is empty
-// @router /config [get]
-func (c *ClientController) GetAliConfig() {
-	c.Data["json"] = map[string]string{
-		"ali_key":    beego.AppConfig.String("aliapi::apikey"),
-		"ali_secret": beego.AppConfig.String("aliapi::secret"),
+// @Title getClientToken
+// @Description Temporary credentials only allowed to access AppSet/Host/Path of the host.
+// @Param	name		path 	string	true		"host name"
+// @Param	path		query 	string	false		"only this path of host"
+// @Param	file		query 	string	false		"filename to presign urls for, needed if storage has no STS"
+// @Success 200 {object} []models.StorageToken
+// @Failure 404 host not found
+// @router /token/:name [get]
+func (c *ClientController) GetToken() {
+	name := c.GetString(":name")
+	path := c.GetString("path")
+	file := c.GetString("file")
+	defer c.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		host := &models.Hosts{
+			Name: name,
+		}
+		hosts, err := models.GetHosts(host, 1, 0)
+		if err != nil {
+			c.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(hosts) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			c.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+
+		tokens, err := models.IssueStorageTokens(hosts[0], path, file)
+		if err != nil {
+			c.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to issue token for:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			switch err {
+			case models.ErrorHostNoAppSet, models.ErrorTokenNoFilename,
+				models.ErrorTokenBadFilename:
+				c.Ctx.Output.SetStatus(http.StatusBadRequest)
+			default:
+				c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			}
+			return
+		}
+		c.Data["json"] = tokens
+		if len(tokens) == 0 {
+			beego.Debug("[C] Got nothing with path:", path)
+			c.Ctx.Output.SetStatus(http.StatusNotFound)
+		} else {
+			c.Ctx.Output.SetStatus(http.StatusOK)
+		}
 	}
-	c.ServeJSON()
 }
 
 // @Title getSignalsWs
@@ -127,103 +193,101 @@ func (c *ClientController) WebSocket() {
 			return
 		}
 		HostId := hosts[0].Id
+		remoteIp := common.ClientIP(c.Ctx)
 
-		ws, err := websocket.Upgrade(
-			c.Ctx.ResponseWriter, c.Ctx.Request,
-			nil, 1024, 1024)
+		upgrader := websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			Subprotocols:    protocol.Subprotocols(),
+		}
+		ws, err := upgrader.Upgrade(c.Ctx.ResponseWriter, c.Ctx.Request, nil)
 		if err != nil {
-			c.Data["json"] = map[string]string{
-				"message": "Failed on upgrading to websocket",
-				"error":   err.Error(),
-			}
+			// Upgrader has answered the request.
 			beego.Warn("[C] Got error:", err)
-			c.Ctx.Output.SetStatus(http.StatusInternalServerError)
-			c.ServeJSON()
 			return
 		}
 		defer ws.Close()
+		a := newAgentConn(ws, HostId, name)
+		presence := models.NewPresence(hosts[0], remoteIp, time.Now())
+		if ws.Subprotocol() != "" {
+			_, a.codec, _ = protocol.ParseSubprotocol(ws.Subprotocol())
+			err = a.handshake()
+			if err != nil {
+				beego.Warn("Handshake of host", name, "failed:", err)
+				return
+			}
+			presence.Protocol = ws.Subprotocol()
+			presence.AgentVersion = a.hello.Agent
+			presence.Os = a.hello.Os
+			presence.Capabilities = a.hello.Capabilities
+		}
+		a.connectionId = presence.ConnectionId
+		err = models.SavePresence(presence)
+		if err != nil {
+			beego.Warn("Got error on saving presence:", err)
+		}
+		defer func() {
+			err := models.PresenceDisconnected(HostId, presence.ConnectionId)
+			if err != nil {
+				beego.Warn("Got error on saving presence:", err)
+			}
+			models.Notify(models.EventAgentDisconnected, notify.SeverityWarning,
+				"Agent disconnected", fmt.Sprint("Agent of ", name, " is offline."),
+				map[string]string{
+					"host":      name,
+					"remote_ip": remoteIp,
+					"server":    presence.Server,
+				})
+		}()
 
 		tick := beego.AppConfig.DefaultInt64("websocket::pingperiod", 5)
 		ticker := time.NewTicker(
 			time.Duration(tick) * time.Second)
 		defer ticker.Stop()
 
-		timeout := beego.AppConfig.DefaultInt64("websocket::timeout", 10)
-		ws.SetReadDeadline(time.Now().Add(
-			time.Duration(timeout) * time.Second),
-		)
-		ws.SetWriteDeadline(time.Now().Add(
-			time.Duration(timeout) * time.Second),
-		)
-
-		var runningStatus = ClientStatusMsg{
-			HostId: HostId,
-			Status: ClientRunStatusStopped,
-		}
 		ws.SetPongHandler(func(string) error {
 			beego.Debug("Host:", name, "is still alive.")
-			ws.SetReadDeadline(time.Now().Add(
-				time.Duration(timeout) * time.Second),
-			)
-			ws.SetWriteDeadline(time.Now().Add(
-				time.Duration(timeout) * time.Second),
-			)
-
-			runningStatus.Status = ClientRunStatusRunning
-			ChanClientStatus <- runningStatus
-
+			a.heartbeat()
 			return nil
 		})
 
-		defer func() {
-			runningStatus.Status = ClientRunStatusStopped
-			ChanClientStatus <- runningStatus
-		}()
+		wake, unsubscribe := models.SubscribeSignals(HostId)
+		defer unsubscribe()
 
-		var c chan models.Signal
-		c, ok := models.SignalChannels[HostId]
-		if !ok {
-			c = make(chan models.Signal, 1024)
-			models.SignalChannels[HostId] = c
-		}
-
-		// Start read routine
-		go func() {
-			defer ws.Close()
-			for {
-				_, bConfirm, err := ws.ReadMessage()
-				if websocket.IsCloseError(err,
-					websocket.CloseGoingAway) {
-					beego.Info("Host", name, "is offline.")
-					return
-				} else if err != nil {
-					beego.Warn("Error on reading:", err.Error())
-					return
-				}
-				s := strings.Split(string(bConfirm), " ")
-				if s[0] == ClientWebSocketReplyDone {
-					models.DeleteSignal(HostId, s[1])
-				}
-			}
-		}()
+		go a.read()
 
+		// Signals not acked in time are taken again at the next ping.
+		if a.deliver() != nil {
+			return
+		}
 		for {
 			select {
-			case s := <-models.SignalChannels[HostId]:
-				ws.WriteJSON(s)
+			case <-wake:
+				err := a.deliver()
+				if err != nil {
+					beego.Warn("Got error on sending signals", err.Error())
+					return
+				}
 			case <-ticker.C:
 				beego.Debug("Websocket ping:", name)
-				err := ws.WriteMessage(websocket.PingMessage, []byte{})
+				err := a.write(websocket.PingMessage, []byte{})
 				if err != nil {
 					beego.Warn("Got error on ping", err.Error())
 					return
 				}
+				err = a.deliver()
+				if err != nil {
+					beego.Warn("Got error on sending signals", err.Error())
+					return
+				}
 			}
 		}
 	}
 }
 
 // @Title getSignals
+// @Description signals not acked yet, or with status, e.g. dead
+// @Param	status	query	string	false	"pending, delivered, acked or dead"
 // @router /signal/:name [get]
 func (c *ClientController) GetSignals() {
 	name := c.GetString(":name")
@@ -248,7 +312,17 @@ func (c *ClientController) GetSignals() {
 			c.Ctx.Output.SetStatus(http.StatusNotFound)
 			return
 		}
-		c.Data["json"] = models.GetSignals(hosts[0].Id)
+		signals, err := models.GetSignals(hosts[0].Id, c.GetString("status"))
+		if err != nil {
+			c.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get signals of:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Data["json"] = signals
 		c.Ctx.Output.SetStatus(http.StatusOK)
 	}
 }
@@ -280,12 +354,20 @@ func (c *ClientController) GetSignal() {
 			return
 		}
 		signal, err := models.GetSignal(hosts[0].Id, id)
-		if err != nil {
+		if err == models.ErrorSignalNotFound {
 			c.Data["json"] = map[string]string{
 				"message": fmt.Sprint("Got nothing with id:", id),
 			}
 			c.Ctx.Output.SetStatus(http.StatusNotFound)
 			return
+		} else if err != nil {
+			c.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with id:", id),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
 		}
 
 		c.Data["json"] = signal
@@ -347,7 +429,9 @@ func (c *ClientController) PostSignal() {
 	}
 }
 
-// @Title deleteSignal
+// @Title ackSignal
+// @Description agent is done with signal, it is never delivered again
+// @Success 204
 // @router /signal/:name/:id [delete]
 func (c *ClientController) DeleteSignal() {
 	name := c.GetString(":name")
@@ -373,11 +457,11 @@ func (c *ClientController) DeleteSignal() {
 			c.Ctx.Output.SetStatus(http.StatusNotFound)
 			return
 		}
-		err = models.DeleteSignal(hosts[0].Id, id)
+		err = models.AckSignal(hosts[0].Id, id)
 		if err != nil {
 			beego.Warn("[C] Got error:", err)
 			c.Data["json"] = map[string]string{
-				"message": "Delete failed",
+				"message": "Ack failed",
 				"error":   err.Error(),
 			}
 			if err == models.ErrorSignalNotFound {
@@ -387,11 +471,115 @@ func (c *ClientController) DeleteSignal() {
 			}
 			return
 		}
+		c.Ctx.Output.SetStatus(http.StatusNoContent)
+	}
+}
 
-		c.Data["json"] = map[string]string{
-			"message": fmt.Sprint("Got nothing with id:", id),
+// @Title nackSignal
+// @Description agent failed with signal, it is delivered again unless out of attempts
+// @Param	body	body	object	false	"{"error": "reason"}"
+// @Success 204
+// @router /signal/:name/:id/nack [post]
+func (c *ClientController) NackSignal() {
+	name := c.GetString(":name")
+	id := c.GetString(":id")
+	defer c.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		host := &models.Hosts{
+			Name: name,
 		}
-		c.Ctx.Output.SetStatus(http.StatusNotFound)
+		hosts, err := models.GetHosts(host, 0, 0)
+		if err != nil {
+			c.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(hosts) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			c.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		var reason map[string]string
+		json.Unmarshal(c.Ctx.Input.RequestBody, &reason)
+		err = models.NackSignal(hosts[0].Id, id, reason["error"])
+		if err != nil {
+			beego.Warn("[C] Got error:", err)
+			c.Data["json"] = map[string]string{
+				"message": "Nack failed",
+				"error":   err.Error(),
+			}
+			if err == models.ErrorSignalNotFound {
+				c.Ctx.Output.SetStatus(http.StatusNotFound)
+			} else {
+				c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			}
+			return
+		}
+		c.Ctx.Output.SetStatus(http.StatusNoContent)
+	}
+}
+
+// @Title requeueSignal
+// @Description deliver a dead signal again, with all its attempts
+// @Success 204
+// @Failure 409 signal is not dead
+// @router /signal/:name/:id/requeue [post]
+func (c *ClientController) RequeueSignal() {
+	name := c.GetString(":name")
+	id := c.GetString(":id")
+	defer c.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		host := &models.Hosts{
+			Name: name,
+		}
+		hosts, err := models.GetHosts(host, 0, 0)
+		if err != nil {
+			c.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(hosts) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			c.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		scope, ok := getScope(&c.Controller)
+		if !ok {
+			return
+		}
+		if !scope.AllowsHost(hosts[0]) {
+			outOfScope(&c.Controller)
+			return
+		}
+		err = models.RequeueSignal(hosts[0].Id, id)
+		if err != nil {
+			beego.Warn("[C] Got error:", err)
+			c.Data["json"] = map[string]string{
+				"message": "Requeue failed",
+				"error":   err.Error(),
+			}
+			switch err {
+			case models.ErrorSignalNotFound:
+				c.Ctx.Output.SetStatus(http.StatusNotFound)
+			case models.ErrorSignalNotDead:
+				c.Ctx.Output.SetStatus(http.StatusConflict)
+			default:
+				c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			}
+			return
+		}
+		models.NotifySignal(hosts[0].Id, id)
+		c.Ctx.Output.SetStatus(http.StatusNoContent)
 	}
 }
 
@@ -441,13 +629,202 @@ func (c *ClientController) NotifySignal() {
 }
 
 // @Title getClientStatus
+// @Description presence of agents of all hosts in scope, and a summary
+// @Success 200 {object} models.FleetStatus
 // @router /config/status [get]
 func (c *ClientController) GetStatus() {
 	defer c.ServeJSON()
-	var lock = new(sync.Mutex)
-	lock.Lock()
-	defer lock.Unlock()
+	scope, ok := getScope(&c.Controller)
+	if !ok {
+		return
+	}
+	hosts, err := models.GetHostsInScope(scope, &models.Hosts{}, 0, 0)
+	if err != nil {
+		c.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	ids := make([]string, len(hosts))
+	for i, v := range hosts {
+		ids[i] = v.Id
+	}
+	presences, err := models.GetPresences(ids)
+	if err != nil {
+		c.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get presence"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data["json"] = models.SummarizePresence(hosts, presences)
+	c.Ctx.Output.SetStatus(http.StatusOK)
+}
+
+// BackupRunStart is what an agent sends when it starts a backup. Runs
+// of backup signals are started by Signal, others by Path of the host.
+type BackupRunStart struct {
+	Path        string    `json:"path"`
+	Job         string    `json:"job"`
+	Signal      string    `json:"signal"`
+	StartedTime time.Time `json:"started_time"`
+}
 
-	c.Data["json"] = ClientStatus
+// getClientHost gets host of name, which must be the one signing the
+// request if it is a host. It writes the response itself if it
+// returns nil.
+func (c *ClientController) getClientHost(name string) *models.Hosts {
+	if hostId, hostName := common.AuthedHost(c.Ctx); hostId != "" && hostName != name {
+		c.Data["json"] = map[string]string{
+			"error": common.ErrorHostNotMatch.Error(),
+		}
+		c.Ctx.Output.SetStatus(http.StatusForbidden)
+		return nil
+	}
+	hosts, err := models.GetHosts(&models.Hosts{Name: name}, 1, 0)
+	if err != nil {
+		c.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with name:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return nil
+	}
+	if name == "" || len(hosts) == 0 {
+		beego.Debug("[C] Got nothing with name:", name)
+		c.Ctx.Output.SetStatus(http.StatusNotFound)
+		return nil
+	}
+	return hosts[0]
+}
+
+// @Title startBackupRun
+// @Description agent starts a backup, and reports it with the id returned
+// @Param	run	body	controllers.BackupRunStart	true	"signal, or path and job"
+// @Success 201 {object} models.BackupRuns
+// @Failure 404 host or path not found
+// @router /run/:name [post]
+func (c *ClientController) PostRun() {
+	name := c.GetString(":name")
+	defer c.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	host := c.getClientHost(name)
+	if host == nil {
+		return
+	}
+	start := new(BackupRunStart)
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, start)
+	if err == nil && start.Signal == "" && start.Path == "" {
+		err = models.ErrorDispatchNoPath
+	}
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		c.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		c.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+
+	var run *models.BackupRuns
+	if start.Signal != "" {
+		run, err = models.ReportSignalRun(host.Id, start.Signal, &models.BackupReport{})
+		if err == models.ErrorBackupRunFinished {
+			err = nil
+		}
+		if err == nil && run == nil {
+			err = models.ErrorBackupRunNotBackup
+		}
+	} else {
+		run = &models.BackupRuns{
+			Host:        host,
+			StartedTime: start.StartedTime,
+		}
+		for _, v := range host.Paths {
+			if v.Path == start.Path {
+				run.Path = v
+			}
+		}
+		if run.Path == nil {
+			beego.Debug("[C] Host", name, "has no path:", start.Path)
+			c.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		if start.Job != "" {
+			run.Job = &models.ClientJobs{Id: start.Job}
+		}
+		_, err = models.AddBackupRun(run)
+	}
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		c.Data["json"] = map[string]string{
+			"message": "Failed to start backup run",
+			"error":   err.Error(),
+		}
+		switch err {
+		case models.ErrorSignalNotFound:
+			c.Ctx.Output.SetStatus(http.StatusNotFound)
+		case models.ErrorBackupRunNotBackup:
+			c.Ctx.Output.SetStatus(http.StatusBadRequest)
+		default:
+			c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+	c.Data["json"] = run
+	c.Ctx.Output.SetStatus(http.StatusCreated)
+}
+
+// @Title reportBackupRun
+// @Description agent reports progress of a backup, or its result with "ok"
+// @Param	report	body	models.BackupReport	true	"progress, or result if ok is set"
+// @Success 200 {object} models.BackupRuns
+// @Failure 409 run has finished
+// @router /run/:name/:id [put]
+func (c *ClientController) PutRun() {
+	name := c.GetString(":name")
+	id := c.GetString(":id")
+	defer c.ServeJSON()
+	beego.Debug("[C] Got name:", name, "id:", id)
+	host := c.getClientHost(name)
+	if host == nil {
+		return
+	}
+	report := new(models.BackupReport)
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, report)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		c.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		c.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	run, err := models.ReportBackupRun(host.Id, id, report)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		c.Data["json"] = map[string]string{
+			"message": "Failed to report backup run",
+			"error":   err.Error(),
+		}
+		switch err {
+		case models.ErrorBackupRunNotFound:
+			c.Ctx.Output.SetStatus(http.StatusNotFound)
+		case models.ErrorBackupRunFinished:
+			c.Ctx.Output.SetStatus(http.StatusConflict)
+		default:
+			c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+	c.Data["json"] = run
 	c.Ctx.Output.SetStatus(http.StatusOK)
 }
@@ -3,51 +3,16 @@ package controllers
 import (
 	"encoding/json"
 	"fmt"
-	"moduleab_server/common"
 	"moduleab_server/models"
 	"net/http"
 
 	"github.com/astaxie/beego"
 )
 
-func init() {
-	AddPrivilege("GET", "^/api/v1/clientJobs", models.RoleFlagUser)
-}
-
 type ClientJobsController struct {
 	beego.Controller
 }
 
-func (h *ClientJobsController) Prepare() {
-	if h.Ctx.Input.Header("Signature") != "" {
-		err := common.AuthWithKey(h.Ctx)
-		if err != nil {
-			h.Data["json"] = map[string]string{
-				"error": err.Error(),
-			}
-			h.Ctx.Output.SetStatus(http.StatusForbidden)
-			h.ServeJSON()
-		}
-	} else {
-		id := h.GetSession("id")
-		if id == nil {
-			h.Data["json"] = map[string]string{
-				"error": "You need login first.",
-			}
-			h.Ctx.Output.SetStatus(http.StatusUnauthorized)
-			h.ServeJSON()
-		} else {
-			if !CheckPrivileges(id.(string), h.Ctx) {
-				h.Data["json"] = map[string]string{
-					"error": "No privileges.",
-				}
-				h.Ctx.Output.SetStatus(http.StatusForbidden)
-				h.ServeJSON()
-			}
-		}
-	}
-}
-
 // @Title createClientJob
 // @router / [post]
 func (a *ClientJobsController) Post() {
@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"encoding/json"
+	"moduleab_server/models"
+	"moduleab_server/protocol"
+	"moduleab_server/version"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/gorilla/websocket"
+)
+
+// agentConn is websocket of an agent. Frames are written by both the
+// reading and the delivering goroutine, so writes are locked.
+type agentConn struct {
+	ws      *websocket.Conn
+	hostId  string
+	name    string
+	timeout time.Duration
+	// nil for agents without subprotocol, which speak the old text
+	// protocol: signals as JSON, and "DONE <id>" or "FAIL <id> <reason>".
+	codec        protocol.Codec
+	hello        protocol.Hello
+	connectionId string
+	lock         sync.Mutex
+}
+
+func newAgentConn(ws *websocket.Conn, hostId, name string) *agentConn {
+	timeout := beego.AppConfig.DefaultInt64("websocket::timeout", 10)
+	a := &agentConn{
+		ws:      ws,
+		hostId:  hostId,
+		name:    name,
+		timeout: time.Duration(timeout) * time.Second,
+	}
+	a.alive()
+	return a
+}
+
+// alive gives the agent another timeout to say something.
+func (a *agentConn) alive() {
+	a.ws.SetReadDeadline(time.Now().Add(a.timeout))
+}
+
+// heartbeat is called when agent answers ping or sends heartbeat.
+func (a *agentConn) heartbeat() {
+	a.alive()
+	err := models.PresenceHeartbeat(a.hostId, a.connectionId)
+	if err != nil {
+		beego.Warn("Got error on saving presence:", err)
+	}
+}
+
+func (a *agentConn) write(messageType int, b []byte) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.ws.SetWriteDeadline(time.Now().Add(a.timeout))
+	return a.ws.WriteMessage(messageType, b)
+}
+
+func (a *agentConn) send(e *protocol.Envelope) error {
+	e.Time = time.Now().Unix()
+	b, err := a.codec.Encode(e)
+	if err != nil {
+		return err
+	}
+	return a.write(a.codec.MessageType(), b)
+}
+
+func (a *agentConn) sendError(code, message, ref string) error {
+	beego.Debug("Send error to host", a.name, code, message)
+	return a.send(protocol.NewError(code, message, ref))
+}
+
+// handshake waits for hello of agent and answers welcome.
+func (a *agentConn) handshake() error {
+	_, b, err := a.ws.ReadMessage()
+	if err != nil {
+		return err
+	}
+	e, err := a.codec.Decode(b)
+	if err != nil {
+		a.sendError(protocol.ErrorCode(err), err.Error(), "")
+		return err
+	}
+	if e.Type != protocol.TypeHello {
+		a.sendError(protocol.CodeUnexpected, "hello is expected", e.Id)
+		return protocol.ErrorBadFrame
+	}
+	err = e.DecodeBody(&a.hello)
+	if err != nil {
+		a.sendError(protocol.CodeBadFrame, err.Error(), e.Id)
+		return err
+	}
+	beego.Info("Host", a.name, "agent", a.hello.Agent, "capabilities:", a.hello.Capabilities)
+	v, _, _ := protocol.ParseSubprotocol(a.ws.Subprotocol())
+	welcome, err := protocol.NewEnvelope(protocol.TypeWelcome, "", &protocol.Welcome{
+		Version:   v,
+		Encoding:  a.codec.Name(),
+		Server:    version.Version.String(),
+		Heartbeat: beego.AppConfig.DefaultInt64("websocket::pingperiod", 5),
+	})
+	if err != nil {
+		return err
+	}
+	return a.send(welcome)
+}
+
+// deliver sends signals the agent should have now.
+func (a *agentConn) deliver() error {
+	signals, err := models.ReceiveSignals(a.hostId, 0)
+	if err != nil {
+		beego.Warn("Got error on receiving signals:", err)
+		return nil
+	}
+	for _, s := range signals {
+		if a.codec == nil {
+			b, err := json.Marshal(s)
+			if err == nil {
+				err = a.write(websocket.TextMessage, b)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		id, _ := s["id"].(string)
+		e, err := protocol.NewEnvelope(protocol.TypeSignal, id, s)
+		if err == nil {
+			err = a.send(e)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read handles frames from agent until it is gone.
+func (a *agentConn) read() {
+	defer a.ws.Close()
+	for {
+		_, b, err := a.ws.ReadMessage()
+		if websocket.IsCloseError(err,
+			websocket.CloseGoingAway) {
+			beego.Info("Host", a.name, "is offline.")
+			return
+		} else if err != nil {
+			beego.Warn("Error on reading:", err.Error())
+			return
+		}
+		a.alive()
+		if a.codec == nil {
+			a.handleText(string(b))
+			continue
+		}
+		e, err := a.codec.Decode(b)
+		if err != nil {
+			ref := ""
+			if e != nil {
+				ref = e.Id
+			}
+			err = a.sendError(protocol.ErrorCode(err), err.Error(), ref)
+		} else {
+			err = a.handle(e)
+		}
+		if err != nil {
+			beego.Warn("Error on writing:", err.Error())
+			return
+		}
+	}
+}
+
+func (a *agentConn) handleText(reply string) {
+	s := strings.SplitN(reply, " ", 3)
+	if len(s) < 2 {
+		return
+	}
+	var err error
+	switch s[0] {
+	case ClientWebSocketReplyDone:
+		err = models.AckSignal(a.hostId, s[1])
+	case ClientWebSocketReplyFail:
+		reason := ""
+		if len(s) > 2 {
+			reason = s[2]
+		}
+		err = models.NackSignal(a.hostId, s[1], reason)
+	}
+	if err != nil {
+		beego.Warn("Got error on reply:", reply, err)
+	}
+}
+
+// report keeps progress and result of backup signals as backup runs.
+func (a *agentConn) report(ref string, r *models.BackupReport) {
+	_, err := models.ReportSignalRun(a.hostId, ref, r)
+	if err == models.ErrorBackupRunFinished || err == models.ErrorSignalNotFound {
+		beego.Debug("Host", a.name, "report of signal", ref, "ignored:", err)
+	} else if err != nil {
+		beego.Warn("Got error on saving backup run:", ref, err)
+	}
+}
+
+// handle answers a valid frame, only errors of writing are returned.
+func (a *agentConn) handle(e *protocol.Envelope) error {
+	switch e.Type {
+	case protocol.TypeHeartbeat:
+		a.heartbeat()
+		h, _ := protocol.NewEnvelope(protocol.TypeHeartbeat, e.Id, &protocol.Heartbeat{})
+		return a.send(h)
+	case protocol.TypeProgress:
+		var p protocol.Progress
+		e.DecodeBody(&p)
+		beego.Debug("Host", a.name, "signal", e.Ref, "progress:", p.Percent, p.Message)
+		a.report(e.Ref, &models.BackupReport{
+			Percent: p.Percent,
+			Bytes:   p.Bytes,
+			Files:   p.Files,
+			Message: p.Message,
+		})
+	case protocol.TypeResult:
+		var r protocol.Result
+		e.DecodeBody(&r)
+		a.report(e.Ref, &models.BackupReport{
+			Bytes:   r.Bytes,
+			Files:   r.Files,
+			Message: r.Message,
+			Record:  r.Record,
+			Ok:      &r.Ok,
+		})
+		var err error
+		if r.Ok {
+			err = models.AckSignal(a.hostId, e.Ref)
+		} else {
+			err = models.NackSignal(a.hostId, e.Ref, r.Message)
+		}
+		if err == models.ErrorSignalNotFound {
+			return a.sendError(protocol.CodeBadFrame, err.Error(), e.Ref)
+		} else if err != nil {
+			beego.Warn("Got error on result:", e.Ref, err)
+			return a.sendError(protocol.CodeInternal, err.Error(), e.Ref)
+		}
+	case protocol.TypeError:
+		var r protocol.Error
+		e.DecodeBody(&r)
+		beego.Warn("Host", a.name, "got error:", r.Code, r.Message, e.Ref)
+	default:
+		return a.sendError(protocol.CodeUnexpected, e.Type+" is not expected", e.Id)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"moduleab_server/models"
+	"net/http"
+
+	"github.com/astaxie/beego"
+)
+
+type CredentialsController struct {
+	beego.Controller
+}
+
+// @Title createCredential
+// @router / [post]
+func (a *CredentialsController) Post() {
+	credential := new(models.Credentials)
+	defer a.ServeJSON()
+	err := json.Unmarshal(a.Ctx.Input.RequestBody, credential)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		a.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		a.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	beego.Debug("[C] Got data:", credential.Name)
+	id, err := models.AddCredential(credential)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		a.Data["json"] = map[string]string{
+			"message": "Failed to add new credential",
+			"error":   err.Error(),
+		}
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+
+	beego.Debug("[C] Got id:", id)
+	a.Data["json"] = map[string]string{
+		"id": id,
+	}
+	a.Ctx.Output.SetStatus(http.StatusCreated)
+}
+
+// @Title getCredential
+// @router /:name [get]
+func (a *CredentialsController) Get() {
+	name := a.GetString(":name")
+	defer a.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		credential := &models.Credentials{
+			Name: name,
+		}
+		credentials, err := models.GetCredentials(credential, 0, 0)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		a.Data["json"] = credentials
+		if len(credentials) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+		} else {
+			a.Ctx.Output.SetStatus(http.StatusOK)
+		}
+	}
+}
+
+// @Title listCredentials
+// @router / [get]
+func (a *CredentialsController) GetAll() {
+	limit, _ := a.GetInt("limit", 0)
+	index, _ := a.GetInt("index", 0)
+
+	defer a.ServeJSON()
+
+	credential := &models.Credentials{}
+	credentials, err := models.GetCredentials(credential, limit, index)
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	a.Data["json"] = credentials
+	if len(credentials) == 0 {
+		beego.Debug("[C] Got nothing")
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		a.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title deleteCredential
+// @router /:name [delete]
+func (a *CredentialsController) Delete() {
+	name := a.GetString(":name")
+	defer a.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		credential := &models.Credentials{
+			Name: name,
+		}
+		credentials, err := models.GetCredentials(credential, 0, 0)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(credentials) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		err = models.DeleteCredential(credentials[0])
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to delete with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		a.Ctx.Output.SetStatus(http.StatusNoContent)
+	}
+}
+
+// @Title updateCredential
+// @Description Secret is kept if it is empty in body.
+// @router /:name [put]
+func (a *CredentialsController) Put() {
+	name := a.GetString(":name")
+	defer a.ServeJSON()
+	beego.Debug("[C] Got credential name:", name)
+	if name != "" {
+		credential := &models.Credentials{
+			Name: name,
+		}
+		credentials, err := models.GetCredentials(credential, 0, 0)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(credentials) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+
+		err = json.Unmarshal(a.Ctx.Input.RequestBody, credential)
+		credential.Id = credentials[0].Id
+		if err != nil {
+			beego.Warn("[C] Got error:", err)
+			a.Data["json"] = map[string]string{
+				"message": "Bad request",
+				"error":   err.Error(),
+			}
+			a.Ctx.Output.SetStatus(http.StatusBadRequest)
+			return
+		}
+		beego.Debug("[C] Got credential data:", credential.Name)
+		err = models.UpdateCredential(credential)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to update with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		a.Ctx.Output.SetStatus(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"moduleab_server/models"
+	"net/http"
+
+	"github.com/astaxie/beego"
+)
+
+type DispatchesController struct {
+	beego.Controller
+}
+
+// DispatchRequest asks a host to do something now. Backup and cleanup
+// need Path, verify needs Record and goes to host of it, runhook needs Hook.
+// Cleanup without ReservedTime keeps as long as the jobs of host and Path.
+type DispatchRequest struct {
+	Type         string            `json:"type"`
+	Host         string            `json:"host"`
+	Path         string            `json:"path"`
+	Record       string            `json:"record"`
+	Hook         string            `json:"hook"`
+	Args         map[string]string `json:"args"`
+	ReservedTime int               `json:"reserved_time"`
+}
+
+// @Title createDispatch
+// @Description send a backup, verify, cleanup or runhook signal to a host now
+// @Param	dispatch	body	controllers.DispatchRequest	true	"what to do on which host"
+// @Success 201 {object} models.Dispatches
+// @Failure 404 host, path or record not found
+// @router / [post]
+func (d *DispatchesController) Post() {
+	req := new(DispatchRequest)
+	defer d.ServeJSON()
+	err := json.Unmarshal(d.Ctx.Input.RequestBody, req)
+	if err == nil {
+		if _, ok := models.SignalTypeNames[req.Type]; !ok {
+			err = models.ErrorDispatchBadType
+		}
+	}
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		d.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		d.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	beego.Debug("[C] Got data:", req)
+	scope, ok := getScope(&d.Controller)
+	if !ok {
+		return
+	}
+
+	dispatch := &models.Dispatches{
+		Type:      models.SignalTypeNames[req.Type],
+		Trigger:   models.DispatchTriggerManual,
+		CreatedBy: &models.Users{Id: CurrentUserId(d.Ctx)},
+	}
+	var signal models.Signal
+	if dispatch.Type == models.SignalTypeVerify {
+		if req.Record == "" {
+			d.Data["json"] = map[string]string{
+				"message": "Bad request",
+				"error":   "Record is required",
+			}
+			d.Ctx.Output.SetStatus(http.StatusBadRequest)
+			return
+		}
+		records, err := models.GetRecordsInScope(scope,
+			&models.Records{Id: req.Record}, 1, 0,
+			models.OrderAsc, models.OrderAsc)
+		if err != nil {
+			d.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get record:", req.Record),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			d.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(records) == 0 {
+			beego.Debug("[C] Got nothing with record:", req.Record)
+			d.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		dispatch.Record = records[0]
+		dispatch.Path = records[0].Path
+		req.Host = records[0].Host.Name
+		signal = models.MakeVerifySignal(records[0])
+	}
+
+	hosts, err := models.GetHostsInScope(scope, &models.Hosts{Name: req.Host}, 1, 0)
+	if err != nil {
+		d.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with name:", req.Host),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		d.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if req.Host == "" || len(hosts) == 0 {
+		beego.Debug("[C] Got nothing with name:", req.Host)
+		d.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	dispatch.Host = hosts[0]
+
+	switch dispatch.Type {
+	case models.SignalTypeBackup, models.SignalTypeCleanup:
+		if req.Path == "" {
+			err = models.ErrorDispatchNoPath
+			break
+		}
+		for _, v := range hosts[0].Paths {
+			if v.Path == req.Path {
+				dispatch.Path = v
+			}
+		}
+		if dispatch.Path == nil {
+			beego.Debug("[C] Host", req.Host, "has no path:", req.Path)
+			d.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		if dispatch.Type == models.SignalTypeBackup {
+			signal = models.MakeBackupSignal(dispatch.Path, nil)
+			break
+		}
+		if req.ReservedTime < 0 {
+			err = models.ErrorDispatchNoReservedTime
+			break
+		}
+		if req.ReservedTime == 0 {
+			req.ReservedTime, err = models.DefaultReservedTime(dispatch.Host, dispatch.Path)
+			if err != nil {
+				break
+			}
+		}
+		signal = models.MakeCleanupSignal(req.Path, req.ReservedTime)
+	case models.SignalTypeRunHook:
+		if req.Hook == "" {
+			err = models.ErrorDispatchNoHook
+			break
+		}
+		signal = models.MakeRunHookSignal(req.Hook, req.Args)
+	}
+	if err == nil {
+		err = models.Dispatch(dispatch, signal)
+	}
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		d.Data["json"] = map[string]string{
+			"message": "Failed to dispatch",
+			"error":   err.Error(),
+		}
+		switch err {
+		case models.ErrorDispatchNoPath, models.ErrorDispatchNoHook,
+			models.ErrorDispatchNoReservedTime:
+			d.Ctx.Output.SetStatus(http.StatusBadRequest)
+		case models.ErrorDispatchPending:
+			d.Ctx.Output.SetStatus(http.StatusConflict)
+		default:
+			d.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+	d.Data["json"] = dispatch
+	d.Ctx.Output.SetStatus(http.StatusCreated)
+}
+
+// @Title listDispatches
+// @Param	host	query	string	false	"host name"
+// @Param	job	query	string	false	"client job id"
+// @Param	trigger	query	string	false	"schedule or manual"
+// @Success 200 {object} []models.Dispatches
+// @router / [get]
+func (d *DispatchesController) GetAll() {
+	limit, _ := d.GetInt("limit", 0)
+	index, _ := d.GetInt("index", 0)
+
+	defer d.ServeJSON()
+	scope, ok := getScope(&d.Controller)
+	if !ok {
+		return
+	}
+	dispatch := &models.Dispatches{Trigger: d.GetString("trigger")}
+	if job := d.GetString("job"); job != "" {
+		dispatch.Job = &models.ClientJobs{Id: job}
+	}
+	if name := d.GetString("host"); name != "" {
+		hosts, err := models.GetHostsInScope(scope, &models.Hosts{Name: name}, 1, 0)
+		if err != nil {
+			d.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			d.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(hosts) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			d.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		dispatch.Host = hosts[0]
+	}
+	dispatches, err := models.GetDispatchesInScope(scope, dispatch, limit, index)
+	if err != nil {
+		d.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		d.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	d.Data["json"] = dispatches
+	if len(dispatches) == 0 {
+		beego.Debug("[C] Got nothing")
+		d.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		d.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title getDispatch
+// @Success 200 {object} models.Dispatches
+// @Failure 404
+// @router /:id [get]
+func (d *DispatchesController) Get() {
+	id := d.GetString(":id")
+	defer d.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	scope, ok := getScope(&d.Controller)
+	if !ok {
+		return
+	}
+	dispatches, err := models.GetDispatchesInScope(scope, &models.Dispatches{Id: id}, 1, 0)
+	if err != nil {
+		d.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		d.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(dispatches) == 0 {
+		beego.Debug("[C] Got nothing with id:", id)
+		d.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	d.Data["json"] = dispatches[0]
+	d.Ctx.Output.SetStatus(http.StatusOK)
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"moduleab_server/models"
+	"net/http"
+
+	"github.com/astaxie/beego"
+)
+
+type EnrollmentTokensController struct {
+	beego.Controller
+}
+
+// @Title createEnrollmentToken
+// @Description create a one-time token for an agent to enroll into appset, token is only shown this time
+// @Param	token	body	models.EnrollmentTokens	true	"name, appset, require_approval and expires_time"
+// @Success 201
+// @router / [post]
+func (h *EnrollmentTokensController) Post() {
+	token := new(models.EnrollmentTokens)
+	defer h.ServeJSON()
+	err := json.Unmarshal(h.Ctx.Input.RequestBody, token)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	token.CreatedBy = &models.Users{Id: CurrentUserId(h.Ctx)}
+	raw, err := models.AddEnrollmentToken(token)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Failed to add new enrollment token",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	beego.Debug("[C] Got id:", token.Id)
+	h.Data["json"] = map[string]interface{}{
+		"id":               token.Id,
+		"token":            raw,
+		"require_approval": token.RequireApproval,
+		"expires_time":     token.ExpiresTime,
+	}
+	h.Ctx.Output.SetStatus(http.StatusCreated)
+}
+
+// @Title listEnrollmentTokens
+// @Param	appset	query	string	false	"app set id"
+// @Success 200 {object} []models.EnrollmentTokens
+// @router / [get]
+func (h *EnrollmentTokensController) GetAll() {
+	limit, _ := h.GetInt("limit", 0)
+	index, _ := h.GetInt("index", 0)
+
+	defer h.ServeJSON()
+
+	token := &models.EnrollmentTokens{}
+	if appSet := h.GetString("appset"); appSet != "" {
+		token.AppSet = &models.AppSets{Id: appSet}
+	}
+	tokens, err := models.GetEnrollmentTokens(token, limit, index)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	h.Data["json"] = tokens
+	if len(tokens) == 0 {
+		beego.Debug("[C] Got nothing")
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title deleteEnrollmentToken
+// @Success 204
+// @Failure 404
+// @router /:id [delete]
+func (h *EnrollmentTokensController) Delete() {
+	id := h.GetString(":id")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	tokens, err := models.GetEnrollmentTokens(&models.EnrollmentTokens{Id: id}, 1, 0)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(tokens) == 0 {
+		beego.Debug("[C] Got nothing with id:", id)
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	err = models.DeleteEnrollmentToken(tokens[0])
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to delete with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	h.Ctx.Output.SetStatus(http.StatusNoContent)
+}
+
+// EnrollController is where agents without a key come with
+// an enrollment token, so it needs no login.
+type EnrollController struct {
+	beego.Controller
+}
+
+// Enrollment is what an agent sends to enroll.
+type Enrollment struct {
+	Token     string   `json:"token"`
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses"`
+}
+
+// @Title enroll
+// @Description agent exchanges an enrollment token for its host and key, secret is only shown this time
+// @Param	enrollment	body	controllers.Enrollment	true	"token, host name and addresses, first is the primary one"
+// @Success 201
+// @Failure 403 bad, used or expired token
+// @router / [post]
+func (h *EnrollController) Post() {
+	e := new(Enrollment)
+	defer h.ServeJSON()
+	err := json.Unmarshal(h.Ctx.Input.RequestBody, e)
+	if err != nil || e.Token == "" || e.Name == "" || len(e.Addresses) == 0 {
+		if err == nil {
+			err = fmt.Errorf("token, name and addresses are required")
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	beego.Debug("[C] Got data:", e.Name, e.Addresses)
+	host, key, err := models.EnrollHost(e.Token, e.Name, e.Addresses)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Failed to enroll",
+			"error":   err.Error(),
+		}
+		switch err {
+		case models.ErrorEnrollmentTokenInvalid,
+			models.ErrorEnrollmentTokenUsed,
+			models.ErrorEnrollmentTokenExpired:
+			h.Ctx.Output.SetStatus(http.StatusForbidden)
+		default:
+			h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		}
+		return
+	}
+	h.Data["json"] = map[string]interface{}{
+		"host_id": host.Id,
+		"name":    host.Name,
+		"status":  host.Status,
+		"key_id":  key.Id,
+		"secret":  key.Secret,
+	}
+	h.Ctx.Output.SetStatus(http.StatusCreated)
+}
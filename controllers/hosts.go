@@ -3,51 +3,16 @@ package controllers
 import (
 	"encoding/json"
 	"fmt"
-	"moduleab_server/common"
 	"moduleab_server/models"
 	"net/http"
 
 	"github.com/astaxie/beego"
 )
 
-func init() {
-	AddPrivilege("GET", "^/api/v1/hosts", models.RoleFlagUser)
-}
-
 type HostsController struct {
 	beego.Controller
 }
 
-func (h *HostsController) Prepare() {
-	if h.Ctx.Input.Header("Signature") != "" {
-		err := common.AuthWithKey(h.Ctx)
-		if err != nil {
-			h.Data["json"] = map[string]string{
-				"error": err.Error(),
-			}
-			h.Ctx.Output.SetStatus(http.StatusForbidden)
-			h.ServeJSON()
-		}
-	} else {
-		id := h.GetSession("id")
-		if id == nil {
-			h.Data["json"] = map[string]string{
-				"error": "You need login first.",
-			}
-			h.Ctx.Output.SetStatus(http.StatusUnauthorized)
-			h.ServeJSON()
-		} else {
-			if !CheckPrivileges(id.(string), h.Ctx) {
-				h.Data["json"] = map[string]string{
-					"error": "No privileges.",
-				}
-				h.Ctx.Output.SetStatus(http.StatusForbidden)
-				h.ServeJSON()
-			}
-		}
-	}
-}
-
 // @Title createHost
 // @Description create Host
 // @Param	host 	body 	object true	"host"
@@ -58,6 +23,13 @@ func (h *HostsController) Prepare() {
 func (h *HostsController) Post() {
 	host := new(models.Hosts)
 	defer h.ServeJSON()
+	if p, _ := Authenticate(h.Ctx); p != nil && p.Type == PrincipalHost {
+		h.Data["json"] = map[string]string{
+			"error": "Agents enroll with /api/v1/enroll.",
+		}
+		h.Ctx.Output.SetStatus(http.StatusForbidden)
+		return
+	}
 	err := json.Unmarshal(h.Ctx.Input.RequestBody, host)
 	if err != nil {
 		beego.Warn("[C] Got error:", err)
@@ -68,6 +40,14 @@ func (h *HostsController) Post() {
 		h.Ctx.Output.SetStatus(http.StatusBadRequest)
 		return
 	}
+	scope, ok := getScope(&h.Controller)
+	if !ok {
+		return
+	}
+	if !scope.AllowsHost(host) {
+		outOfScope(&h.Controller)
+		return
+	}
 	beego.Debug("[C] Got data:", host)
 	id, err := models.AddHost(host)
 	if err != nil {
@@ -111,6 +91,16 @@ func (h *HostsController) Get() {
 			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
 			return
 		}
+		if len(hosts) != 0 {
+			scope, ok := getScope(&h.Controller)
+			if !ok {
+				return
+			}
+			if !scope.AllowsHost(hosts[0]) {
+				outOfScope(&h.Controller)
+				return
+			}
+		}
 		h.Data["json"] = hosts
 		if len(hosts) == 0 {
 			beego.Debug("[C] Got nothing with name:", name)
@@ -132,7 +122,11 @@ func (h *HostsController) GetAll() {
 	defer h.ServeJSON()
 
 	host := &models.Hosts{}
-	hosts, err := models.GetHosts(host, limit, index)
+	scope, ok := getScope(&h.Controller)
+	if !ok {
+		return
+	}
+	hosts, err := models.GetHostsInScope(scope, host, limit, index)
 	if err != nil {
 		h.Data["json"] = map[string]string{
 			"message": fmt.Sprint("Failed to get"),
@@ -179,6 +173,14 @@ func (h *HostsController) Delete() {
 			h.Ctx.Output.SetStatus(http.StatusNotFound)
 			return
 		}
+		scope, ok := getScope(&h.Controller)
+		if !ok {
+			return
+		}
+		if !scope.AllowsHost(hosts[0]) {
+			outOfScope(&h.Controller)
+			return
+		}
 		err = models.DeleteHost(hosts[0])
 		if err != nil {
 			h.Data["json"] = map[string]string{
@@ -223,6 +225,14 @@ func (h *HostsController) Put() {
 			h.Ctx.Output.SetStatus(http.StatusNotFound)
 			return
 		}
+		scope, ok := getScope(&h.Controller)
+		if !ok {
+			return
+		}
+		if !scope.AllowsHost(hosts[0]) {
+			outOfScope(&h.Controller)
+			return
+		}
 
 		err = json.Unmarshal(h.Ctx.Input.RequestBody, host)
 		if err != nil {
@@ -235,6 +245,13 @@ func (h *HostsController) Put() {
 			return
 		}
 		host.Id = hosts[0].Id
+		// Only POST /hosts/:name/approve approves a host.
+		host.Status = hosts[0].Status
+		// Nor can it be moved out of scope.
+		if !scope.AllowsHost(host) {
+			outOfScope(&h.Controller)
+			return
+		}
 		beego.Debug("[C] Got host data:", host)
 		err = models.UpdateHost(host)
 		if err != nil {
@@ -250,3 +267,243 @@ func (h *HostsController) Put() {
 		h.Ctx.Output.SetStatus(http.StatusAccepted)
 	}
 }
+
+// getHostByName writes the response itself if it returns nil.
+func (h *HostsController) getHostByName(name string) *models.Hosts {
+	hosts, err := models.GetHosts(&models.Hosts{Name: name}, 1, 0)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with name:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return nil
+	}
+	if len(hosts) == 0 {
+		beego.Debug("[C] Got nothing with name:", name)
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+		return nil
+	}
+	return hosts[0]
+}
+
+// getHostInScope is getHostByName which also refuses hosts out of scope
+// of the user, it writes the response itself if it returns nil.
+func (h *HostsController) getHostInScope(name string) *models.Hosts {
+	host := h.getHostByName(name)
+	if host == nil {
+		return nil
+	}
+	scope, ok := getScope(&h.Controller)
+	if !ok {
+		return nil
+	}
+	if !scope.AllowsHost(host) {
+		outOfScope(&h.Controller)
+		return nil
+	}
+	return host
+}
+
+// @Title approveHost
+// @Description let a host enrolled with a token requiring approval use its keys
+// @Success 202
+// @router /:name/approve [post]
+func (h *HostsController) Approve() {
+	name := h.GetString(":name")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		host := h.getHostByName(name)
+		if host == nil {
+			return
+		}
+		scope, ok := getScope(&h.Controller)
+		if !ok {
+			return
+		}
+		if !scope.AllowsHost(host) {
+			outOfScope(&h.Controller)
+			return
+		}
+		err := models.ApproveHost(host)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to approve:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		h.Ctx.Output.SetStatus(http.StatusAccepted)
+	}
+}
+
+// @Title getHostStatus
+// @Description presence of agent of host, online is false if never seen
+// @Success 200 {object} models.Presence
+// @router /:name/status [get]
+func (h *HostsController) GetStatus() {
+	name := h.GetString(":name")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		host := h.getHostByName(name)
+		if host == nil {
+			return
+		}
+		scope, ok := getScope(&h.Controller)
+		if !ok {
+			return
+		}
+		if !scope.AllowsHost(host) {
+			outOfScope(&h.Controller)
+			return
+		}
+		presence, err := models.GetPresence(host.Id)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get status of:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if presence == nil {
+			presence = &models.Presence{HostId: host.Id, HostName: host.Name}
+		}
+		h.Data["json"] = presence
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title listHostKeys
+// @Description list API keys of host, secrets are not included
+// @Success 200 {object} []models.HostKeys
+// @router /:name/keys [get]
+func (h *HostsController) GetKeys() {
+	name := h.GetString(":name")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		host := h.getHostInScope(name)
+		if host == nil {
+			return
+		}
+		keys, err := models.GetHostKeys(&models.HostKeys{Host: host}, 0, 0)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get keys of:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		for _, v := range keys {
+			v.Secret = ""
+		}
+		h.Data["json"] = keys
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title createHostKey
+// @Description issue a new API key of host, secret is only returned this time
+// @Success 201 {object} models.HostKeys
+// @router /:name/keys [post]
+func (h *HostsController) PostKey() {
+	name := h.GetString(":name")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		host := h.getHostInScope(name)
+		if host == nil {
+			return
+		}
+		key, err := models.AddHostKey(host)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to issue key of:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		h.Data["json"] = key
+		h.Ctx.Output.SetStatus(http.StatusCreated)
+	}
+}
+
+// @Title rotateHostKey
+// @Description issue a new API key of host and revoke all old ones
+// @Success 201 {object} models.HostKeys
+// @router /:name/keys/rotate [post]
+func (h *HostsController) RotateKey() {
+	name := h.GetString(":name")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		host := h.getHostInScope(name)
+		if host == nil {
+			return
+		}
+		key, err := models.RotateHostKey(host)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to rotate key of:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		h.Data["json"] = key
+		h.Ctx.Output.SetStatus(http.StatusCreated)
+	}
+}
+
+// @Title revokeHostKey
+// @router /:name/keys/:id [delete]
+func (h *HostsController) RevokeKey() {
+	name := h.GetString(":name")
+	id := h.GetString(":id")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got name:", name, "key:", id)
+	if name != "" {
+		host := h.getHostInScope(name)
+		if host == nil {
+			return
+		}
+		keys, err := models.GetHostKeys(&models.HostKeys{Id: id, Host: host}, 1, 0)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get key:", id),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(keys) == 0 {
+			beego.Debug("[C] Got nothing with id:", id)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		err = models.RevokeHostKey(keys[0])
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to revoke key:", id),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		h.Ctx.Output.SetStatus(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"fmt"
+	"moduleab_server/models"
+	"net/http"
+
+	"github.com/astaxie/beego"
+)
+
+type IncidentsController struct {
+	beego.Controller
+}
+
+// @Title listIncidents
+// @Description incidents like missed backups, newest first
+// @Param	status	query	string	false	"open or resolved"
+// @Param	type	query	string	false	"e.g. missed_backup"
+// @Param	host	query	string	false	"host name"
+// @Success 200 {object} []models.Incidents
+// @router / [get]
+func (h *IncidentsController) GetAll() {
+	limit, _ := h.GetInt("limit", 50)
+	index, _ := h.GetInt("index", 0)
+
+	defer h.ServeJSON()
+	scope, ok := getScope(&h.Controller)
+	if !ok {
+		return
+	}
+	incident := &models.Incidents{
+		Status: h.GetString("status"),
+		Type:   h.GetString("type"),
+	}
+	if name := h.GetString("host"); name != "" {
+		hosts, err := models.GetHostsInScope(scope, &models.Hosts{Name: name}, 1, 0)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(hosts) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		incident.Host = hosts[0]
+	}
+	incidents, err := models.GetIncidentsInScope(scope, incident, limit, index)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	h.Data["json"] = incidents
+	if len(incidents) == 0 {
+		beego.Debug("[C] Got nothing")
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title getIncident
+// @Success 200 {object} models.Incidents
+// @Failure 404
+// @router /:id [get]
+func (h *IncidentsController) Get() {
+	id := h.GetString(":id")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	scope, ok := getScope(&h.Controller)
+	if !ok {
+		return
+	}
+	incidents, err := models.GetIncidentsInScope(scope, &models.Incidents{Id: id}, 1, 0)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(incidents) == 0 {
+		beego.Debug("[C] Got nothing with id:", id)
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	h.Data["json"] = incidents[0]
+	h.Ctx.Output.SetStatus(http.StatusOK)
+}
+
+// @Title ackIncident
+// @Description someone is on it, the incident stays open until resolved by the checker
+// @Success 204
+// @Failure 409 incident has been resolved
+// @router /:id/ack [post]
+func (h *IncidentsController) Ack() {
+	id := h.GetString(":id")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	scope, ok := getScope(&h.Controller)
+	if !ok {
+		return
+	}
+	incidents, err := models.GetIncidentsInScope(scope, &models.Incidents{Id: id}, 1, 0)
+	if err == nil && len(incidents) == 0 {
+		err = models.ErrorIncidentNotFound
+	}
+	if err == nil {
+		err = models.AckIncident(id, CurrentUserId(h.Ctx))
+	}
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Failed to ack",
+			"error":   err.Error(),
+		}
+		switch err {
+		case models.ErrorIncidentNotFound:
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+		case models.ErrorIncidentResolved:
+			h.Ctx.Output.SetStatus(http.StatusConflict)
+		default:
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+	h.Ctx.Output.SetStatus(http.StatusNoContent)
+}
@@ -3,12 +3,17 @@ package controllers
 import (
 	"encoding/json"
 	"fmt"
+	"moduleab_server/common"
 	"moduleab_server/models"
 	"net/http"
+	"time"
 
 	"github.com/astaxie/beego"
 )
 
+// Time between password and second factor of a login.
+const PendingLoginTimeout = 5 * time.Minute
+
 type LoginController struct {
 	beego.Controller
 }
@@ -27,9 +32,42 @@ func (h *LoginController) Login() {
 		h.Ctx.Output.SetStatus(http.StatusBadRequest)
 		return
 	}
-	beego.Debug("[C] Got data:", user)
-	users, err := models.GetUser(user, 0, 0)
+	beego.Debug("[C] Got login name:", user.Name)
+	ip := common.ClientIP(h.Ctx)
+	// Redis being down should not stop everyone from login,
+	// so errors of limiting are only logged.
+	locked, err := common.LoginLockedFor(user.Name, ip)
 	if err != nil {
+		beego.Warn("[C] Got error:", err)
+	}
+	if locked > 0 {
+		models.LogAuthEvent(models.AuthEventLoginFailure, user.Name, ip, "Locked")
+		h.Data["json"] = map[string]string{
+			"error": fmt.Sprint("Too many failures, try again in ", locked),
+		}
+		h.Ctx.Output.Header("Retry-After", fmt.Sprint(int64(locked/time.Second)))
+		h.Ctx.Output.SetStatus(http.StatusTooManyRequests)
+		return
+	}
+
+	u, err := models.Authenticate(user.Name, user.NewPassword)
+	if err == models.ErrorBadCredentials {
+		beego.Debug("[C] Bad credentials of:", user.Name)
+		models.LogAuthEvent(models.AuthEventLoginFailure, user.Name, ip, err.Error())
+		locked, err := common.LoginFailed(user.Name, ip)
+		if err != nil {
+			beego.Warn("[C] Got error:", err)
+		}
+		if locked > 0 {
+			beego.Warn("[C] Login locked:", user.Name, ip, locked)
+			models.LogAuthEvent(models.AuthEventLocked, user.Name, ip, fmt.Sprint("For ", locked))
+		}
+		h.Data["json"] = map[string]string{
+			"error": models.ErrorBadCredentials.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusForbidden)
+		return
+	} else if err != nil {
 		h.Data["json"] = map[string]string{
 			"message": fmt.Sprint("Failed to get with name:", user.Name),
 			"error":   err.Error(),
@@ -38,18 +76,311 @@ func (h *LoginController) Login() {
 		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
 		return
 	}
-	if len(users) == 0 || user.Password == "" {
-		beego.Debug("[C] Got nothing with name:", user.Name)
+	if h.startPendingLogin(u) {
+		h.Data["json"] = map[string]bool{
+			"totp_required":        u.TotpEnabled,
+			"totp_enroll_required": !u.TotpEnabled,
+		}
+		h.Ctx.Output.SetStatus(http.StatusAccepted)
+		return
+	}
+	h.startSession(u)
+	h.Ctx.Output.SetStatus(http.StatusOK)
+}
+
+// startPendingLogin returns true if u needs a second factor. The first
+// one is right, but nothing is allowed until the second factor is
+// verified, or enrolled if required.
+func (h *LoginController) startPendingLogin(u *models.Users) bool {
+	if !u.TotpEnabled && !u.RequiresTotp() {
+		return false
+	}
+	h.SetSession("pending_id", u.Id)
+	h.SetSession("pending_time", time.Now().Unix())
+	return true
+}
+
+func (h *LoginController) startSession(u *models.Users) {
+	err := common.LoginSucceeded(u.Name)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+	}
+	models.LogAuthEvent(models.AuthEventLoginSuccess, u.Name, common.ClientIP(h.Ctx), "")
+	h.DelSession("pending_id")
+	h.DelSession("pending_time")
+	h.SetSession("id", u.Id)
+	h.SetSession("name", u.Name)
+	h.SetSession("show_name", u.ShowName)
+}
+
+// currentUser is the user logged in, or whose password is verified in
+// PendingLoginTimeout if allowPending. It writes the response itself
+// if it returns nil.
+func (h *LoginController) currentUser(allowPending bool) (*models.Users, bool) {
+	id, _ := h.GetSession("id").(string)
+	pending := false
+	if id == "" && allowPending {
+		t, _ := h.GetSession("pending_time").(int64)
+		if time.Since(time.Unix(t, 0)) < PendingLoginTimeout {
+			id, _ = h.GetSession("pending_id").(string)
+			pending = true
+		}
+	}
+	if id == "" {
+		h.Data["json"] = map[string]string{
+			"error": "You need login first.",
+		}
+		h.Ctx.Output.SetStatus(http.StatusUnauthorized)
+		return nil, false
+	}
+	users, err := models.GetUser(&models.Users{Id: id}, 1, 0)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": "Failed to get user",
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return nil, false
+	}
+	if len(users) == 0 {
+		h.Data["json"] = map[string]string{
+			"error": "Invalid user id.",
+		}
+		h.Ctx.Output.SetStatus(http.StatusUnauthorized)
+		return nil, false
+	}
+	return users[0], pending
+}
+
+type totpRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (h *LoginController) parseTotpRequest() (*totpRequest, bool) {
+	req := new(totpRequest)
+	err := json.Unmarshal(h.Ctx.Input.RequestBody, req)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return nil, false
+	}
+	return req, true
+}
+
+// secondFactorLocked writes the response and returns true if
+// u is locked out at ip.
+func (h *LoginController) secondFactorLocked(u *models.Users, ip string) bool {
+	locked, err := common.LoginLockedFor(u.Name, ip)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+	}
+	if locked > 0 {
+		h.Data["json"] = map[string]string{
+			"error": fmt.Sprint("Too many failures, try again in ", locked),
+		}
+		h.Ctx.Output.Header("Retry-After", fmt.Sprint(int64(locked/time.Second)))
+		h.Ctx.Output.SetStatus(http.StatusTooManyRequests)
+		return true
+	}
+	return false
+}
+
+// secondFactorFailed counts a bad code like a bad password, and writes
+// the response of err.
+func (h *LoginController) secondFactorFailed(u *models.Users, ip string, err error) {
+	models.LogAuthEvent(models.AuthEventLoginFailure, u.Name, ip, err.Error())
+	if err == models.ErrorBadTotpCode {
+		locked, lerr := common.LoginFailed(u.Name, ip)
+		if lerr != nil {
+			beego.Warn("[C] Got error:", lerr)
+		}
+		if locked > 0 {
+			models.LogAuthEvent(models.AuthEventLocked, u.Name, ip, fmt.Sprint("For ", locked))
+		}
 		h.Ctx.Output.SetStatus(http.StatusForbidden)
+	} else if err == models.ErrorTotpNotEnrolled {
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+	} else {
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+	}
+	h.Data["json"] = map[string]string{
+		"error": err.Error(),
+	}
+}
+
+// verifySecondFactor counts failures like passwords, since
+// six digits are even easier to guess.
+func (h *LoginController) verifySecondFactor(u *models.Users, req *totpRequest) bool {
+	ip := common.ClientIP(h.Ctx)
+	if h.secondFactorLocked(u, ip) {
+		return false
+	}
+	err := models.VerifySecondFactor(u, req.Code, req.RecoveryCode)
+	if err == nil {
+		return true
+	}
+	h.secondFactorFailed(u, ip, err)
+	return false
+}
+
+// @Title verifyTotp
+// @Description second step of login, with code or recovery_code
+// @router /totp [post]
+func (h *LoginController) VerifyTotp() {
+	defer h.ServeJSON()
+	u, pending := h.currentUser(true)
+	if u == nil {
+		return
+	}
+	if !pending {
+		h.Ctx.Output.SetStatus(http.StatusOK)
+		return
+	}
+	req, ok := h.parseTotpRequest()
+	if !ok || !h.verifySecondFactor(u, req) {
+		return
+	}
+	h.startSession(u)
+	h.Ctx.Output.SetStatus(http.StatusOK)
+}
+
+// @Title enrollTotp
+// @Description make a new secret, it works after /totp/enable
+// @router /totp/enroll [post]
+func (h *LoginController) EnrollTotp() {
+	defer h.ServeJSON()
+	u, _ := h.currentUser(true)
+	if u == nil {
+		return
+	}
+	if u.TotpEnabled {
+		h.Data["json"] = map[string]string{
+			"error": "Two-factor authentication is enabled, disable it first.",
+		}
+		h.Ctx.Output.SetStatus(http.StatusConflict)
+		return
+	}
+	secret, uri, err := models.EnrollTotp(u)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": "Failed to enroll",
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	h.Data["json"] = map[string]string{
+		"secret": secret,
+		"uri":    uri,
+	}
+	h.Ctx.Output.SetStatus(http.StatusOK)
+}
+
+// @Title enableTotp
+// @Description verify code of the enrolled secret, returns recovery codes
+// @router /totp/enable [post]
+func (h *LoginController) EnableTotp() {
+	defer h.ServeJSON()
+	u, pending := h.currentUser(true)
+	if u == nil {
+		return
+	}
+	// Otherwise it would be a second factor without lockout.
+	if u.TotpEnabled {
+		h.Data["json"] = map[string]string{
+			"error": "Two-factor authentication is enabled already.",
+		}
+		h.Ctx.Output.SetStatus(http.StatusConflict)
+		return
+	}
+	req, ok := h.parseTotpRequest()
+	if !ok {
+		return
+	}
+	ip := common.ClientIP(h.Ctx)
+	if h.secondFactorLocked(u, ip) {
 		return
-	} else if len(users) > 1 {
-		beego.Debug("[C] Got duplicate user with name:", user.Name)
+	}
+	codes, err := models.EnableTotp(u, req.Code)
+	if err != nil {
+		h.secondFactorFailed(u, ip, err)
+		return
+	}
+	if pending {
+		h.startSession(u)
+	}
+	h.Data["json"] = map[string][]string{
+		"recovery_codes": codes,
+	}
+	h.Ctx.Output.SetStatus(http.StatusOK)
+}
+
+// @Title newRecoveryCodes
+// @Description replace all recovery codes, needs a code
+// @router /totp/recovery [post]
+func (h *LoginController) NewRecoveryCodes() {
+	defer h.ServeJSON()
+	u, _ := h.currentUser(false)
+	if u == nil {
+		return
+	}
+	req, ok := h.parseTotpRequest()
+	if !ok || !h.verifySecondFactor(u, req) {
+		return
+	}
+	codes, err := models.NewRecoveryCodes(u)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": "Failed to make recovery codes",
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	h.Data["json"] = map[string][]string{
+		"recovery_codes": codes,
+	}
+	h.Ctx.Output.SetStatus(http.StatusOK)
+}
+
+// @Title disableTotp
+// @Description needs a code, refused if a role of user requires it
+// @router /totp/disable [post]
+func (h *LoginController) DisableTotp() {
+	defer h.ServeJSON()
+	u, _ := h.currentUser(false)
+	if u == nil {
+		return
+	}
+	req, ok := h.parseTotpRequest()
+	if !ok || !h.verifySecondFactor(u, req) {
+		return
+	}
+	err := models.DisableTotp(u)
+	if err == models.ErrorTotpRequired {
+		h.Data["json"] = map[string]string{
+			"error": err.Error(),
+		}
 		h.Ctx.Output.SetStatus(http.StatusForbidden)
 		return
+	} else if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": "Failed to disable",
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
 	}
-	h.SetSession("id", users[0].Id)
-	h.SetSession("name", users[0].Name)
-	h.SetSession("show_name", users[0].ShowName)
 	h.Ctx.Output.SetStatus(http.StatusOK)
 }
 
@@ -65,6 +396,8 @@ func (h *LoginController) Logout() {
 	}
 
 	h.DelSession("id")
+	h.DelSession("pending_id")
+	h.DelSession("pending_time")
 	h.Ctx.Output.SetStatus(http.StatusOK)
 }
 
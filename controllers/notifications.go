@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"moduleab_server/models"
+	"net/http"
+
+	"github.com/astaxie/beego"
+)
+
+type NotificationChannelsController struct {
+	beego.Controller
+}
+
+// Secrets never leave the server through this API.
+func hideChannelSecrets(channels []*models.NotificationChannels) []*models.NotificationChannels {
+	for _, v := range channels {
+		v.Secret = ""
+	}
+	return channels
+}
+
+// getChannel gets channel of id, it writes the response itself
+// if it returns nil.
+func (n *NotificationChannelsController) getChannel(id string) *models.NotificationChannels {
+	channels, err := models.GetNotificationChannels(&models.NotificationChannels{Id: id}, 1, 0)
+	if err != nil {
+		n.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		n.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return nil
+	}
+	if id == "" || len(channels) == 0 {
+		beego.Debug("[C] Got nothing with id:", id)
+		n.Ctx.Output.SetStatus(http.StatusNotFound)
+		return nil
+	}
+	return channels[0]
+}
+
+// @Title createNotificationChannel
+// @Description type is smtp, webhook, slack or dingtalk
+// @Param	channel	body	models.NotificationChannels	true	"channel"
+// @Success 201
+// @router / [post]
+func (n *NotificationChannelsController) Post() {
+	channel := new(models.NotificationChannels)
+	defer n.ServeJSON()
+	err := json.Unmarshal(n.Ctx.Input.RequestBody, channel)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		n.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		n.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	beego.Debug("[C] Got data:", channel.Name, channel.Type)
+	id, err := models.AddNotificationChannel(channel)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		n.Data["json"] = map[string]string{
+			"message": "Failed to add new notification channel",
+			"error":   err.Error(),
+		}
+		n.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	beego.Debug("[C] Got id:", id)
+	n.Data["json"] = map[string]string{
+		"id": id,
+	}
+	n.Ctx.Output.SetStatus(http.StatusCreated)
+}
+
+// @Title listNotificationChannels
+// @Success 200 {object} []models.NotificationChannels
+// @router / [get]
+func (n *NotificationChannelsController) GetAll() {
+	limit, _ := n.GetInt("limit", 0)
+	index, _ := n.GetInt("index", 0)
+	defer n.ServeJSON()
+	channels, err := models.GetNotificationChannels(&models.NotificationChannels{
+		Type: n.GetString("type"),
+	}, limit, index)
+	if err != nil {
+		n.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		n.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	n.Data["json"] = hideChannelSecrets(channels)
+	if len(channels) == 0 {
+		beego.Debug("[C] Got nothing")
+		n.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		n.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title getNotificationChannel
+// @Success 200 {object} models.NotificationChannels
+// @router /:id [get]
+func (n *NotificationChannelsController) Get() {
+	id := n.GetString(":id")
+	defer n.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	channel := n.getChannel(id)
+	if channel == nil {
+		return
+	}
+	n.Data["json"] = hideChannelSecrets([]*models.NotificationChannels{channel})[0]
+	n.Ctx.Output.SetStatus(http.StatusOK)
+}
+
+// @Title updateNotificationChannel
+// @Description Secret is kept if it is empty in body.
+// @router /:id [put]
+func (n *NotificationChannelsController) Put() {
+	id := n.GetString(":id")
+	defer n.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	channel := n.getChannel(id)
+	if channel == nil {
+		return
+	}
+	channel.Secret = ""
+	err := json.Unmarshal(n.Ctx.Input.RequestBody, channel)
+	channel.Id = id
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		n.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		n.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	err = models.UpdateNotificationChannel(channel)
+	if err != nil {
+		n.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to update with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		n.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	n.Ctx.Output.SetStatus(http.StatusAccepted)
+}
+
+// @Title deleteNotificationChannel
+// @Success 204
+// @router /:id [delete]
+func (n *NotificationChannelsController) Delete() {
+	id := n.GetString(":id")
+	defer n.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	channel := n.getChannel(id)
+	if channel == nil {
+		return
+	}
+	err := models.DeleteNotificationChannel(channel)
+	if err != nil {
+		n.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to delete with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		n.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	n.Ctx.Output.SetStatus(http.StatusNoContent)
+}
+
+// @Title testNotificationChannel
+// @Description send a test message at once, without retries
+// @Success 200
+// @Failure 502 channel failed to send
+// @router /:id/test [post]
+func (n *NotificationChannelsController) Test() {
+	id := n.GetString(":id")
+	defer n.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	channel := n.getChannel(id)
+	if channel == nil {
+		return
+	}
+	err := models.SendTestNotification(channel)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		n.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to send with channel:", channel.Name),
+			"error":   err.Error(),
+		}
+		n.Ctx.Output.SetStatus(http.StatusBadGateway)
+		return
+	}
+	n.Data["json"] = map[string]bool{
+		"ok": true,
+	}
+	n.Ctx.Output.SetStatus(http.StatusOK)
+}
+
+// @Title createNotificationRule
+// @Description channel gets events matching event, like "incident.*", of at least severity
+// @Param	rule	body	models.NotificationRules	true	"event and severity"
+// @Success 201
+// @router /:id/rules [post]
+func (n *NotificationChannelsController) PostRule() {
+	id := n.GetString(":id")
+	defer n.ServeJSON()
+	channel := n.getChannel(id)
+	if channel == nil {
+		return
+	}
+	rule := new(models.NotificationRules)
+	err := json.Unmarshal(n.Ctx.Input.RequestBody, rule)
+	if err == nil {
+		rule.Channel = channel
+		_, err = models.AddNotificationRule(rule)
+	}
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		n.Data["json"] = map[string]string{
+			"message": "Failed to add new notification rule",
+			"error":   err.Error(),
+		}
+		n.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	n.Data["json"] = map[string]string{
+		"id": rule.Id,
+	}
+	n.Ctx.Output.SetStatus(http.StatusCreated)
+}
+
+// @Title deleteNotificationRule
+// @Success 204
+// @router /:id/rules/:rule [delete]
+func (n *NotificationChannelsController) DeleteRule() {
+	id := n.GetString(":id")
+	ruleId := n.GetString(":rule")
+	defer n.ServeJSON()
+	channel := n.getChannel(id)
+	if channel == nil {
+		return
+	}
+	for _, v := range channel.Rules {
+		if v.Id != ruleId {
+			continue
+		}
+		err := models.DeleteNotificationRule(v)
+		if err != nil {
+			n.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to delete with id:", ruleId),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			n.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		n.Ctx.Output.SetStatus(http.StatusNoContent)
+		return
+	}
+	beego.Debug("[C] Got nothing with id:", ruleId)
+	n.Ctx.Output.SetStatus(http.StatusNotFound)
+}
+
+// @Title listNotificationDeliveries
+// @Param	status	query	string	false	"pending, sent or failed"
+// @Param	event	query	string	false	"event"
+// @Success 200 {object} []models.NotificationDeliveries
+// @router /:id/deliveries [get]
+func (n *NotificationChannelsController) GetDeliveries() {
+	id := n.GetString(":id")
+	limit, _ := n.GetInt("limit", 50)
+	index, _ := n.GetInt("index", 0)
+	defer n.ServeJSON()
+	channel := n.getChannel(id)
+	if channel == nil {
+		return
+	}
+	deliveries, err := models.GetNotificationDeliveries(&models.NotificationDeliveries{
+		Channel: channel,
+		Status:  n.GetString("status"),
+		Event:   n.GetString("event"),
+	}, limit, index)
+	if err != nil {
+		n.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		n.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	n.Data["json"] = deliveries
+	if len(deliveries) == 0 {
+		beego.Debug("[C] Got nothing")
+		n.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		n.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
@@ -3,51 +3,17 @@ package controllers
 import (
 	"encoding/json"
 	"fmt"
-	"moduleab_server/common"
 	"moduleab_server/models"
+	"moduleab_server/storage"
 	"net/http"
 
 	"github.com/astaxie/beego"
 )
 
-func init() {
-	AddPrivilege("GET", "^/api/v1/oas", models.RoleFlagUser)
-}
-
 type OasController struct {
 	beego.Controller
 }
 
-func (h *OasController) Prepare() {
-	if h.Ctx.Input.Header("Signature") != "" {
-		err := common.AuthWithKey(h.Ctx)
-		if err != nil {
-			h.Data["json"] = map[string]string{
-				"error": err.Error(),
-			}
-			h.Ctx.Output.SetStatus(http.StatusForbidden)
-			h.ServeJSON()
-		}
-	} else {
-		id := h.GetSession("id")
-		if id == nil {
-			h.Data["json"] = map[string]string{
-				"error": "You need login first.",
-			}
-			h.Ctx.Output.SetStatus(http.StatusUnauthorized)
-			h.ServeJSON()
-		} else {
-			if !CheckPrivileges(id.(string), h.Ctx) {
-				h.Data["json"] = map[string]string{
-					"error": "No privileges.",
-				}
-				h.Ctx.Output.SetStatus(http.StatusForbidden)
-				h.ServeJSON()
-			}
-		}
-	}
-}
-
 // @Title createOAS
 // @router / [post]
 func (a *OasController) Post() {
@@ -65,7 +31,17 @@ func (a *OasController) Post() {
 	}
 	beego.Debug("Got data:", oas)
 
-	o, err := common.NewOasClient(oas.Endpoint)
+	conf, err := oas.StorageConfig()
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		a.Data["json"] = map[string]string{
+			"message": "Bad config",
+			"error":   err.Error(),
+		}
+		a.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	cold, err := storage.NewColdStorage(conf)
 	if err != nil {
 		beego.Warn("[C] Got error:", err)
 		a.Data["json"] = map[string]string{
@@ -75,11 +51,11 @@ func (a *OasController) Post() {
 		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
 		return
 	}
-	oas.VaultId, err = o.GetOasVaultId(oas.VaultName)
+	oas.VaultId, err = cold.GetVaultId(oas.VaultName)
 	if err != nil {
 		beego.Warn("[C] Got error:", err)
 		a.Data["json"] = map[string]string{
-			"message": "Failed to access OAS",
+			"message": "Failed to access cold storage",
 			"error":   err.Error(),
 		}
 		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"moduleab_server/common"
+	"moduleab_server/models"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+// Time a browser has to come back from the OpenID provider.
+const OidcLoginTimeout = 10 * time.Minute
+
+// @Title oidcLogin
+// @Description redirect to the OpenID provider, with PKCE
+// @Success 302
+// @router /oidc/login [get]
+func (h *LoginController) OidcLogin() {
+	conf := common.NewOidcConfig()
+	provider, err := common.OidcDiscover(conf.Issuer)
+	if err != nil {
+		h.oidcFailed(http.StatusInternalServerError, err.Error())
+		return
+	}
+	values := make([]string, 3)
+	for i := range values {
+		values[i], err = common.NewOidcRandom()
+		if err != nil {
+			h.oidcFailed(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+	h.SetSession("oidc_state", state)
+	h.SetSession("oidc_nonce", nonce)
+	h.SetSession("oidc_verifier", verifier)
+	h.SetSession("oidc_time", time.Now().Unix())
+	h.Redirect(conf.AuthCodeURL(provider, state, nonce, verifier), http.StatusFound)
+}
+
+// @Title oidcCallback
+// @Description the OpenID provider redirects here with code, a session
+// is started like /login and the browser goes to oidc::successurl
+// @Success 302
+// @Failure 403 bad state, id token or no role for the user
+// @router /oidc/callback [get]
+func (h *LoginController) OidcCallback() {
+	state, _ := h.GetSession("oidc_state").(string)
+	nonce, _ := h.GetSession("oidc_nonce").(string)
+	verifier, _ := h.GetSession("oidc_verifier").(string)
+	t, _ := h.GetSession("oidc_time").(int64)
+	// Every login gets its own state, so it can be used only once.
+	h.DelSession("oidc_state")
+	h.DelSession("oidc_nonce")
+	h.DelSession("oidc_verifier")
+	h.DelSession("oidc_time")
+
+	if e := h.GetString("error"); e != "" {
+		h.oidcFailed(http.StatusForbidden, e+" "+h.GetString("error_description"))
+		return
+	}
+	if state == "" || h.GetString("state") != state ||
+		time.Since(time.Unix(t, 0)) > OidcLoginTimeout {
+		h.oidcFailed(http.StatusForbidden, "Bad or expired state.")
+		return
+	}
+
+	conf := common.NewOidcConfig()
+	provider, err := common.OidcDiscover(conf.Issuer)
+	if err != nil {
+		h.oidcFailed(http.StatusInternalServerError, err.Error())
+		return
+	}
+	idToken, err := conf.Exchange(provider, h.GetString("code"), verifier)
+	if err != nil {
+		h.oidcFailed(http.StatusForbidden, err.Error())
+		return
+	}
+	claims, err := conf.VerifyIdToken(provider, idToken, nonce, time.Now())
+	if err != nil {
+		h.oidcFailed(http.StatusForbidden, err.Error())
+		return
+	}
+	name, showName, roles, err := conf.UserOf(claims)
+	if err != nil {
+		h.oidcFailed(http.StatusForbidden, err.Error())
+		return
+	}
+	subject, err := conf.SubjectOf(claims)
+	if err != nil {
+		h.oidcFailed(http.StatusForbidden, err.Error())
+		return
+	}
+	u, err := models.ProvisionUser(models.UserSourceOidc, subject, name, showName, roles)
+	if err == models.ErrorUserSourceConflict || err == models.ErrorUserNoRoles {
+		models.LogAuthEvent(models.AuthEventLoginFailure, name, common.ClientIP(h.Ctx), err.Error())
+		h.oidcFailed(http.StatusForbidden, err.Error())
+		return
+	} else if err != nil {
+		h.oidcFailed(http.StatusInternalServerError, err.Error())
+		return
+	}
+	// Like password login, the second factor of ModuleAB is still needed.
+	if h.startPendingLogin(u) {
+		q := url.Values{}
+		if u.TotpEnabled {
+			q.Set("totp_required", "true")
+		} else {
+			q.Set("totp_enroll_required", "true")
+		}
+		totpURL := beego.AppConfig.DefaultString("oidc::totpurl", "/")
+		h.Redirect(totpURL+"?"+q.Encode(), http.StatusFound)
+		return
+	}
+	h.startSession(u)
+	h.Redirect(beego.AppConfig.DefaultString("oidc::successurl", "/"), http.StatusFound)
+}
+
+func (h *LoginController) oidcFailed(status int, err string) {
+	beego.Warn("[C] OIDC login failed:", err)
+	h.Data["json"] = map[string]string{
+		"message": "OIDC login failed",
+		"error":   err,
+	}
+	h.Ctx.Output.SetStatus(status)
+	h.ServeJSON()
+}
@@ -3,51 +3,16 @@ package controllers
 import (
 	"encoding/json"
 	"fmt"
-	"moduleab_server/common"
 	"moduleab_server/models"
 	"net/http"
 
 	"github.com/astaxie/beego"
 )
 
-func init() {
-	AddPrivilege("GET", "^/api/v1/paths", models.RoleFlagUser)
-}
-
 type PathsController struct {
 	beego.Controller
 }
 
-func (h *PathsController) Prepare() {
-	if h.Ctx.Input.Header("Signature") != "" {
-		err := common.AuthWithKey(h.Ctx)
-		if err != nil {
-			h.Data["json"] = map[string]string{
-				"error": err.Error(),
-			}
-			h.Ctx.Output.SetStatus(http.StatusForbidden)
-			h.ServeJSON()
-		}
-	} else {
-		id := h.GetSession("id")
-		if id == nil {
-			h.Data["json"] = map[string]string{
-				"error": "You need login first.",
-			}
-			h.Ctx.Output.SetStatus(http.StatusUnauthorized)
-			h.ServeJSON()
-		} else {
-			if !CheckPrivileges(id.(string), h.Ctx) {
-				h.Data["json"] = map[string]string{
-					"error": "No privileges.",
-				}
-				h.Ctx.Output.SetStatus(http.StatusForbidden)
-				h.ServeJSON()
-			}
-		}
-	}
-}
-
 // @Title createPath
 // @Description create Path
 // @Param	path 	body 	object true	"path"
@@ -68,6 +33,14 @@ func (h *PathsController) Post() {
 		h.Ctx.Output.SetStatus(http.StatusBadRequest)
 		return
 	}
+	scope, ok := getScope(&h.Controller)
+	if !ok {
+		return
+	}
+	if !scope.AllowsPath(path) || !scope.AllowsAppSets(path.AppSet) {
+		outOfScope(&h.Controller)
+		return
+	}
 	beego.Debug("[C] Got data:", path)
 	id, err := models.AddPath(path)
 	if err != nil {
@@ -111,6 +84,16 @@ func (h *PathsController) Get() {
 			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
 			return
 		}
+		if len(paths) != 0 {
+			scope, ok := getScope(&h.Controller)
+			if !ok {
+				return
+			}
+			if !scope.AllowsPath(paths[0]) {
+				outOfScope(&h.Controller)
+				return
+			}
+		}
 		h.Data["json"] = paths
 		if len(paths) == 0 {
 			beego.Debug("[C] Got nothing with id:", id)
@@ -132,7 +115,11 @@ func (h *PathsController) GetAll() {
 	defer h.ServeJSON()
 
 	path := &models.Paths{}
-	paths, err := models.GetPaths(path, limit, index)
+	scope, ok := getScope(&h.Controller)
+	if !ok {
+		return
+	}
+	paths, err := models.GetPathsInScope(scope, path, limit, index)
 	if err != nil {
 		h.Data["json"] = map[string]string{
 			"message": fmt.Sprint("Failed to get"),
@@ -179,6 +166,14 @@ func (h *PathsController) Delete() {
 			h.Ctx.Output.SetStatus(http.StatusNotFound)
 			return
 		}
+		scope, ok := getScope(&h.Controller)
+		if !ok {
+			return
+		}
+		if !scope.AllowsPath(paths[0]) {
+			outOfScope(&h.Controller)
+			return
+		}
 		err = models.DeletePath(paths[0])
 		if err != nil {
 			h.Data["json"] = map[string]string{
@@ -224,6 +219,14 @@ func (h *PathsController) Put() {
 			h.Ctx.Output.SetStatus(http.StatusNotFound)
 			return
 		}
+		scope, ok := getScope(&h.Controller)
+		if !ok {
+			return
+		}
+		if !scope.AllowsPath(paths[0]) {
+			outOfScope(&h.Controller)
+			return
+		}
 
 		err = json.Unmarshal(h.Ctx.Input.RequestBody, path)
 		if err != nil {
@@ -239,6 +242,11 @@ func (h *PathsController) Put() {
 		if path.AppSet != nil {
 			path.AppSet = paths[0].AppSet
 		}
+		// Nor can it be moved out of scope.
+		if !scope.AllowsPath(path) {
+			outOfScope(&h.Controller)
+			return
+		}
 		beego.Debug("[C] Got path data:", path)
 		err = models.UpdatePath(path)
 		if err != nil {
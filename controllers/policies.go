@@ -3,51 +3,16 @@ package controllers
 import (
 	"encoding/json"
 	"fmt"
-	"moduleab_server/common"
 	"moduleab_server/models"
 	"net/http"
 
 	"github.com/astaxie/beego"
 )
 
-func init() {
-	AddPrivilege("GET", "^/api/v1/policies", models.RoleFlagUser)
-}
-
 type PolicyController struct {
 	beego.Controller
 }
 
-func (h *PolicyController) Prepare() {
-	if h.Ctx.Input.Header("Signature") != "" {
-		err := common.AuthWithKey(h.Ctx)
-		if err != nil {
-			h.Data["json"] = map[string]string{
-				"error": err.Error(),
-			}
-			h.Ctx.Output.SetStatus(http.StatusForbidden)
-			h.ServeJSON()
-		}
-	} else {
-		id := h.GetSession("id")
-		if id == nil {
-			h.Data["json"] = map[string]string{
-				"error": "You need login first.",
-			}
-			h.Ctx.Output.SetStatus(http.StatusUnauthorized)
-			h.ServeJSON()
-		} else {
-			if !CheckPrivileges(id.(string), h.Ctx) {
-				h.Data["json"] = map[string]string{
-					"error": "No privileges.",
-				}
-				h.Ctx.Output.SetStatus(http.StatusForbidden)
-				h.ServeJSON()
-			}
-		}
-	}
-}
-
 // @Title createOAS
 // @router / [post]
 func (a *PolicyController) Post() {
@@ -64,6 +29,14 @@ func (a *PolicyController) Post() {
 		return
 	}
 
+	scope, ok := getScope(&a.Controller)
+	if !ok {
+		return
+	}
+	if !scope.AllowsPolicy(policy) {
+		outOfScope(&a.Controller)
+		return
+	}
 	beego.Debug("[C] Got data:", policy)
 	id, err := models.AddPolicy(policy)
 	if err != nil {
@@ -103,6 +76,16 @@ func (a *PolicyController) Get() {
 			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
 			return
 		}
+		if len(policies) != 0 {
+			scope, ok := getScope(&a.Controller)
+			if !ok {
+				return
+			}
+			if !scope.AllowsPolicy(policies[0]) {
+				outOfScope(&a.Controller)
+				return
+			}
+		}
 		a.Data["json"] = policies
 		if len(policies) == 0 {
 			beego.Debug("[C] Got nothing with name:", name)
@@ -120,7 +103,11 @@ func (a *PolicyController) GetAll() {
 	index, _ := a.GetInt("index", 0)
 	defer a.ServeJSON()
 	policy := &models.Policies{}
-	policies, err := models.GetPolicies(policy, limit, index)
+	scope, ok := getScope(&a.Controller)
+	if !ok {
+		return
+	}
+	policies, err := models.GetPoliciesInScope(scope, policy, limit, index)
 	if err != nil {
 		a.Data["json"] = map[string]string{
 			"message": fmt.Sprint("Failed to get"),
@@ -164,6 +151,14 @@ func (a *PolicyController) Delete() {
 			a.Ctx.Output.SetStatus(http.StatusNotFound)
 			return
 		}
+		scope, ok := getScope(&a.Controller)
+		if !ok {
+			return
+		}
+		if !scope.AllowsPolicy(policies[0]) {
+			outOfScope(&a.Controller)
+			return
+		}
 		err = models.DeletePolicy(policies[0])
 		if err != nil {
 			a.Data["json"] = map[string]string{
@@ -207,6 +202,14 @@ func (a *PolicyController) Put() {
 			a.Ctx.Output.SetStatus(http.StatusNotFound)
 			return
 		}
+		scope, ok := getScope(&a.Controller)
+		if !ok {
+			return
+		}
+		if !scope.AllowsPolicy(policies[0]) {
+			outOfScope(&a.Controller)
+			return
+		}
 
 		err = json.Unmarshal(a.Ctx.Input.RequestBody, policy)
 		policy.Id = policies[0].Id
@@ -219,6 +222,11 @@ func (a *PolicyController) Put() {
 			a.Ctx.Output.SetStatus(http.StatusBadRequest)
 			return
 		}
+		// Nor can it be moved out of scope.
+		if !scope.AllowsPolicy(policy) {
+			outOfScope(&a.Controller)
+			return
+		}
 		beego.Debug("[C] Got policy data:", policy)
 		err = models.UpdatePolicy(policy)
 		if err != nil {
@@ -2,59 +2,138 @@ package controllers
 
 import (
 	"moduleab_server/models"
+	"net/http"
 	"regexp"
+	"strings"
 
+	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/context"
 )
 
-type Privileges struct {
-	Method   string
-	Pattern  string
-	RoleFlag int
+// PermissionRule gives Permission to requests of Method (or any if empty)
+// whose URL matches Pattern. Empty Permission means no one is checked.
+type PermissionRule struct {
+	Method     string
+	Pattern    *regexp.Regexp
+	Permission string
 }
 
-var privileges []Privileges
+// PermissionRules are checked in order before the default
+// resource:action of RequiredPermission.
+var PermissionRules = []PermissionRule{
+	{"", regexp.MustCompile("^/api/v1/auth(/|$)"), ""},
+	{"", regexp.MustCompile("^/api/v1/enroll(/|$)"), ""},
+	{"", regexp.MustCompile("^/api/v1/version(/|$)"), ""},
+	// Everyone manages his own tokens, checked by the controller.
+	{"", regexp.MustCompile("^/api/v1/accessTokens(/|$)"), ""},
+	// Only admins, whose *:* is the only permission matching these.
+	{"", regexp.MustCompile("^/api/v1/authEvents(/|$)"), "authEvents:admin"},
+	{"", regexp.MustCompile("^/api/v1/scopes(/|$)"), "scopes:admin"},
+	{"", regexp.MustCompile("^/api/v1/enrollmentTokens(/|$)"), "enrollmentTokens:admin"},
+	{"", regexp.MustCompile("^/api/v1/notificationChannels(/|$)"), "notificationChannels:admin"},
+	{"", regexp.MustCompile("^/api/v1/webhookSubscriptions(/|$)"), "webhookSubscriptions:admin"},
+	{"POST", regexp.MustCompile("^/api/v1/hosts/[^/]+/approve$"), "hosts:approve"},
+	{"GET", regexp.MustCompile("^/api/v1/records/[^/]+/recover$"), "records:recover"},
+	{"POST", regexp.MustCompile("^/api/v1/client/signal/[^/]+/[^/]+/requeue$"), "signals:requeue"},
+	{"POST", regexp.MustCompile("^/api/v1/users/[^/]+/unlock$"), "users:unlock"},
+	// Everyone changes his own profile, checked by the controller.
+	{"PUT", regexp.MustCompile("^/api/v1/users/[^/]+/profile$"), ""},
+	{"", regexp.MustCompile("^/api/v1/hosts/[^/]+/keys(/|$)"), "hosts:keys"},
+}
 
-func init() {
-	privileges = make([]Privileges, 0)
+// RequiredPermission is what a request needs, like records:recover.
+// Unless a rule of PermissionRules matches, it is resource:action, where
+// resource is what follows /api/v1/, and action comes from method.
+func RequiredPermission(method, url string) string {
+	for _, r := range PermissionRules {
+		if (r.Method == "" || r.Method == method) && r.Pattern.MatchString(url) {
+			return r.Permission
+		}
+	}
+	if !strings.HasPrefix(url, "/api/v1/") {
+		return ""
+	}
+	resource := strings.SplitN(strings.TrimPrefix(url, "/api/v1/"), "/", 2)[0]
+	if resource == "" {
+		return ""
+	}
+	action := models.PermissionActionWrite
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		action = models.PermissionActionRead
+	case "DELETE":
+		action = models.PermissionActionDelete
+	}
+	return resource + ":" + action
 }
 
-func CheckPrivileges(userid string, ctx *context.Context) bool {
-	if userid == "" {
+// CheckPrivileges tells if user of p has the permission of the request,
+// and so does the token if p comes with one.
+func CheckPrivileges(p *Principal, ctx *context.Context) bool {
+	if p == nil || p.Id == "" {
 		return false
 	}
-	users, err := models.GetUser(&models.Users{Id: userid}, 1, 0)
+	required := RequiredPermission(ctx.Input.Method(), ctx.Input.URL())
+	if required == "" {
+		return true
+	}
+	ok, err := PermissionChecker(p.Id, required)
 	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		return false
+	}
+	if !ok {
+		beego.Debug("[C] User", p.Id, "has no permission:", required)
+		return false
+	}
+	if p.Token != nil && !p.Token.Allows(required) {
+		beego.Debug("[C] Access token", p.Token.Id, "has no scope:", required)
 		return false
 	}
-	if len(users) == 0 {
+	return true
+}
+
+// IsAdmin is for actions only administrators can do,
+// which CheckPrivileges cannot tell from operators.
+func IsAdmin(userid string) bool {
+	if userid == "" {
+		return false
+	}
+	users, err := models.GetUser(&models.Users{Id: userid}, 1, 0)
+	if err != nil || len(users) == 0 {
 		return false
 	}
 	for _, v := range users[0].Roles {
-		for _, p := range privileges {
-			if regexp.MustCompile(p.Pattern).MatchString(
-				ctx.Input.URL(),
-			) &&
-				ctx.Input.Method() == p.Method &&
-				v.RoleFlag <= p.RoleFlag {
-				return true
-			}
-			// Default is Operator
-			if v.RoleFlag <= models.RoleFlagOperator {
-				return true
-			}
+		if v.RoleFlag == models.RoleFlagAdmin {
+			return true
 		}
 	}
 	return false
 }
 
-func AddPrivilege(method, urlpattern string, roleflag int) {
-	privileges = append(
-		privileges,
-		Privileges{
-			Method:   method,
-			Pattern:  urlpattern,
-			RoleFlag: roleflag,
-		},
-	)
+// getScope gets scope of who is requesting, nil for hosts signing
+// requests. It writes the response itself if it returns false.
+func getScope(c *beego.Controller) (*models.Scope, bool) {
+	p, _ := Authenticate(c.Ctx)
+	if p == nil || p.Type != PrincipalUser {
+		return nil, true
+	}
+	scope, err := models.GetUserScope(p.Id)
+	if err != nil {
+		c.Data["json"] = map[string]string{
+			"message": "Failed to get scope",
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return nil, false
+	}
+	return scope, true
+}
+
+func outOfScope(c *beego.Controller) {
+	c.Data["json"] = map[string]string{
+		"error": models.ErrorOutOfScope.Error(),
+	}
+	c.Ctx.Output.SetStatus(http.StatusForbidden)
 }
@@ -11,44 +11,10 @@ import (
 	"github.com/astaxie/beego"
 )
 
-func init() {
-	AddPrivilege("GET", "^/api/v1/records", models.RoleFlagUser)
-}
-
 type RecordsController struct {
 	beego.Controller
 }
 
-func (h *RecordsController) Prepare() {
-	if h.Ctx.Input.Header("Signature") != "" {
-		err := common.AuthWithKey(h.Ctx)
-		if err != nil {
-			h.Data["json"] = map[string]string{
-				"error": err.Error(),
-			}
-			h.Ctx.Output.SetStatus(http.StatusForbidden)
-			h.ServeJSON()
-		}
-	} else {
-		id := h.GetSession("id")
-		if id == nil {
-			h.Data["json"] = map[string]string{
-				"error": "You need login first.",
-			}
-			h.Ctx.Output.SetStatus(http.StatusUnauthorized)
-			h.ServeJSON()
-		} else {
-			if !CheckPrivileges(id.(string), h.Ctx) {
-				h.Data["json"] = map[string]string{
-					"error": "No privileges.",
-				}
-				h.Ctx.Output.SetStatus(http.StatusForbidden)
-				h.ServeJSON()
-			}
-		}
-	}
-}
-
 // @Title createRecord
 // @Description create Record
 // @Param	record 	body 	object true	"record"
@@ -70,6 +36,49 @@ func (h *RecordsController) Post() {
 		return
 	}
 	beego.Debug("[C] Got data:", record)
+	// It is part of object key, which must stay in prefix of host.
+	if err = models.ValidTokenFilename(record.Filename); err != nil {
+		h.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	// A host can only post records of its own.
+	if hostId, hostName := common.AuthedHost(h.Ctx); hostId != "" {
+		if record.Host == nil ||
+			(record.Host.Id != "" && record.Host.Id != hostId) ||
+			(record.Host.Name != "" && record.Host.Name != hostName) {
+			h.Data["json"] = map[string]string{
+				"error": common.ErrorHostNotMatch.Error(),
+			}
+			h.Ctx.Output.SetStatus(http.StatusForbidden)
+			return
+		}
+		hosts, err := models.GetHosts(&models.Hosts{Id: hostId}, 1, 0)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with id:", hostId),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(hosts) == 0 {
+			err = common.ErrorHostNotMatch
+		} else {
+			err = record.BindHost(hosts[0])
+		}
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"error": err.Error(),
+			}
+			h.Ctx.Output.SetStatus(http.StatusForbidden)
+			return
+		}
+	}
 	id, err := models.AddRecord(record)
 	if err != nil {
 		beego.Warn("[C] Got error:", err)
@@ -128,10 +137,14 @@ func (h *RecordsController) GetAll() {
 	tBtEnd, _ := time.Parse(time.RFC3339, btEnd)
 	tAtStart, _ := time.Parse(time.RFC3339, atStart)
 	tAtEnd, _ := time.Parse(time.RFC3339, atEnd)
-	records, err := models.GetRecords(record, limit, index,
+	defer h.ServeJSON()
+	scope, ok := getScope(&h.Controller)
+	if !ok {
+		return
+	}
+	records, err := models.GetRecordsInScope(scope, record, limit, index,
 		models.OrderDesc, models.OrderDesc,
 		tBtStart, tBtEnd, tAtStart, tAtEnd)
-	defer h.ServeJSON()
 	if err != nil {
 		h.Data["json"] = map[string]string{
 			"message": fmt.Sprint("Failed to get"),
@@ -179,6 +192,14 @@ func (h *RecordsController) Delete() {
 			h.Ctx.Output.SetStatus(http.StatusNotFound)
 			return
 		}
+		scope, ok := getScope(&h.Controller)
+		if !ok {
+			return
+		}
+		if !scope.AllowsRecord(records[0]) {
+			outOfScope(&h.Controller)
+			return
+		}
 		err = models.DeleteRecord(records[0])
 		if err != nil {
 			h.Data["json"] = map[string]string{
@@ -219,6 +240,14 @@ func (h *RecordsController) Recover() {
 			h.Ctx.Output.SetStatus(http.StatusNotFound)
 			return
 		}
+		scope, ok := getScope(&h.Controller)
+		if !ok {
+			return
+		}
+		if !scope.AllowsRecord(records[0]) {
+			outOfScope(&h.Controller)
+			return
+		}
 
 		switch records[0].Type {
 		case models.RecordTypeArchive:
@@ -228,12 +257,10 @@ func (h *RecordsController) Recover() {
 				Records: records[0],
 			}
 
-			oasClient, err := common.NewOasClient(
-				records[0].BackupSet.Oas.Endpoint,
-			)
+			cold, err := records[0].BackupSet.GetColdStorage()
 			if err != nil {
 				h.Data["json"] = map[string]string{
-					"message": fmt.Sprint("Failed to connect to OAS"),
+					"message": fmt.Sprint("Failed to connect to cold storage"),
 					"error":   err.Error(),
 				}
 				beego.Warn("[C] Got error:", err)
@@ -241,14 +268,19 @@ func (h *RecordsController) Recover() {
 				return
 			}
 
-			oasJob.RequestId, oasJob.JobId, err = oasClient.RecoverToOss(
-				records[0].BackupSet.Oas.VaultId,
+			hot, err := records[0].BackupSet.Oss.StorageConfig()
+			if err != nil {
+				h.Data["json"] = map[string]string{
+					"message": fmt.Sprint("Bad hot storage config"),
+					"error":   err.Error(),
+				}
+				beego.Warn("[C] Got error:", err)
+				h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+				return
+			}
+			job, err := cold.Restore(
+				hot,
 				records[0].ArchiveId,
-				common.ConvertOssAddrToInternal(
-					records[0].BackupSet.Oss.Endpoint,
-				),
-				records[0].BackupSet.Oss.BucketName,
-				records[0].GetFullPath(),
 				records[0].GetFullPath(),
 			)
 			if err != nil {
@@ -260,6 +292,7 @@ func (h *RecordsController) Recover() {
 				h.Ctx.Output.SetStatus(http.StatusInternalServerError)
 				return
 			}
+			oasJob.RequestId, oasJob.JobId = job.RequestId, job.Id
 			id, err := models.AddOasJobs(oasJob)
 			if err != nil {
 				h.Data["json"] = map[string]string{
@@ -270,6 +303,10 @@ func (h *RecordsController) Recover() {
 				h.Ctx.Output.SetStatus(http.StatusInternalServerError)
 				return
 			}
+			models.PublishRecordEvent(models.EventOasJobCreated, records[0], map[string]string{
+				"job":      job.Id,
+				"job_type": "restore",
+			})
 			h.Data["json"] = map[string]string{
 				"job_id":  id,
 				"message": "This is archive, so some waiting is necessary.",
@@ -278,8 +315,7 @@ func (h *RecordsController) Recover() {
 		case models.RecordTypeBackup:
 			signal := models.MakeDownloadSignal(
 				records[0].GetFullPath(),
-				records[0].BackupSet.Oss.Endpoint,
-				records[0].BackupSet.Oss.BucketName,
+				records[0].BackupSet.Oss,
 			)
 			id, err := models.AddSignal(
 				records[0].Host.Id,
@@ -302,6 +338,9 @@ func (h *RecordsController) Recover() {
 				h.Ctx.Output.SetStatus(http.StatusInternalServerError)
 				return
 			}
+			models.PublishRecordEvent(models.EventRecordRecovered, records[0], map[string]string{
+				"signal": id,
+			})
 			h.Data["json"] = map[string]string{
 				"job_id":  id,
 				"message": "This is backup, so agent should be downloading now.",
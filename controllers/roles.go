@@ -1,62 +1,215 @@
 package controllers
 
 import (
+	"encoding/json"
 	"fmt"
-	"moduleab_server/common"
 	"moduleab_server/models"
 	"net/http"
 
 	"github.com/astaxie/beego"
 )
 
-func init() {
-	AddPrivilege("GET", "^/api/v1/roles", models.RoleFlagUser)
-}
-
 type RolesController struct {
 	beego.Controller
 }
 
-func (h *RolesController) Prepare() {
-	if h.Ctx.Input.Header("Signature") != "" {
-		err := common.AuthWithKey(h.Ctx)
+// @Title getAllRoles
+// @router / [get]
+func (h *RolesController) GetAll() {
+	limit, _ := h.GetInt("limit", 0)
+	index, _ := h.GetInt("index", 0)
+
+	defer h.ServeJSON()
+
+	role := &models.Roles{}
+	roles, err := models.GetRole(role, limit, index)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	h.Data["json"] = roles
+	if len(roles) == 0 {
+		beego.Debug("[C] Got nothing")
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title createRole
+// @Description permissions are given as [{"id": "..."}]
+// @router / [post]
+func (h *RolesController) Post() {
+	defer h.ServeJSON()
+	role := new(models.Roles)
+	err := json.Unmarshal(h.Ctx.Input.RequestBody, role)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	role.Removable = true
+	beego.Debug("[C] Got data:", role)
+	id, err := models.AddRole(role)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Failed to add new role",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+
+	beego.Debug("[C] Got id:", id)
+	h.Data["json"] = map[string]string{
+		"id": id,
+	}
+	h.Ctx.Output.SetStatus(http.StatusCreated)
+}
+
+// @Title getRole
+// @router /:name [get]
+func (h *RolesController) Get() {
+	name := h.GetString(":name")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		role := &models.Roles{
+			Name: name,
+		}
+		roles, err := models.GetRole(role, 0, 0)
 		if err != nil {
 			h.Data["json"] = map[string]string{
-				"error": err.Error(),
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
 			}
-			h.Ctx.Output.SetStatus(http.StatusForbidden)
-			h.ServeJSON()
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
 		}
-	} else {
-		id := h.GetSession("id")
-		if id == nil {
+		h.Data["json"] = roles
+		if len(roles) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+		} else {
+			h.Ctx.Output.SetStatus(http.StatusOK)
+		}
+	}
+}
+
+// @Title updateRole
+// @Description permissions of role are replaced if given
+// @router /:name [put]
+func (h *RolesController) Put() {
+	name := h.GetString(":name")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got role name:", name)
+	if name != "" {
+		role := &models.Roles{
+			Name: name,
+		}
+		roles, err := models.GetRole(role, 0, 0)
+		if err != nil {
 			h.Data["json"] = map[string]string{
-				"error": "You need login first.",
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
 			}
-			h.Ctx.Output.SetStatus(http.StatusUnauthorized)
-			h.ServeJSON()
-		} else {
-			if !CheckPrivileges(id.(string), h.Ctx) {
-				h.Data["json"] = map[string]string{
-					"error": "No privileges.",
-				}
-				h.Ctx.Output.SetStatus(http.StatusForbidden)
-				h.ServeJSON()
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(roles) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+
+		role.Permissions = nil
+		err = json.Unmarshal(h.Ctx.Input.RequestBody, role)
+		if err != nil {
+			beego.Warn("[C] Got error:", err)
+			h.Data["json"] = map[string]string{
+				"message": "Bad request",
+				"error":   err.Error(),
+			}
+			h.Ctx.Output.SetStatus(http.StatusBadRequest)
+			return
+		}
+		role.Id = roles[0].Id
+		role.Removable = roles[0].Removable // Removable should not be changed.
+		beego.Debug("[C] Got role data:", role)
+		err = models.UpdateRole(role)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to update with name:", name),
+				"error":   err.Error(),
 			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
 		}
+		h.Ctx.Output.SetStatus(http.StatusAccepted)
 	}
 }
 
-// @Title getAllRoles
-// @router / [get]
-func (h *RolesController) GetAll() {
+// @Title deleteRole
+// @router /:name [delete]
+func (h *RolesController) Delete() {
+	name := h.GetString(":name")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		role := &models.Roles{
+			Name: name,
+		}
+		roles, err := models.GetRole(role, 0, 0)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(roles) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		err = models.DeleteRole(roles[0])
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to delete with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		h.Ctx.Output.SetStatus(http.StatusNoContent)
+	}
+}
+
+// @Title listPermissions
+// @router /permissions [get]
+func (h *RolesController) GetPermissions() {
 	limit, _ := h.GetInt("limit", 0)
 	index, _ := h.GetInt("index", 0)
 
 	defer h.ServeJSON()
 
-	role := &models.Roles{}
-	roles, err := models.GetRole(role, limit, index)
+	permissions, err := models.GetPermissions(&models.Permissions{}, limit, index)
 	if err != nil {
 		h.Data["json"] = map[string]string{
 			"message": fmt.Sprint("Failed to get"),
@@ -66,11 +219,82 @@ func (h *RolesController) GetAll() {
 		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
 		return
 	}
-	h.Data["json"] = roles
-	if len(roles) == 0 {
+	h.Data["json"] = permissions
+	if len(permissions) == 0 {
 		beego.Debug("[C] Got nothing")
 		h.Ctx.Output.SetStatus(http.StatusNotFound)
 	} else {
 		h.Ctx.Output.SetStatus(http.StatusOK)
 	}
 }
+
+// @Title createPermission
+// @Description name is resource:action, * matches any
+// @router /permissions [post]
+func (h *RolesController) PostPermission() {
+	defer h.ServeJSON()
+	permission := new(models.Permissions)
+	err := json.Unmarshal(h.Ctx.Input.RequestBody, permission)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	beego.Debug("[C] Got data:", permission)
+	id, err := models.AddPermission(permission)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Failed to add new permission",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+
+	beego.Debug("[C] Got id:", id)
+	h.Data["json"] = map[string]string{
+		"id": id,
+	}
+	h.Ctx.Output.SetStatus(http.StatusCreated)
+}
+
+// @Title deletePermission
+// @router /permissions/:name [delete]
+func (h *RolesController) DeletePermission() {
+	name := h.GetString(":name")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		permissions, err := models.GetPermissions(&models.Permissions{Name: name}, 0, 0)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(permissions) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		err = models.DeletePermission(permissions[0])
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to delete with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		h.Ctx.Output.SetStatus(http.StatusNoContent)
+	}
+}
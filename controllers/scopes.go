@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"moduleab_server/models"
+	"net/http"
+
+	"github.com/astaxie/beego"
+)
+
+type ScopesController struct {
+	beego.Controller
+}
+
+// @Title createScopeBinding
+// @Description bind a role or user to an app set or backup set
+// @Param	binding	body	models.ScopeBindings	true	"one of role and user, one of appset and backupset"
+// @Success 201 {string} id
+// @Failure 400 bad binding
+// @router / [post]
+func (h *ScopesController) Post() {
+	binding := new(models.ScopeBindings)
+	defer h.ServeJSON()
+	err := json.Unmarshal(h.Ctx.Input.RequestBody, binding)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	beego.Debug("[C] Got data:", binding)
+	id, err := models.AddScopeBinding(binding)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Failed to add new scope binding",
+			"error":   err.Error(),
+		}
+		if err == models.ErrorBadScopeOwner || err == models.ErrorBadScopeSet {
+			h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		} else {
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	beego.Debug("[C] Got id:", id)
+	h.Data["json"] = map[string]string{
+		"id": id,
+	}
+	h.Ctx.Output.SetStatus(http.StatusCreated)
+}
+
+// @Title listScopeBindings
+// @Param	role	query	string	false	"role id"
+// @Param	user	query	string	false	"user id"
+// @Success 200 {object} []models.ScopeBindings
+// @router / [get]
+func (h *ScopesController) GetAll() {
+	limit, _ := h.GetInt("limit", 0)
+	index, _ := h.GetInt("index", 0)
+
+	defer h.ServeJSON()
+
+	binding := &models.ScopeBindings{}
+	if role := h.GetString("role"); role != "" {
+		binding.Role = &models.Roles{Id: role}
+	}
+	if user := h.GetString("user"); user != "" {
+		binding.User = &models.Users{Id: user}
+	}
+	bindings, err := models.GetScopeBindings(binding, limit, index)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	h.Data["json"] = bindings
+	if len(bindings) == 0 {
+		beego.Debug("[C] Got nothing")
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title deleteScopeBinding
+// @Success 204
+// @Failure 404
+// @router /:id [delete]
+func (h *ScopesController) Delete() {
+	id := h.GetString(":id")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	bindings, err := models.GetScopeBindings(&models.ScopeBindings{Id: id}, 1, 0)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(bindings) == 0 {
+		beego.Debug("[C] Got nothing with id:", id)
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	err = models.DeleteScopeBinding(bindings[0])
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to delete with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	h.Ctx.Output.SetStatus(http.StatusNoContent)
+}
@@ -10,45 +10,10 @@ import (
 	"github.com/astaxie/beego"
 )
 
-func init() {
-	AddPrivilege("GET", "^/api/v1/users", models.RoleFlagUser)
-	AddPrivilege("PUT", "^/api/v1/users", models.RoleFlagUser)
-}
-
 type UserController struct {
 	beego.Controller
 }
 
-func (h *UserController) Prepare() {
-	if h.Ctx.Input.Header("Signature") != "" {
-		err := common.AuthWithKey(h.Ctx)
-		if err != nil {
-			h.Data["json"] = map[string]string{
-				"error": err.Error(),
-			}
-			h.Ctx.Output.SetStatus(http.StatusForbidden)
-			h.ServeJSON()
-		}
-	} else {
-		id := h.GetSession("id")
-		if id == nil {
-			h.Data["json"] = map[string]string{
-				"error": "You need login first.",
-			}
-			h.Ctx.Output.SetStatus(http.StatusUnauthorized)
-			h.ServeJSON()
-		} else {
-			if !CheckPrivileges(id.(string), h.Ctx) {
-				h.Data["json"] = map[string]string{
-					"error": "No privileges.",
-				}
-				h.Ctx.Output.SetStatus(http.StatusForbidden)
-				h.ServeJSON()
-			}
-		}
-	}
-}
-
 // @Title createUser
 // @router / [post]
 func (h *UserController) Post() {
@@ -64,7 +29,7 @@ func (h *UserController) Post() {
 		h.Ctx.Output.SetStatus(http.StatusBadRequest)
 		return
 	}
-	beego.Debug("[C] Got data:", user)
+	beego.Debug("[C] Got data:", user.Name)
 	id, err := models.AddUser(user)
 	if err != nil {
 		beego.Warn("[C] Got error:", err)
@@ -206,10 +171,10 @@ func (h *UserController) Put() {
 			return
 		}
 
-		sessionId := h.GetSession("id")
-		if sessionId != nil {
+		sessionId := CurrentUserId(h.Ctx)
+		if sessionId != "" {
 			userNow := &models.Users{
-				Id: sessionId.(string),
+				Id: sessionId,
 			}
 			userNows, err := models.GetUser(userNow, 0, 0)
 			if err != nil {
@@ -240,10 +205,9 @@ func (h *UserController) Put() {
 		}
 		user.Id = users[0].Id
 		user.Removable = users[0].Removable // Removable should not be changed.
-		if user.Password != users[0].Password {
-			user.Password = common.EncryptPassword(user.Password)
-		}
-		beego.Debug("[C] Got user data:", user)
+		// Only a new password in body changes it.
+		user.Password = users[0].Password
+		beego.Debug("[C] Got user data:", user.Name)
 		err = models.UpdateUser(user)
 		if err != nil {
 			h.Data["json"] = map[string]string{
@@ -257,3 +221,119 @@ func (h *UserController) Put() {
 		h.Ctx.Output.SetStatus(http.StatusAccepted)
 	}
 }
+
+// @Title unlockUser
+// @Description clear login failures and lockout of user, only for admins
+// @router /:name/unlock [post]
+func (h *UserController) Unlock() {
+	name := h.GetString(":name")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	id := CurrentUserId(h.Ctx)
+	if !IsAdmin(id) {
+		h.Data["json"] = map[string]string{
+			"error": "No privileges.",
+		}
+		h.Ctx.Output.SetStatus(http.StatusForbidden)
+		return
+	}
+	if name != "" {
+		err := common.UnlockLogin(name)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to unlock with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		models.LogAuthEvent(
+			models.AuthEventUnlocked, name, common.ClientIP(h.Ctx),
+			fmt.Sprint("By ", h.GetSession("name")),
+		)
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+type profileRequest struct {
+	ShowName    string `json:"name"`
+	Password    string `json:"password"`
+	OldPassword string `json:"old_password"`
+}
+
+// @Title updateProfile
+// @Description users change their own name and password, but never roles.
+// old_password is required to change password.
+// @router /:name/profile [put]
+func (h *UserController) Profile() {
+	name := h.GetString(":name")
+	defer h.ServeJSON()
+	users, err := models.GetUser(&models.Users{Name: name}, 1, 0)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with name:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if name == "" || len(users) == 0 {
+		beego.Debug("[C] Got nothing with name:", name)
+		h.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	user := users[0]
+	if user.Id != CurrentUserId(h.Ctx) {
+		h.Data["json"] = map[string]string{
+			"error": "Only your own profile can be changed here.",
+		}
+		h.Ctx.Output.SetStatus(http.StatusForbidden)
+		return
+	}
+	req := new(profileRequest)
+	err = json.Unmarshal(h.Ctx.Input.RequestBody, req)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		h.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	if req.ShowName != "" {
+		user.ShowName = req.ShowName
+	}
+	if req.Password != "" {
+		if ok, _ := common.VerifyPassword(user.Password, req.OldPassword); !ok {
+			h.Data["json"] = map[string]string{
+				"error": models.ErrorBadCredentials.Error(),
+			}
+			h.Ctx.Output.SetStatus(http.StatusForbidden)
+			return
+		}
+		user.Password, err = common.EncryptPassword(req.Password)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": "Failed to hash password",
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+	err = models.UpdateUserProfile(user)
+	if err != nil {
+		h.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to update with name:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		h.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	h.Ctx.Output.SetStatus(http.StatusAccepted)
+}
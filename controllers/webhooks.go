@@ -0,0 +1,272 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"moduleab_server/models"
+	"net/http"
+
+	"github.com/astaxie/beego"
+)
+
+type WebhookSubscriptionsController struct {
+	beego.Controller
+}
+
+// Secrets never leave the server through this API.
+func hideWebhookSecrets(subs []*models.WebhookSubscriptions) []*models.WebhookSubscriptions {
+	for _, v := range subs {
+		v.Secret = ""
+	}
+	return subs
+}
+
+// getSubscription gets subscription of id, it writes the response
+// itself if it returns nil.
+func (w *WebhookSubscriptionsController) getSubscription(id string) *models.WebhookSubscriptions {
+	subs, err := models.GetWebhookSubscriptions(&models.WebhookSubscriptions{Id: id}, 1, 0)
+	if err != nil {
+		w.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		w.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return nil
+	}
+	if id == "" || len(subs) == 0 {
+		beego.Debug("[C] Got nothing with id:", id)
+		w.Ctx.Output.SetStatus(http.StatusNotFound)
+		return nil
+	}
+	return subs[0]
+}
+
+// @Title createWebhookSubscription
+// @Description events is like "record.*,oasjob.completed", empty for all
+// @Param	subscription	body	models.WebhookSubscriptions	true	"subscription"
+// @Success 201
+// @router / [post]
+func (w *WebhookSubscriptionsController) Post() {
+	sub := new(models.WebhookSubscriptions)
+	defer w.ServeJSON()
+	err := json.Unmarshal(w.Ctx.Input.RequestBody, sub)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		w.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		w.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	beego.Debug("[C] Got data:", sub.Name, sub.Url)
+	id, err := models.AddWebhookSubscription(sub)
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		w.Data["json"] = map[string]string{
+			"message": "Failed to add new webhook subscription",
+			"error":   err.Error(),
+		}
+		w.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	beego.Debug("[C] Got id:", id)
+	w.Data["json"] = map[string]string{
+		"id": id,
+	}
+	w.Ctx.Output.SetStatus(http.StatusCreated)
+}
+
+// @Title listWebhookSubscriptions
+// @Success 200 {object} []models.WebhookSubscriptions
+// @router / [get]
+func (w *WebhookSubscriptionsController) GetAll() {
+	limit, _ := w.GetInt("limit", 0)
+	index, _ := w.GetInt("index", 0)
+	defer w.ServeJSON()
+	subs, err := models.GetWebhookSubscriptions(&models.WebhookSubscriptions{}, limit, index)
+	if err != nil {
+		w.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		w.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	w.Data["json"] = hideWebhookSecrets(subs)
+	if len(subs) == 0 {
+		beego.Debug("[C] Got nothing")
+		w.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		w.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title getWebhookSubscription
+// @Success 200 {object} models.WebhookSubscriptions
+// @router /:id [get]
+func (w *WebhookSubscriptionsController) Get() {
+	id := w.GetString(":id")
+	defer w.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	sub := w.getSubscription(id)
+	if sub == nil {
+		return
+	}
+	w.Data["json"] = hideWebhookSecrets([]*models.WebhookSubscriptions{sub})[0]
+	w.Ctx.Output.SetStatus(http.StatusOK)
+}
+
+// @Title updateWebhookSubscription
+// @Description Secret is kept if it is empty in body.
+// @router /:id [put]
+func (w *WebhookSubscriptionsController) Put() {
+	id := w.GetString(":id")
+	defer w.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	sub := w.getSubscription(id)
+	if sub == nil {
+		return
+	}
+	sub.Secret = ""
+	err := json.Unmarshal(w.Ctx.Input.RequestBody, sub)
+	sub.Id = id
+	if err != nil {
+		beego.Warn("[C] Got error:", err)
+		w.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   err.Error(),
+		}
+		w.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	err = models.UpdateWebhookSubscription(sub)
+	if err != nil {
+		w.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to update with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		w.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	w.Ctx.Output.SetStatus(http.StatusAccepted)
+}
+
+// @Title deleteWebhookSubscription
+// @Success 204
+// @router /:id [delete]
+func (w *WebhookSubscriptionsController) Delete() {
+	id := w.GetString(":id")
+	defer w.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	sub := w.getSubscription(id)
+	if sub == nil {
+		return
+	}
+	err := models.DeleteWebhookSubscription(sub)
+	if err != nil {
+		w.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to delete with id:", id),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		w.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	w.Ctx.Output.SetStatus(http.StatusNoContent)
+}
+
+// @Title listWebhookDeliveries
+// @Description delivery log of subscription, newest first
+// @Param	status	query	string	false	"pending, sent or failed"
+// @Param	event	query	string	false	"event"
+// @Param	resource	query	string	false	"like records/<id>"
+// @Success 200 {object} []models.WebhookDeliveries
+// @router /:id/deliveries [get]
+func (w *WebhookSubscriptionsController) GetDeliveries() {
+	id := w.GetString(":id")
+	limit, _ := w.GetInt("limit", 50)
+	index, _ := w.GetInt("index", 0)
+	defer w.ServeJSON()
+	sub := w.getSubscription(id)
+	if sub == nil {
+		return
+	}
+	deliveries, err := models.GetWebhookDeliveries(&models.WebhookDeliveries{
+		Subscription: sub,
+		Status:       w.GetString("status"),
+		Event:        w.GetString("event"),
+		Resource:     w.GetString("resource"),
+	}, limit, index)
+	if err != nil {
+		w.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		w.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	w.Data["json"] = deliveries
+	if len(deliveries) == 0 {
+		beego.Debug("[C] Got nothing")
+		w.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		w.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title redeliverWebhook
+// @Description queue a sent or failed delivery again, as a new delivery
+// @Success 201
+// @Failure 409 delivery is still pending
+// @router /:id/deliveries/:delivery/redeliver [post]
+func (w *WebhookSubscriptionsController) Redeliver() {
+	id := w.GetString(":id")
+	deliveryId := w.GetString(":delivery")
+	defer w.ServeJSON()
+	sub := w.getSubscription(id)
+	if sub == nil {
+		return
+	}
+	deliveries, err := models.GetWebhookDeliveries(&models.WebhookDeliveries{
+		Id:           deliveryId,
+		Subscription: sub,
+	}, 1, 0)
+	if err != nil {
+		w.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with id:", deliveryId),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		w.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if deliveryId == "" || len(deliveries) == 0 {
+		beego.Debug("[C] Got nothing with id:", deliveryId)
+		w.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	deliveries[0].Subscription = sub
+	newId, err := models.RedeliverWebhook(deliveries[0])
+	if err != nil {
+		w.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to redeliver with id:", deliveryId),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		if err == models.ErrorWebhookPending {
+			w.Ctx.Output.SetStatus(http.StatusConflict)
+		} else {
+			w.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Data["json"] = map[string]string{
+		"id": newId,
+	}
+	w.Ctx.Output.SetStatus(http.StatusCreated)
+}
@@ -11,8 +11,12 @@ import (
 	"runtime"
 
 	_ "moduleab_server/docs"
+	"moduleab_server/models"
 	"moduleab_server/policies"
 	_ "moduleab_server/routers"
+	_ "moduleab_server/storage/aliyun"
+	_ "moduleab_server/storage/local"
+	_ "moduleab_server/storage/s3"
 	"moduleab_server/version"
 	"os"
 
@@ -115,6 +119,12 @@ func main() {
 		err = orm.RunSyncdb("default", false, false)
 	}
 
+	if err != nil {
+		beego.Alert("Database error:", err, ". go exit.")
+		os.Exit(1)
+	}
+	// Databases made before permissions existed have none.
+	err = models.InitPermissions()
 	if err != nil {
 		beego.Alert("Database error:", err, ". go exit.")
 		os.Exit(1)
@@ -127,6 +137,13 @@ func main() {
 	)
 	beego.Info("Run check oas job...")
 	go policies.CheckOasJob()
+	go policies.PurgeSignals()
+	go policies.ScheduleClientJobs()
+	go policies.CheckMissedBackups()
+	go policies.ExpireBackupRuns()
+	go policies.DeliverNotifications()
+	go policies.DeliverWebhooks()
+	go models.RunSignalBroker()
 	beego.Info("All is ready, go running...")
 	beego.BConfig.WebConfig.Session.SessionOn = true
 	beego.BConfig.WebConfig.Session.SessionName = "Session_MobuleAB"
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"github.com/pborman/uuid"
+)
+
+// Tokens look like "mab_<base64>", the prefix makes them easy to
+// find in scripts and logs.
+const AccessTokenPrefix = "mab_"
+
+var (
+	ErrorAccessTokenInvalid = errors.New("Invalid access token")
+	ErrorAccessTokenExpired = errors.New("Access token has expired")
+	ErrorAccessTokenRevoked = errors.New("Access token has been revoked")
+	ErrorAccessTokenScope   = errors.New("Scope of access token is not granted to user")
+)
+
+// 个人访问令牌，只保存令牌的SHA256
+// Scopes为逗号分隔的权限，如records:read，为空表示用户的全部权限
+type AccessTokens struct {
+	Id           string    `orm:"pk;size(36)" json:"id"`
+	Name         string    `orm:"size(64)" json:"name" valid:"Required"`
+	User         *Users    `orm:"rel(fk);on_delete(cascade)" json:"-"`
+	Hash         string    `orm:"size(64);unique;index" json:"-"`
+	Prefix       string    `orm:"size(16)" json:"prefix"`
+	Scopes       string    `orm:"size(512);null" json:"scopes"`
+	Revoked      bool      `orm:"default(0)" json:"revoked"`
+	CreatedTime  time.Time `orm:"auto_now_add;type(datetime)" json:"created_time"`
+	ExpiresTime  time.Time `orm:"type(datetime)" json:"expires_time"`
+	LastUsedTime time.Time `orm:"type(datetime);null" json:"last_used_time"`
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(AccessTokens))
+	} else {
+		orm.RegisterModel(new(AccessTokens))
+	}
+}
+
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *AccessTokens) ScopeList() []string {
+	r := make([]string, 0)
+	for _, v := range strings.Split(a.Scopes, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+// Allows tells if token may be used for required, which also
+// needs to be granted by roles of its user.
+func (a *AccessTokens) Allows(required string) bool {
+	scopes := a.ScopeList()
+	if len(scopes) == 0 {
+		return true
+	}
+	for _, v := range scopes {
+		if MatchPermission(v, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddAccessToken saves a new token of a.User, and returns the token
+// itself, which is only shown this time. ExpiresTime defaults to
+// security::accesstokenexpire days later.
+func AddAccessToken(a *AccessTokens) (string, error) {
+	beego.Debug("[M] Got data:", a.Name)
+	if a.User == nil || a.User.Id == "" {
+		return "", fmt.Errorf("Bad info: User:Can not be empty")
+	}
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		return "", err
+	}
+	if !valid {
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return "", fmt.Errorf("Bad info: %s", errS)
+	}
+	scopes := a.ScopeList()
+	for _, v := range scopes {
+		ok, err := HasPermission(a.User.Id, v)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("%s: %s", ErrorAccessTokenScope, v)
+		}
+	}
+	a.Scopes = strings.Join(scopes, ",")
+	if a.ExpiresTime.IsZero() {
+		days := beego.AppConfig.DefaultInt("security::accesstokenexpire", 90)
+		a.ExpiresTime = time.Now().AddDate(0, 0, days)
+	}
+
+	b := make([]byte, 30)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := AccessTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	a.Id = uuid.New()
+	a.Hash = hashAccessToken(token)
+	a.Prefix = token[:12]
+	a.Revoked = false
+	o := orm.NewOrm()
+	_, err = o.Insert(a)
+	if err != nil {
+		return "", err
+	}
+	beego.Debug("[M] Access token saved:", a.Id)
+	return token, nil
+}
+
+func RevokeAccessToken(a *AccessTokens) error {
+	beego.Debug("[M] Got data:", a.Id)
+	o := orm.NewOrm()
+	a.Revoked = true
+	_, err := o.Update(a, "Revoked")
+	return err
+}
+
+// If get all, just use &AccessTokens{}
+func GetAccessTokens(cond *AccessTokens, limit, index int) ([]*AccessTokens, error) {
+	r := make([]*AccessTokens, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("access_tokens")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Hash != "" {
+		q = q.Filter("hash", cond.Hash)
+	}
+	if cond.User != nil {
+		q = q.Filter("user_id", cond.User.Id)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.OrderBy("-created_time").All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// AuthenticateAccessToken finds the valid token, and marks it used.
+func AuthenticateAccessToken(token string) (*AccessTokens, error) {
+	if !strings.HasPrefix(token, AccessTokenPrefix) {
+		return nil, ErrorAccessTokenInvalid
+	}
+	tokens, err := GetAccessTokens(&AccessTokens{Hash: hashAccessToken(token)}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, ErrorAccessTokenInvalid
+	}
+	a := tokens[0]
+	if a.Revoked {
+		return nil, ErrorAccessTokenRevoked
+	}
+	if time.Now().After(a.ExpiresTime) {
+		return nil, ErrorAccessTokenExpired
+	}
+	a.LastUsedTime = time.Now()
+	o := orm.NewOrm()
+	_, err = o.Update(a, "LastUsedTime")
+	if err != nil {
+		// Token is still good, only last-used time is lost.
+		beego.Warn("[M] Failed to update access token:", err)
+	}
+	return a, nil
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/pborman/uuid"
+)
+
+const (
+	AuthEventLoginSuccess = "login_success"
+	AuthEventLoginFailure = "login_failure"
+	AuthEventLocked       = "locked"
+	AuthEventUnlocked     = "unlocked"
+)
+
+// 登录相关的审计日志
+type AuthEvents struct {
+	Id       string    `orm:"pk;size(36)" json:"id"`
+	Type     string    `orm:"size(32);index" json:"type"`
+	UserName string    `orm:"size(32);index" json:"loginname"`
+	Ip       string    `orm:"size(45);index" json:"ip"`
+	Message  string    `orm:"size(256);null" json:"message"`
+	Time     time.Time `orm:"auto_now_add;type(datetime);index" json:"time"`
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(AuthEvents))
+	} else {
+		orm.RegisterModel(new(AuthEvents))
+	}
+}
+
+func AddAuthEvent(a *AuthEvents) (string, error) {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	a.Id = uuid.New()
+	_, err := o.Insert(a)
+	if err != nil {
+		return "", err
+	}
+	return a.Id, nil
+}
+
+// LogAuthEvent adds an event, failure is only logged
+// since it should never break the login itself.
+func LogAuthEvent(eventType, name, ip, message string) {
+	_, err := AddAuthEvent(&AuthEvents{
+		Type:     eventType,
+		UserName: name,
+		Ip:       ip,
+		Message:  message,
+	})
+	if err != nil {
+		beego.Warn("[M] Failed to log auth event:", err)
+	}
+}
+
+// If get all, just use &AuthEvents{}, newest first.
+func GetAuthEvents(cond *AuthEvents, limit, index int) ([]*AuthEvents, error) {
+	r := make([]*AuthEvents, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("auth_events")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Type != "" {
+		q = q.Filter("type", cond.Type)
+	}
+	if cond.UserName != "" {
+		q = q.Filter("user_name", cond.UserName)
+	}
+	if cond.Ip != "" {
+		q = q.Filter("ip", cond.Ip)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.OrderBy("-time").All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
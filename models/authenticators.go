@@ -0,0 +1,192 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"moduleab_server/common"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/pborman/uuid"
+)
+
+const (
+	UserSourceLocal = "local"
+	UserSourceLdap  = "ldap"
+	UserSourceOidc  = "oidc"
+)
+
+var (
+	ErrorAuthenticatorNotFound = errors.New("Authenticator not found")
+	ErrorUserSourceConflict    = errors.New("User exists with another source")
+	ErrorUserNoRoles           = errors.New("No role is mapped for user")
+)
+
+// Authenticator checks name and password of a login, and returns the
+// user in database. It returns ErrorBadCredentials if they are wrong,
+// so the next one can be tried.
+type Authenticator interface {
+	Authenticate(name, password string) (*Users, error)
+}
+
+var authenticators = make(map[string]Authenticator)
+
+// RegisterAuthenticator makes an authenticator available by name
+// for auth::backends. It panics if called twice with the same name.
+func RegisterAuthenticator(name string, a Authenticator) {
+	if a == nil {
+		panic("models: RegisterAuthenticator authenticator is nil")
+	}
+	if _, ok := authenticators[name]; ok {
+		panic("models: RegisterAuthenticator called twice for " + name)
+	}
+	authenticators[name] = a
+}
+
+func init() {
+	RegisterAuthenticator(UserSourceLocal, LocalAuthenticator{})
+	RegisterAuthenticator(UserSourceLdap, LdapAuthenticator{})
+}
+
+// Authenticate is the only way to check a password, use it instead of
+// looking users up with one. Authenticators of auth::backends are tried
+// in order, a failing directory does not stop local users from login.
+func Authenticate(name, password string) (*Users, error) {
+	backends := beego.AppConfig.Strings("auth::backends")
+	if len(backends) == 0 {
+		backends = []string{UserSourceLocal}
+	}
+	var lastErr error = ErrorBadCredentials
+	for _, v := range backends {
+		a, ok := authenticators[v]
+		if !ok {
+			beego.Warn("[M] Got error:", ErrorAuthenticatorNotFound, v)
+			continue
+		}
+		user, err := a.Authenticate(name, password)
+		if err == nil {
+			return user, nil
+		}
+		if err != ErrorBadCredentials {
+			beego.Warn("[M] Authenticator", v, "got error:", err)
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+// LdapAuthenticator checks passwords by binding to directory of section
+// ldap, and keeps users and their roles in database the same as it.
+type LdapAuthenticator struct{}
+
+func (LdapAuthenticator) Authenticate(name, password string) (*Users, error) {
+	conf := common.NewLdapConfig()
+	entry, err := common.LdapAuthenticate(conf, name, password)
+	if err == common.ErrorLdapBadUser {
+		return nil, ErrorBadCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	user, err := ProvisionUser(UserSourceLdap, "", name, entry.ShowName, conf.RoleNames(entry.Groups))
+	if err == ErrorUserSourceConflict || err == ErrorUserNoRoles {
+		beego.Warn("[M] Refused ldap user:", name, err)
+		return nil, ErrorBadCredentials
+	}
+	return user, err
+}
+
+// ProvisionUser adds user of a directory on the first login, and
+// updates its show name and roles later, since directory is the truth.
+// Users with a subject are found by it, name is only their login name
+// then, and it can not be one of another user.
+func ProvisionUser(source, subject, name, showName string, roleNames []string) (*Users, error) {
+	beego.Debug("[M] Got data:", source, name, roleNames)
+	if showName == "" {
+		showName = name
+	}
+	roles := make([]*Roles, 0)
+	for _, v := range roleNames {
+		r, err := GetRole(&Roles{Name: v}, 1, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(r) == 0 {
+			beego.Warn("[M] Role not found:", v)
+			continue
+		}
+		roles = append(roles, r[0])
+	}
+	if len(roles) == 0 {
+		return nil, ErrorUserNoRoles
+	}
+
+	cond := &Users{Name: name}
+	if subject != "" {
+		cond = &Users{Subject: &subject}
+	}
+	users, err := GetUser(cond, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if subject != "" && len(users) == 0 {
+		taken, err := GetUser(&Users{Name: name}, 1, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(taken) != 0 {
+			return nil, ErrorUserSourceConflict
+		}
+	}
+	o := orm.NewOrm()
+	err = o.Begin()
+	if err != nil {
+		return nil, err
+	}
+	var user *Users
+	if len(users) == 0 {
+		// Password is never used, but it must not be guessable.
+		b := make([]byte, 30)
+		_, err = rand.Read(b)
+		if err != nil {
+			o.Rollback()
+			return nil, err
+		}
+		user = &Users{
+			Id:        uuid.New(),
+			Name:      name,
+			ShowName:  showName,
+			Removable: true,
+			Source:    source,
+		}
+		if subject != "" {
+			user.Subject = &subject
+		}
+		user.Password, err = common.EncryptPassword(base64.StdEncoding.EncodeToString(b))
+		if err == nil {
+			_, err = o.Insert(user)
+		}
+		beego.Info("[M] Provision user:", source, name)
+	} else {
+		user = users[0]
+		if user.Source != source {
+			o.Rollback()
+			return nil, ErrorUserSourceConflict
+		}
+		user.ShowName = showName
+		_, err = o.Update(user, "ShowName")
+		if err == nil {
+			_, err = o.QueryM2M(user, "Roles").Clear()
+		}
+	}
+	if err == nil {
+		_, err = o.QueryM2M(user, "Roles").Add(roles)
+	}
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	o.Commit()
+	user.Roles = roles
+	return user, nil
+}
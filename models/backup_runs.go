@@ -0,0 +1,360 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/pborman/uuid"
+)
+
+const (
+	BackupRunStatusRunning   = "running"
+	BackupRunStatusSucceeded = "succeeded"
+	BackupRunStatusFailed    = "failed"
+)
+
+// Runs agents start on their own, not by a dispatch.
+const BackupRunTriggerAgent = "agent"
+
+var (
+	ErrorBackupRunNotFound  = errors.New("Backup run not found")
+	ErrorBackupRunFinished  = errors.New("Backup run has finished")
+	ErrorBackupRunNoHost    = errors.New("Host is required")
+	ErrorBackupRunNotBackup = errors.New("Signal is not a backup")
+)
+
+// 备份执行记录，agent每次备份一条，不论成功与否，
+// 成功时Record为其产生的备份记录
+type BackupRuns struct {
+	Id           string      `orm:"pk;size(36)" json:"id"`
+	Host         *Hosts      `orm:"rel(fk);on_delete(cascade)" json:"host"`
+	Path         *Paths      `orm:"rel(fk);null;on_delete(set_null)" json:"path"`
+	Job          *ClientJobs `orm:"rel(fk);null;on_delete(set_null)" json:"job"`
+	Record       *Records    `orm:"rel(fk);null;on_delete(set_null)" json:"record"`
+	SignalId     string      `orm:"size(36);index" json:"signal_id"`
+	Attempt      int         `orm:"default(0)" json:"attempt"` // Delivery attempt of signal
+	Trigger      string      `orm:"size(16)" json:"trigger"`
+	Status       string      `orm:"size(16);index" json:"status"`
+	Percent      int         `json:"percent"`
+	Bytes        int64       `json:"bytes"`
+	Files        int         `json:"files"`
+	Message      string      `orm:"size(1024);null" json:"message"`
+	StartedTime  time.Time   `orm:"type(datetime);index" json:"started_time"`
+	UpdatedTime  time.Time   `orm:"type(datetime)" json:"updated_time"`
+	FinishedTime time.Time   `orm:"type(datetime);null" json:"finished_time"`
+	Duration     int64       `json:"duration"` // Second
+}
+
+// BackupReport is progress of a backup reported by agent,
+// or its result if Ok is not nil.
+type BackupReport struct {
+	Percent int    `json:"percent"`
+	Bytes   int64  `json:"bytes"`
+	Files   int    `json:"files"`
+	Message string `json:"message"`
+	Record  string `json:"record"`
+	Ok      *bool  `json:"ok"`
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(BackupRuns))
+	} else {
+		orm.RegisterModel(new(BackupRuns))
+	}
+}
+
+func (a *BackupRuns) Finished() bool {
+	return a.Status == BackupRunStatusSucceeded || a.Status == BackupRunStatusFailed
+}
+
+// Apply updates run with report r at now. Record of r is left
+// to the caller, which should check it is of the host.
+func (a *BackupRuns) Apply(r *BackupReport, now time.Time) error {
+	if a.Finished() {
+		return ErrorBackupRunFinished
+	}
+	a.UpdatedTime = now
+	if r.Percent > a.Percent {
+		a.Percent = r.Percent
+	}
+	if r.Bytes > 0 {
+		a.Bytes = r.Bytes
+	}
+	if r.Files > 0 {
+		a.Files = r.Files
+	}
+	if r.Message != "" {
+		a.Message = r.Message
+		if len(a.Message) > 1024 {
+			a.Message = a.Message[:1024]
+		}
+	}
+	if r.Ok == nil {
+		return nil
+	}
+	a.Status = BackupRunStatusFailed
+	if *r.Ok {
+		a.Status = BackupRunStatusSucceeded
+		a.Percent = 100
+	}
+	a.FinishedTime = now
+	a.Duration = int64(now.Sub(a.StartedTime) / time.Second)
+	return nil
+}
+
+// AddBackupRun starts a run, Trigger defaults to agent.
+func AddBackupRun(a *BackupRuns) (string, error) {
+	beego.Debug("[M] Got data:", a)
+	if a.Host == nil || a.Host.Id == "" {
+		return "", ErrorBackupRunNoHost
+	}
+	now := time.Now()
+	a.Id = uuid.New()
+	a.Status = BackupRunStatusRunning
+	a.Record = nil
+	if a.Trigger == "" {
+		a.Trigger = BackupRunTriggerAgent
+	}
+	if a.StartedTime.IsZero() || a.StartedTime.After(now) {
+		a.StartedTime = now
+	}
+	a.UpdatedTime = now
+	_, err := orm.NewOrm().Insert(a)
+	if err != nil {
+		return "", err
+	}
+	return a.Id, nil
+}
+
+// ReportBackupRun applies r to run id of host.
+func ReportBackupRun(hostId, id string, r *BackupReport) (*BackupRuns, error) {
+	runs, err := GetBackupRuns(&BackupRuns{Id: id, Host: &Hosts{Id: hostId}}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, ErrorBackupRunNotFound
+	}
+	return runs[0], reportBackupRun(runs[0], r)
+}
+
+func reportBackupRun(a *BackupRuns, r *BackupReport) error {
+	err := a.Apply(r, time.Now())
+	if err != nil {
+		return err
+	}
+	if r.Record != "" {
+		records, err := GetRecords(&Records{Id: r.Record}, 1, 0, OrderDesc, OrderDesc)
+		if err != nil {
+			return err
+		}
+		if len(records) != 0 && records[0].Host != nil &&
+			records[0].Host.Id == a.Host.Id {
+			a.Record = records[0]
+		} else {
+			beego.Warn("[M] Record not of host:", r.Record, a.Host.Id)
+		}
+	}
+	_, err = orm.NewOrm().Update(a)
+	return err
+}
+
+// ReportSignalRun applies r to run of backup signal of host. A run is
+// started at the first report of every delivery attempt, so a retried
+// signal never reports into the run of the attempt before it.
+// Reports of other signals are ignored, with nil run.
+func ReportSignalRun(hostId, signalId string, r *BackupReport) (*BackupRuns, error) {
+	a, err := getHostSignal(hostId, signalId)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := GetBackupRuns(&BackupRuns{
+		Host:     &Hosts{Id: hostId},
+		SignalId: signalId,
+		Attempt:  a.Attempts,
+	}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) != 0 {
+		return runs[0], reportBackupRun(runs[0], r)
+	}
+	s, err := a.Signal()
+	if err != nil {
+		return nil, err
+	}
+	if t, _ := s["type"].(float64); int(t) != SignalTypeBackup {
+		return nil, nil
+	}
+	run, err := newSignalRun(hostId, a)
+	if err != nil {
+		return nil, err
+	}
+	err = abandonBackupRuns(hostId, signalId, a.Attempts)
+	if err != nil {
+		return nil, err
+	}
+	_, err = AddBackupRun(run)
+	if err != nil {
+		return nil, err
+	}
+	return run, reportBackupRun(run, r)
+}
+
+// newSignalRun is the run of the current attempt of backup signal a,
+// with job, path and trigger of its dispatch.
+func newSignalRun(hostId string, a *Signals) (*BackupRuns, error) {
+	run := &BackupRuns{
+		Host:     &Hosts{Id: hostId},
+		SignalId: a.Id,
+		Attempt:  a.Attempts,
+		Trigger:  DispatchTriggerManual,
+	}
+	dispatches, err := GetDispatches(&Dispatches{SignalId: a.Id}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(dispatches) != 0 {
+		run.Job = dispatches[0].Job
+		run.Path = dispatches[0].Path
+		run.Trigger = dispatches[0].Trigger
+		if a.Attempts <= 1 {
+			run.StartedTime = dispatches[0].DispatchedTime
+		}
+	}
+	return run, nil
+}
+
+// failBackupRuns fails runs of q still running with message.
+func failBackupRuns(q orm.QuerySeter, now time.Time, message string) (int64, error) {
+	if len(message) > 1024 {
+		message = message[:1024]
+	}
+	return q.Filter("status", BackupRunStatusRunning).
+		Update(orm.Params{
+			"status":        BackupRunStatusFailed,
+			"message":       message,
+			"updated_time":  now,
+			"finished_time": now,
+		})
+}
+
+// abandonBackupRuns fails runs of signal still running from attempts
+// before attempt, their agent gave up on them.
+func abandonBackupRuns(hostId, signalId string, attempt int) error {
+	n, err := failBackupRuns(orm.NewOrm().QueryTable("backup_runs").
+		Filter("host_id", hostId).
+		Filter("signal_id", signalId).
+		Filter("attempt__lt", attempt),
+		time.Now(), "Signal was delivered again")
+	if n != 0 {
+		beego.Debug("[M] Abandoned runs of signal:", signalId, n)
+	}
+	return err
+}
+
+// failSignalRuns fails runs of dead signal a still running. If its agent
+// never reported the last attempt of a backup signal, a failed run is
+// added for it, so history tells why the backup did not happen.
+func failSignalRuns(hostId string, a *Signals) error {
+	now := time.Now()
+	message := "Signal is dead: " + a.LastError
+	n, err := failBackupRuns(orm.NewOrm().QueryTable("backup_runs").
+		Filter("host_id", hostId).
+		Filter("signal_id", a.Id),
+		now, message)
+	if err != nil {
+		return err
+	}
+	if n != 0 {
+		beego.Debug("[M] Failed runs of dead signal:", a.Id, n)
+	}
+	s, err := a.Signal()
+	if err != nil {
+		return err
+	}
+	if t, _ := s["type"].(float64); int(t) != SignalTypeBackup {
+		return nil
+	}
+	runs, err := GetBackupRuns(&BackupRuns{
+		Host:     &Hosts{Id: hostId},
+		SignalId: a.Id,
+		Attempt:  a.Attempts,
+	}, 1, 0)
+	if err != nil || len(runs) != 0 {
+		return err
+	}
+	run, err := newSignalRun(hostId, a)
+	if err != nil {
+		return err
+	}
+	_, err = AddBackupRun(run)
+	if err != nil {
+		return err
+	}
+	ok := false
+	return reportBackupRun(run, &BackupReport{Ok: &ok, Message: message})
+}
+
+// ExpireBackupRuns fails runs not reported for longer than the signal
+// visibility timeout, by when their signal is delivered again anyway.
+func ExpireBackupRuns(now time.Time) (int64, error) {
+	return failBackupRuns(orm.NewOrm().QueryTable("backup_runs").
+		Filter("updated_time__lt", now.Add(-SignalVisibilityTimeout())),
+		now, "No report within signal visibility timeout")
+}
+
+// If get all, just use &BackupRuns{}, newest first.
+func GetBackupRuns(cond *BackupRuns, limit, index int, times ...time.Time) ([]*BackupRuns, error) {
+	return GetBackupRunsInScope(nil, cond, limit, index, times...)
+}
+
+// GetBackupRunsInScope is GetBackupRuns which only gets runs of hosts in
+// scope. Times are since and until of StartedTime, zero for no limit.
+func GetBackupRunsInScope(scope *Scope, cond *BackupRuns, limit, index int,
+	times ...time.Time) ([]*BackupRuns, error) {
+	r := make([]*BackupRuns, 0)
+	o := orm.NewOrm()
+	q := scope.filter(o.QueryTable("backup_runs"), "host__app_set__id", "")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Host != nil && cond.Host.Id != "" {
+		q = q.Filter("host_id", cond.Host.Id)
+	}
+	if cond.Path != nil && cond.Path.Id != "" {
+		q = q.Filter("path_id", cond.Path.Id)
+	}
+	if cond.Job != nil && cond.Job.Id != "" {
+		q = q.Filter("job_id", cond.Job.Id)
+	}
+	if cond.SignalId != "" {
+		q = q.Filter("signal_id", cond.SignalId)
+	}
+	if cond.Attempt > 0 {
+		q = q.Filter("attempt", cond.Attempt)
+	}
+	if cond.Status != "" {
+		q = q.Filter("status", cond.Status)
+	}
+	if len(times) > 0 && !times[0].IsZero() {
+		q = q.Filter("started_time__gte", times[0])
+	}
+	if len(times) > 1 && !times[1].IsZero() {
+		q = q.Filter("started_time__lte", times[1])
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.RelatedSel("Host", "Path", "Record").OrderBy("-started_time").All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -1,8 +1,10 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"moduleab_server/common"
+	"moduleab_server/storage"
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/orm"
@@ -21,6 +23,35 @@ type BackupSets struct {
 	Paths    []*Paths    `orm:"reverse(many)" json:"paths"`
 }
 
+var (
+	ErrorNoHotStorage  = errors.New("Backup set has no hot storage")
+	ErrorNoColdStorage = errors.New("Backup set has no cold storage")
+)
+
+// GetHotStorage returns the storage agents upload to, it's chosen by Oss.Type.
+func (b *BackupSets) GetHotStorage() (storage.HotStorage, error) {
+	if b.Oss == nil {
+		return nil, ErrorNoHotStorage
+	}
+	conf, err := b.Oss.StorageConfig()
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewHotStorage(conf)
+}
+
+// GetColdStorage returns the storage records archived to, it's chosen by Oas.Type.
+func (b *BackupSets) GetColdStorage() (storage.ColdStorage, error) {
+	if b.Oas == nil {
+		return nil, ErrorNoColdStorage
+	}
+	conf, err := b.Oas.StorageConfig()
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewColdStorage(conf)
+}
+
 func init() {
 	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
 		orm.RegisterModelWithPrefix(prefix, new(BackupSets))
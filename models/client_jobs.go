@@ -3,6 +3,7 @@ package models
 import (
 	"fmt"
 	"moduleab_server/common"
+	"time"
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/orm"
@@ -20,6 +21,7 @@ type ClientJobs struct {
 	Period       int      `json:"period" valid:"Required;Min(10)"` // Second
 	Type         int      `json:"type" valid:"Required"`
 	ReservedTime int      `json:"reservedtime" valid:"Required"` // Second
+	Rpo          int      `orm:"default(0)" json:"rpo"`          // Second, 0 for Period*sla::rpofactor
 	Host         []*Hosts `orm:"rel(m2m);on_delete(set_null)" json:"hosts"`
 	Paths        []*Paths `orm:"rel(m2m);on_delete(set_null)" json:"paths"`
 }
@@ -32,6 +34,16 @@ func init() {
 	}
 }
 
+// RpoTarget is how old the latest backup of a path may be
+// before it is missed.
+func (a *ClientJobs) RpoTarget() time.Duration {
+	if a.Rpo > 0 {
+		return time.Duration(a.Rpo) * time.Second
+	}
+	factor := beego.AppConfig.DefaultInt("sla::rpofactor", 2)
+	return time.Duration(a.Period*factor) * time.Second
+}
+
 func AddClientJob(a *ClientJobs) (string, error) {
 	beego.Debug("[M] Got data:", a)
 	o := orm.NewOrm()
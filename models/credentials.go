@@ -0,0 +1,179 @@
+package models
+
+import (
+	"fmt"
+	"moduleab_server/common"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"github.com/pborman/uuid"
+)
+
+// 存储账号凭据，Secret加密保存，从不输出；写入时用NewSecret（JSON中为secret）
+type Credentials struct {
+	Id        string `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	Name      string `orm:"size(32);unique;index" json:"name" valid:"Required"`
+	Desc      string `orm:"size(128);null" json:"description"`
+	AccessKey string `orm:"size(128)" json:"access_key" valid:"Required"`
+	Secret    string `orm:"size(512)" json:"-"`
+	NewSecret string `orm:"-" json:"secret,omitempty"`
+	RoleArn   string `orm:"size(256);null" json:"role_arn"` // 签发STS临时凭据时扮演的角色
+	Oss       []*Oss `orm:"reverse(many)" json:"oss"`
+	Oas       []*Oas `orm:"reverse(many)" json:"oas"`
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(Credentials))
+	} else {
+		orm.RegisterModel(new(Credentials))
+	}
+}
+
+// GetSecret returns the decrypted secret.
+func (a *Credentials) GetSecret() (string, error) {
+	return common.DecryptSecret(a.Secret)
+}
+
+func AddCredential(a *Credentials) (string, error) {
+	beego.Debug("[M] Got data:", a.Name)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	a.Id = uuid.New()
+	beego.Debug("[M] Got new id:", a.Id)
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return "", fmt.Errorf("Bad info: %s", errS)
+	}
+	if a.NewSecret == "" {
+		o.Rollback()
+		return "", fmt.Errorf("Bad info: Secret:Can not be empty")
+	}
+	a.Secret, err = common.EncryptSecret(a.NewSecret)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	_, err = o.Insert(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	beego.Debug("[M] Credential saved")
+	o.Commit()
+	return a.Id, nil
+}
+
+func DeleteCredential(a *Credentials) error {
+	beego.Debug("[M] Got data:", a.Name)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return fmt.Errorf("Bad info: %s", errS)
+	}
+	_, err = o.Delete(a)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	o.Commit()
+	return nil
+}
+
+// UpdateCredential keeps the old secret if a.NewSecret is empty.
+func UpdateCredential(a *Credentials) error {
+	beego.Debug("[M] Got data:", a.Name)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return fmt.Errorf("Bad info: %s", errS)
+	}
+	cols := []string{"Name", "Desc", "AccessKey", "RoleArn"}
+	if a.NewSecret != "" {
+		a.Secret, err = common.EncryptSecret(a.NewSecret)
+		if err != nil {
+			o.Rollback()
+			return err
+		}
+		cols = append(cols, "Secret")
+	}
+	_, err = o.Update(a, cols...)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	o.Commit()
+	return nil
+}
+
+// If get all, just use &Credentials{}
+func GetCredentials(cond *Credentials, limit, index int) ([]*Credentials, error) {
+	r := make([]*Credentials, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("credentials")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Name != "" {
+		q = q.Filter("name", cond.Name)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.All(&r)
+
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range r {
+		o.LoadRelated(v, "Oss", common.RelDepth)
+		o.LoadRelated(v, "Oas", common.RelDepth)
+	}
+	return r, nil
+}
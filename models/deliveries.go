@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+// Statuses of deliveries, of notifications and of webhooks.
+const (
+	deliveryPending = "pending"
+	deliverySent    = "sent"
+	deliveryFailed  = "failed"
+)
+
+// deliveryLease is how long others leave a delivery alone while it is
+// being sent.
+const deliveryLease = time.Minute
+
+// delivery is a row of a delivery table, which is sent by whichever
+// server claims it first and retried with NotificationBackoff.
+type delivery interface {
+	// attempt is id of the delivery and how many attempts it has had.
+	attempt() (string, int)
+	send() error
+	// done keeps result of an attempt, err is nil if it is sent.
+	done(now time.Time, err error, maxAttempts int)
+}
+
+// attemptDelivery claims d in table for one more attempt, sends it and
+// saves the result. It tells if d is sent, false if others claimed it.
+func attemptDelivery(o orm.Ormer, table string, d delivery, now time.Time, maxAttempts int) bool {
+	id, attempts := d.attempt()
+	claimed, err := o.QueryTable(table).
+		Filter("id", id).
+		Filter("status", deliveryPending).
+		Filter("attempts", attempts).
+		Update(orm.Params{"attempts": attempts + 1, "next_time": now.Add(deliveryLease)})
+	if err != nil || claimed != 1 {
+		return false
+	}
+	err = d.send()
+	d.done(now, err, maxAttempts)
+	_, uerr := o.Update(d, "Status", "LastError", "NextTime", "SentTime")
+	if uerr != nil {
+		beego.Warn("[M] Got error on updating delivery:", id, uerr)
+	}
+	return err == nil
+}
+
+// retryDelivery is the error kept of attempts failed with err, and when
+// to try again, zero when there is no attempt left.
+func retryDelivery(now time.Time, err error, attempts, maxAttempts int) (string, time.Time) {
+	lastError := err.Error()
+	if len(lastError) > 512 {
+		lastError = lastError[:512]
+	}
+	if attempts >= maxAttempts {
+		return lastError, time.Time{}
+	}
+	return lastError, now.Add(NotificationBackoff(attempts))
+}
+
+// purgeDeliveries deletes sent and failed deliveries of table created
+// before.
+func purgeDeliveries(table string, before time.Time) (int64, error) {
+	return orm.NewOrm().QueryTable(table).
+		Filter("status__in", deliverySent, deliveryFailed).
+		Filter("created_time__lt", before).
+		Delete()
+}
@@ -0,0 +1,265 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/pborman/uuid"
+)
+
+const (
+	DispatchTriggerSchedule = "schedule"
+	DispatchTriggerManual   = "manual"
+)
+
+var (
+	ErrorDispatchBadType = errors.New("Unknown dispatch type")
+	ErrorDispatchNoPath  = errors.New("Path is required")
+	ErrorDispatchNoHook  = errors.New("Hook is required")
+	ErrorDispatchPending = errors.New("Host is pending approval")
+	// Cleanup with reserved_time 0 would delete every backup of path.
+	ErrorDispatchNoReservedTime = errors.New("Reserved time is required, no job of host backs up path")
+)
+
+// 服务端下发给agent的任务，每条对应一个信号。定时下发的Slot为
+// 作业/主机/路径/周期序号，多台服务器同一周期只有一台能下发
+type Dispatches struct {
+	Id             string      `orm:"pk;size(36)" json:"id"`
+	Job            *ClientJobs `orm:"rel(fk);null;on_delete(set_null)" json:"job"`
+	Host           *Hosts      `orm:"rel(fk);on_delete(cascade)" json:"host"`
+	Path           *Paths      `orm:"rel(fk);null;on_delete(set_null)" json:"path"`
+	Record         *Records    `orm:"rel(fk);null;on_delete(set_null)" json:"record"`
+	Type           int         `json:"type"`
+	Trigger        string      `orm:"size(16)" json:"trigger"`
+	Slot           string      `orm:"size(160);unique" json:"-"`
+	SignalId       string      `orm:"size(36);index" json:"signal_id"`
+	SignalStatus   string      `orm:"-" json:"signal_status"`
+	CreatedBy      *Users      `orm:"rel(fk);null;on_delete(set_null)" json:"-"`
+	DispatchedTime time.Time   `orm:"type(datetime);index" json:"dispatched_time"`
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(Dispatches))
+	} else {
+		orm.RegisterModel(new(Dispatches))
+	}
+}
+
+// DispatchSlot is the slot of backup of path on host by job at now,
+// one for every Period seconds.
+func DispatchSlot(job *ClientJobs, host *Hosts, path *Paths, now time.Time) string {
+	return fmt.Sprintf("%s/%s/%s/%d", job.Id, host.Id, path.Id,
+		now.Unix()/int64(job.Period))
+}
+
+// Dispatch saves d and sends signal s to its host. It fails if Slot of d
+// has been taken, e.g. by another server.
+func Dispatch(d *Dispatches, s Signal) error {
+	beego.Debug("[M] Got data:", d.Host.Id, d.Type, d.Trigger)
+	if d.Host.IsPending() {
+		return ErrorDispatchPending
+	}
+	d.Id = uuid.New()
+	if d.Slot == "" {
+		d.Slot = d.Id
+	}
+	if d.DispatchedTime.IsZero() {
+		d.DispatchedTime = time.Now()
+	}
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = o.Insert(d)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	d.SignalId, err = insertSignal(o, d.Host.Id, s)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	_, err = o.Update(d, "SignalId")
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	err = o.Commit()
+	if err != nil {
+		return err
+	}
+	d.SignalStatus = SignalStatusPending
+	err = NotifySignal(d.Host.Id, d.SignalId)
+	if err != nil {
+		// Delivered at the next ping anyway.
+		beego.Warn("[M] Got error on notifying signal:", err)
+	}
+	beego.Info("[M] Dispatched", d.Id, "to host:", d.Host.Name)
+	return nil
+}
+
+// DefaultReservedTime is ReservedTimeOf all jobs, for cleanups of path on
+// host without their own reserved time.
+func DefaultReservedTime(host *Hosts, path *Paths) (int, error) {
+	jobs, err := GetClientJobs(&ClientJobs{}, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	reserved := ReservedTimeOf(jobs, host, path)
+	if reserved <= 0 {
+		return 0, ErrorDispatchNoReservedTime
+	}
+	return reserved, nil
+}
+
+// ReservedTimeOf is the longest ReservedTime of jobs backing up path on
+// host, 0 if there is none.
+func ReservedTimeOf(jobs []*ClientJobs, host *Hosts, path *Paths) int {
+	reserved := 0
+	for _, job := range jobs {
+		if job.ReservedTime > reserved && job.hasHostPath(host, path) {
+			reserved = job.ReservedTime
+		}
+	}
+	return reserved
+}
+
+// hasHostPath tells if job backs up path on host.
+func (job *ClientJobs) hasHostPath(host *Hosts, path *Paths) bool {
+	hasHost, hasPath := false, false
+	for _, v := range job.Host {
+		hasHost = hasHost || v.Id == host.Id
+	}
+	for _, v := range job.Paths {
+		hasPath = hasPath || v.Id == path.Id
+	}
+	return hasHost && hasPath
+}
+
+// dispatchBusy tells if the last dispatch of job for path on host has
+// not been acked or dead yet, then a new one would only pile up.
+func dispatchBusy(job *ClientJobs, host *Hosts, path *Paths) (bool, error) {
+	r := make([]*Dispatches, 0)
+	_, err := orm.NewOrm().QueryTable("dispatches").
+		Filter("job_id", job.Id).
+		Filter("host_id", host.Id).
+		Filter("path_id", path.Id).
+		OrderBy("-dispatched_time").
+		Limit(1).All(&r)
+	if err != nil || len(r) == 0 || r[0].SignalId == "" {
+		return false, err
+	}
+	a, err := getHostSignal(host.Id, r[0].SignalId)
+	if err == ErrorSignalNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return a.Status == SignalStatusPending || a.Status == SignalStatusDelivered, nil
+}
+
+// ScheduleClientJobs dispatches backups of every path of every job to
+// every host of it, once every Period seconds, and returns how many.
+func ScheduleClientJobs(now time.Time) (int, error) {
+	jobs, err := GetClientJobs(&ClientJobs{}, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	o := orm.NewOrm()
+	n := 0
+	for _, job := range jobs {
+		if job.Period <= 0 {
+			continue
+		}
+		for _, host := range job.Host {
+			if host.IsPending() {
+				continue
+			}
+			for _, path := range job.Paths {
+				slot := DispatchSlot(job, host, path, now)
+				if o.QueryTable("dispatches").Filter("slot", slot).Exist() {
+					continue
+				}
+				busy, err := dispatchBusy(job, host, path)
+				if err != nil {
+					beego.Warn("[M] Got error on checking dispatch:", err)
+					continue
+				}
+				if busy {
+					beego.Debug("[M] Last backup not done yet:", host.Name, path.Path)
+					continue
+				}
+				err = Dispatch(&Dispatches{
+					Job:            job,
+					Host:           host,
+					Path:           path,
+					Type:           SignalTypeBackup,
+					Trigger:        DispatchTriggerSchedule,
+					Slot:           slot,
+					DispatchedTime: now,
+				}, MakeBackupSignal(path, job))
+				if err != nil {
+					// Most likely taken by another server.
+					beego.Debug("[M] Not dispatched:", slot, err)
+					continue
+				}
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+// If get all, just use &Dispatches{}, newest first.
+func GetDispatches(cond *Dispatches, limit, index int) ([]*Dispatches, error) {
+	return GetDispatchesInScope(nil, cond, limit, index)
+}
+
+// GetDispatchesInScope is GetDispatches which only gets those of hosts
+// in scope, with SignalStatus of their signals.
+func GetDispatchesInScope(scope *Scope, cond *Dispatches, limit, index int) ([]*Dispatches, error) {
+	r := make([]*Dispatches, 0)
+	o := orm.NewOrm()
+	q := scope.filter(o.QueryTable("dispatches"), "host__app_set__id", "")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Host != nil {
+		q = q.Filter("host_id", cond.Host.Id)
+	}
+	if cond.Job != nil {
+		q = q.Filter("job_id", cond.Job.Id)
+	}
+	if cond.Trigger != "" {
+		q = q.Filter("trigger", cond.Trigger)
+	}
+	if cond.SignalId != "" {
+		q = q.Filter("signal_id", cond.SignalId)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.RelatedSel("Host", "Path").OrderBy("-dispatched_time").All(&r)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range r {
+		if v.SignalId == "" {
+			continue
+		}
+		a, err := getHostSignal(v.Host.Id, v.SignalId)
+		if err == nil {
+			v.SignalStatus = a.Status
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,213 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"github.com/pborman/uuid"
+)
+
+// Enrollment tokens look like "mae_<base64>".
+const EnrollmentTokenPrefix = "mae_"
+
+var (
+	ErrorEnrollmentTokenInvalid = errors.New("Invalid enrollment token")
+	ErrorEnrollmentTokenUsed    = errors.New("Enrollment token has been used")
+	ErrorEnrollmentTokenExpired = errors.New("Enrollment token has expired")
+)
+
+// 主机注册令牌，只能使用一次，注册的主机属于AppSet
+// RequireApproval为真时，主机需管理员批准后才能使用其密钥
+type EnrollmentTokens struct {
+	Id              string    `orm:"pk;size(36)" json:"id"`
+	Name            string    `orm:"size(64)" json:"name" valid:"Required"`
+	AppSet          *AppSets  `orm:"rel(fk);on_delete(cascade)" json:"appset"`
+	Hash            string    `orm:"size(64);unique;index" json:"-"`
+	Prefix          string    `orm:"size(16)" json:"prefix"`
+	RequireApproval bool      `orm:"default(0)" json:"require_approval"`
+	CreatedBy       *Users    `orm:"rel(fk);on_delete(set_null);null" json:"-"`
+	Used            bool      `orm:"default(0)" json:"used"`
+	UsedTime        time.Time `orm:"type(datetime);null" json:"used_time"`
+	Host            *Hosts    `orm:"rel(fk);on_delete(set_null);null" json:"host"`
+	CreatedTime     time.Time `orm:"auto_now_add;type(datetime)" json:"created_time"`
+	ExpiresTime     time.Time `orm:"type(datetime)" json:"expires_time"`
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(EnrollmentTokens))
+	} else {
+		orm.RegisterModel(new(EnrollmentTokens))
+	}
+}
+
+// Usable tells why token can not enroll a host at now, nil if it can.
+func (a *EnrollmentTokens) Usable(now time.Time) error {
+	if a.Used {
+		return ErrorEnrollmentTokenUsed
+	}
+	if now.After(a.ExpiresTime) {
+		return ErrorEnrollmentTokenExpired
+	}
+	return nil
+}
+
+// AddEnrollmentToken saves a new token, and returns the token itself,
+// which is only shown this time. ExpiresTime defaults to
+// security::enrollmenttokenexpire hours later.
+func AddEnrollmentToken(a *EnrollmentTokens) (string, error) {
+	beego.Debug("[M] Got data:", a.Name)
+	if a.AppSet == nil || a.AppSet.Id == "" {
+		return "", fmt.Errorf("Bad info: AppSet:Can not be empty")
+	}
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		return "", err
+	}
+	if !valid {
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return "", fmt.Errorf("Bad info: %s", errS)
+	}
+	if a.ExpiresTime.IsZero() {
+		hours := beego.AppConfig.DefaultInt("security::enrollmenttokenexpire", 24)
+		a.ExpiresTime = time.Now().Add(time.Duration(hours) * time.Hour)
+	}
+
+	b := make([]byte, 30)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := EnrollmentTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	a.Id = uuid.New()
+	a.Hash = hashAccessToken(token)
+	a.Prefix = token[:12]
+	a.Used = false
+	a.Host = nil
+	o := orm.NewOrm()
+	_, err = o.Insert(a)
+	if err != nil {
+		return "", err
+	}
+	beego.Debug("[M] Enrollment token saved:", a.Id)
+	return token, nil
+}
+
+func DeleteEnrollmentToken(a *EnrollmentTokens) error {
+	beego.Debug("[M] Got data:", a.Id)
+	o := orm.NewOrm()
+	_, err := o.Delete(a)
+	return err
+}
+
+// If get all, just use &EnrollmentTokens{}
+func GetEnrollmentTokens(cond *EnrollmentTokens, limit, index int) ([]*EnrollmentTokens, error) {
+	r := make([]*EnrollmentTokens, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("enrollment_tokens")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Hash != "" {
+		q = q.Filter("hash", cond.Hash)
+	}
+	if cond.AppSet != nil {
+		q = q.Filter("app_set_id", cond.AppSet.Id)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.RelatedSel("AppSet").OrderBy("-created_time").All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// EnrollHost uses token to add host of the agent to AppSet of the token,
+// and issues its first key, whose secret is only returned this time.
+// Host gets IpAddr and Addresses from addrs, the first is the primary one.
+func EnrollHost(token, name string, addrs []string) (*Hosts, *HostKeys, error) {
+	beego.Debug("[M] Got data:", name, addrs)
+	if !strings.HasPrefix(token, EnrollmentTokenPrefix) {
+		return nil, nil, ErrorEnrollmentTokenInvalid
+	}
+	// Checked before the token is used up.
+	if err := ValidHostName(strings.TrimSpace(name)); err != nil {
+		return nil, nil, err
+	}
+	tokens, err := GetEnrollmentTokens(&EnrollmentTokens{Hash: hashAccessToken(token)}, 1, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, ErrorEnrollmentTokenInvalid
+	}
+	t := tokens[0]
+	now := time.Now()
+	if err = t.Usable(now); err != nil {
+		return nil, nil, err
+	}
+
+	host := &Hosts{
+		Name:      name,
+		AppSet:    t.AppSet,
+		Status:    HostStatusApproved,
+		Addresses: make([]*HostAddresses, 0, len(addrs)),
+	}
+	if t.RequireApproval {
+		host.Status = HostStatusPending
+	}
+	for _, v := range addrs {
+		host.Addresses = append(host.Addresses, &HostAddresses{Addr: v})
+	}
+
+	o := orm.NewOrm()
+	err = o.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = insertHost(o, host)
+	if err != nil {
+		o.Rollback()
+		return nil, nil, err
+	}
+	// Only one agent can win a token used at the same time.
+	n, err := o.QueryTable("enrollment_tokens").
+		Filter("id", t.Id).
+		Filter("used", false).
+		Update(orm.Params{
+			"used":      true,
+			"used_time": now,
+			"host_id":   host.Id,
+		})
+	if err == nil && n != 1 {
+		err = ErrorEnrollmentTokenUsed
+	}
+	if err != nil {
+		o.Rollback()
+		return nil, nil, err
+	}
+	k, err := addHostKey(o, host)
+	if err != nil {
+		o.Rollback()
+		return nil, nil, err
+	}
+	o.Commit()
+	beego.Info("[M] Host enrolled:", host.Name, host.Status)
+	return host, k, nil
+}
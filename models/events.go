@@ -0,0 +1,140 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/pborman/uuid"
+)
+
+// Events published on the bus, webhook subscriptions match them with
+// patterns like "record.*".
+const (
+	EventRecordCreated   = "record.created"
+	EventRecordArchived  = "record.archived"
+	EventRecordRecovered = "record.recovered"
+	EventRecordDeleted   = "record.deleted"
+	EventOasJobCreated   = "oasjob.created"
+	EventOasJobCompleted = "oasjob.completed"
+)
+
+// Event is something happened to Resource, like "records/<id>".
+// Sequence is assigned by database and grows with events published by
+// every server, so that events of a resource can be told in order.
+type Event struct {
+	Id       string      `json:"id"`
+	Type     string      `json:"type"`
+	Resource string      `json:"resource"`
+	Sequence int64       `json:"sequence"`
+	Time     time.Time   `json:"time"`
+	Data     interface{} `json:"data"`
+}
+
+// 事件序号，由数据库自增分配，多台服务器发布的事件按Id排序
+type EventSequences struct {
+	Id          int64     `orm:"pk;auto" json:"sequence"`
+	EventId     string    `orm:"size(36);index" json:"event_id"`
+	Type        string    `orm:"size(64)" json:"type"`
+	Resource    string    `orm:"size(128)" json:"resource"`
+	CreatedTime time.Time `orm:"auto_now_add;type(datetime);index" json:"created_time"`
+}
+
+// EventHandler is called with every event published. Events may come
+// from more than one publisher at a time, Sequence tells their order.
+// It must be quick and must not publish events itself.
+type EventHandler func(e *Event)
+
+var eventBus struct {
+	sync.RWMutex
+	handlers []EventHandler
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(EventSequences))
+	} else {
+		orm.RegisterModel(new(EventSequences))
+	}
+}
+
+// SubscribeEvents adds h to the bus, usually in init.
+func SubscribeEvents(h EventHandler) {
+	eventBus.Lock()
+	defer eventBus.Unlock()
+	eventBus.handlers = append(eventBus.handlers, h)
+}
+
+// PublishEvent numbers event of typ and hands it to every handler.
+// Events which can't be numbered are only logged, as handlers could
+// not tell their order.
+func PublishEvent(typ, resource string, data interface{}) *Event {
+	e := &Event{
+		Id:       uuid.New(),
+		Type:     typ,
+		Resource: resource,
+		Time:     time.Now(),
+		Data:     data,
+	}
+	seq, err := orm.NewOrm().Insert(&EventSequences{
+		EventId:  e.Id,
+		Type:     typ,
+		Resource: resource,
+	})
+	if err != nil {
+		beego.Warn("[M] Got error on numbering event", typ, resource, err)
+		return e
+	}
+	e.Sequence = seq
+	eventBus.RLock()
+	handlers := eventBus.handlers
+	eventBus.RUnlock()
+	for _, h := range handlers {
+		h(e)
+	}
+	return e
+}
+
+// PurgeEventSequences deletes numbers of events published before,
+// the latest is always kept so that numbers never start over.
+func PurgeEventSequences(before time.Time) (int64, error) {
+	o := orm.NewOrm()
+	latest := make([]*EventSequences, 0)
+	_, err := o.QueryTable("event_sequences").OrderBy("-id").Limit(1).All(&latest, "Id")
+	if err != nil || len(latest) == 0 {
+		return 0, err
+	}
+	return o.QueryTable("event_sequences").
+		Filter("id__lt", latest[0].Id).
+		Filter("created_time__lt", before).
+		Delete()
+}
+
+// PublishRecordEvent publishes event of record r, with extra data
+// like the policy which deleted it.
+func PublishRecordEvent(typ string, r *Records, extra map[string]string) *Event {
+	data := map[string]interface{}{
+		"id":          r.Id,
+		"filename":    r.Filename,
+		"type":        r.Type,
+		"archive_id":  r.ArchiveId,
+		"backup_time": r.BackupTime,
+	}
+	if r.Host != nil {
+		data["host"] = r.Host.Id
+		data["host_name"] = r.Host.Name
+	}
+	if r.Path != nil {
+		data["path"] = r.Path.Id
+		data["path_name"] = r.Path.Path
+	}
+	if !r.ArchivedTime.IsZero() {
+		data["archived_time"] = r.ArchivedTime
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	return PublishEvent(typ, fmt.Sprint("records/", r.Id), data)
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"moduleab_server/common"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/pborman/uuid"
+)
+
+// 主机的API密钥，Id即请求头中的KeyId
+type HostKeys struct {
+	Id          string    `orm:"pk;size(36)" json:"key_id"`
+	Host        *Hosts    `orm:"rel(fk)" json:"host"`
+	Secret      string    `orm:"size(512)" json:"secret,omitempty"`
+	Revoked     bool      `orm:"default(0)" json:"revoked"`
+	CreatedTime time.Time `orm:"auto_now_add;type(datetime)" json:"created_time"`
+	RevokedTime time.Time `orm:"type(datetime);null" json:"revoked_time"`
+}
+
+var (
+	ErrorHostKeyNotFound = errors.New("Host key not found")
+	ErrorHostKeyRevoked  = errors.New("Host key has been revoked")
+	ErrorHostPending     = errors.New("Host is waiting for approval")
+)
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(HostKeys))
+	} else {
+		orm.RegisterModel(new(HostKeys))
+	}
+	common.HostKeyResolver = ResolveHostKey
+}
+
+func newHostKeySecret() (string, error) {
+	b := make([]byte, 30)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+func addHostKey(o orm.Ormer, host *Hosts) (*HostKeys, error) {
+	secret, err := newHostKeySecret()
+	if err != nil {
+		return nil, err
+	}
+	k := &HostKeys{
+		Id:   uuid.New(),
+		Host: host,
+	}
+	k.Secret, err = common.EncryptSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	_, err = o.Insert(k)
+	if err != nil {
+		return nil, err
+	}
+	// Plain secret is only shown once.
+	k.Secret = secret
+	return k, nil
+}
+
+// AddHostKey issues a new key of host, old keys are still valid.
+func AddHostKey(host *Hosts) (*HostKeys, error) {
+	beego.Debug("[M] Got data:", host.Name)
+	if host.Id == "" {
+		return nil, fmt.Errorf("Bad info: Host:Can not be empty")
+	}
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return nil, err
+	}
+	k, err := addHostKey(o, host)
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	beego.Debug("[M] Host key saved:", k.Id)
+	o.Commit()
+	return k, nil
+}
+
+// RotateHostKey issues a new key of host and revokes all the old ones.
+func RotateHostKey(host *Hosts) (*HostKeys, error) {
+	beego.Debug("[M] Got data:", host.Name)
+	if host.Id == "" {
+		return nil, fmt.Errorf("Bad info: Host:Can not be empty")
+	}
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return nil, err
+	}
+	_, err = o.QueryTable("host_keys").
+		Filter("host", host.Id).
+		Filter("revoked", false).
+		Update(orm.Params{
+			"revoked":      true,
+			"revoked_time": time.Now(),
+		})
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	k, err := addHostKey(o, host)
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	beego.Debug("[M] Host key rotated:", k.Id)
+	o.Commit()
+	return k, nil
+}
+
+func RevokeHostKey(k *HostKeys) error {
+	beego.Debug("[M] Got data:", k.Id)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	k.Revoked = true
+	k.RevokedTime = time.Now()
+	_, err = o.Update(k, "Revoked", "RevokedTime")
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	o.Commit()
+	return nil
+}
+
+// If get all, just use &HostKeys{}
+func GetHostKeys(cond *HostKeys, limit, index int) ([]*HostKeys, error) {
+	r := make([]*HostKeys, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("host_keys")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Host != nil {
+		q = q.Filter("host", cond.Host.Id)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.RelatedSel("Host").All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ResolveHostKey is common.HostKeyResolver.
+func ResolveHostKey(keyId string) (string, string, string, error) {
+	keys, err := GetHostKeys(&HostKeys{Id: keyId}, 1, 0)
+	if err != nil {
+		return "", "", "", err
+	}
+	if len(keys) == 0 {
+		return "", "", "", ErrorHostKeyNotFound
+	}
+	if keys[0].Revoked {
+		return "", "", "", ErrorHostKeyRevoked
+	}
+	if keys[0].Host.IsPending() {
+		return "", "", "", ErrorHostPending
+	}
+	secret, err := common.DecryptSecret(keys[0].Secret)
+	if err != nil {
+		return "", "", "", err
+	}
+	return keys[0].Host.Id, keys[0].Host.Name, secret, nil
+}
@@ -1,8 +1,11 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"moduleab_server/common"
+	"net"
+	"regexp"
 	"strings"
 
 	"github.com/astaxie/beego"
@@ -11,67 +14,172 @@ import (
 	"github.com/pborman/uuid"
 )
 
-// 当Agent运行时，自动注册相关信息，如有则跳过
+const (
+	HostStatusApproved = "approved"
+	// Enrolled with a token requiring approval, its keys
+	// are refused until an admin approves it.
+	HostStatusPending = "pending"
+)
+
+var ErrorHostBadName = errors.New("Host name must be letters, digits, '.', '_' or '-', up to 64")
+
+// Host names end up in paths of storage and in URLs of client APIs.
+var hostNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// ValidHostName tells if name can be used as name of host.
+func ValidHostName(name string) error {
+	if !hostNamePattern.MatchString(name) {
+		return ErrorHostBadName
+	}
+	return nil
+}
+
+// Agent用注册令牌登记主机，IpAddr为主地址，Addresses为全部地址，支持IPv6
 type Hosts struct {
-	Id         string        `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
-	Name       string        `orm:"index;unique;size(64)" json:"name" valid:"Required"`
-	IpAddr     string        `orm:"index;unique;size(15)" json:"ip" valid:"Required;IP"`
-	AppSet     *AppSets      `orm:"rel(fk);on_delete(set_null);null" json:"appset"`
-	Paths      []*Paths      `orm:"rel(m2m);on_delete(set_null)" json:"path"`
-	ClientJobs []*ClientJobs `orm:"reverse(many);" json:"jobs"`
+	Id         string           `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	Name       string           `orm:"index;unique;size(64)" json:"name" valid:"Required"`
+	IpAddr     string           `orm:"index;unique;size(45)" json:"ip" valid:"Required"`
+	Status     string           `orm:"size(16);default(approved)" json:"status"`
+	AppSet     *AppSets         `orm:"rel(fk);on_delete(set_null);null" json:"appset"`
+	Paths      []*Paths         `orm:"rel(m2m);on_delete(set_null)" json:"path"`
+	ClientJobs []*ClientJobs    `orm:"reverse(many);" json:"jobs"`
+	Keys       []*HostKeys      `orm:"reverse(many)" json:"-"`
+	Addresses  []*HostAddresses `orm:"reverse(many)" json:"addresses"`
+}
+
+// 主机的全部地址
+type HostAddresses struct {
+	Id   string `orm:"pk;size(36)" json:"-"`
+	Host *Hosts `orm:"rel(fk);on_delete(cascade)" json:"-"`
+	Addr string `orm:"size(45);index" json:"addr"`
 }
 
 func init() {
 	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
-		orm.RegisterModelWithPrefix(prefix, new(Hosts))
+		orm.RegisterModelWithPrefix(prefix, new(Hosts), new(HostAddresses))
 	} else {
-		orm.RegisterModel(new(Hosts))
+		orm.RegisterModel(new(Hosts), new(HostAddresses))
 	}
 }
 
+// Valid checks addresses, which may be IPv4 or IPv6.
+func (h *Hosts) Valid(v *validation.Validation) {
+	if h.IpAddr != "" && net.ParseIP(h.IpAddr) == nil {
+		v.SetError("IpAddr", "Must be a valid IP address")
+	}
+	for _, a := range h.Addresses {
+		if net.ParseIP(a.Addr) == nil {
+			v.SetError("Addresses", "Must be valid IP addresses")
+			return
+		}
+	}
+	if h.Status != "" && h.Status != HostStatusApproved && h.Status != HostStatusPending {
+		v.SetError("Status", "Must be approved or pending")
+	}
+}
+
+// IsPending tells if host is waiting for approval.
+func (h *Hosts) IsPending() bool {
+	return h.Status == HostStatusPending
+}
+
+// normalizeAddresses makes IpAddr the first of Addresses,
+// and Addresses have IpAddr and no duplicates.
+func (h *Hosts) normalizeAddresses() {
+	addrs := make([]*HostAddresses, 0, len(h.Addresses)+1)
+	seen := make(map[string]bool)
+	add := func(a string) {
+		if ip := net.ParseIP(strings.TrimSpace(a)); ip != nil {
+			a = ip.String()
+		}
+		if a == "" || seen[a] {
+			return
+		}
+		seen[a] = true
+		addrs = append(addrs, &HostAddresses{Addr: a})
+	}
+	add(h.IpAddr)
+	for _, v := range h.Addresses {
+		add(v.Addr)
+	}
+	h.Addresses = addrs
+	if len(addrs) != 0 {
+		h.IpAddr = addrs[0].Addr
+	}
+}
+
+func saveHostAddresses(o orm.Ormer, h *Hosts) error {
+	_, err := o.QueryTable("host_addresses").Filter("host", h.Id).Delete()
+	if err != nil {
+		return err
+	}
+	for _, v := range h.Addresses {
+		v.Id = uuid.New()
+		v.Host = &Hosts{Id: h.Id}
+		_, err = o.Insert(v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func AddHost(host *Hosts) (string, error) {
 	beego.Debug("[M] Got data:", host)
+	host.Name = strings.TrimSpace(host.Name)
+	if err := ValidHostName(host.Name); err != nil {
+		return "", err
+	}
 	o := orm.NewOrm()
 	err := o.Begin()
 	if err != nil {
 		return "", err
 	}
+	err = insertHost(o, host)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	beego.Debug("[M] Host data saved")
+	o.Commit()
+	return host.Id, nil
+
+}
 
+// insertHost adds host within transaction of o.
+func insertHost(o orm.Ormer, host *Hosts) error {
 	host.Id = uuid.New()
 	host.Name = strings.TrimSpace(host.Name)
+	host.normalizeAddresses()
+	if host.Status == "" {
+		host.Status = HostStatusApproved
+	}
 	beego.Debug("[M] Got id:", host.Id)
 	validator := new(validation.Validation)
 	valid, err := validator.Valid(host)
 	if err != nil {
-		o.Rollback()
-		return "", err
+		return err
 	}
 	if !valid {
-		o.Rollback()
 		var errS string
 		for _, err := range validator.Errors {
 			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
 		}
-		return "", fmt.Errorf("Bad info: %s", errS)
+		return fmt.Errorf("Bad info: %s", errS)
 	}
 
 	beego.Debug("[M] Got new data:", host)
 	_, err = o.Insert(host)
 	if err != nil {
-		o.Rollback()
-		return "", err
+		return err
 	}
 	if host.Paths != nil && len(host.Paths) != 0 {
 		_, err = o.QueryM2M(host, "Paths").Add(host.Paths)
 		if err != nil {
-			o.Rollback()
-			return "", err
+			return err
 		}
 	}
-	beego.Debug("[M] Host data saved")
-	o.Commit()
-	return host.Id, nil
-
+	return saveHostAddresses(o, host)
 }
 
 func DeleteHost(h *Hosts) error {
@@ -126,6 +234,14 @@ func UpdateHost(h *Hosts) error {
 	if err != nil {
 		return err
 	}
+	// Addresses are only changed if given.
+	updateAddresses := h.Addresses != nil
+	if updateAddresses {
+		h.normalizeAddresses()
+	}
+	if h.Status == "" {
+		h.Status = HostStatusApproved
+	}
 	validator := new(validation.Validation)
 	valid, err := validator.Valid(h)
 	if err != nil {
@@ -157,16 +273,36 @@ func UpdateHost(h *Hosts) error {
 			return err
 		}
 	}
+	if updateAddresses {
+		err = saveHostAddresses(o, h)
+		if err != nil {
+			o.Rollback()
+			return err
+		}
+	}
 
 	o.Commit()
 	return nil
 }
 
+// ApproveHost lets a pending host use its keys.
+func ApproveHost(h *Hosts) error {
+	beego.Debug("[M] Got data:", h.Name)
+	h.Status = HostStatusApproved
+	_, err := orm.NewOrm().Update(h, "Status")
+	return err
+}
+
 // If get all, just use &Host{}
 func GetHosts(cond *Hosts, limit, index int) ([]*Hosts, error) {
+	return GetHostsInScope(nil, cond, limit, index)
+}
+
+// GetHostsInScope is GetHosts which only gets hosts in scope.
+func GetHostsInScope(scope *Scope, cond *Hosts, limit, index int) ([]*Hosts, error) {
 	r := make([]*Hosts, 0)
 	o := orm.NewOrm()
-	q := o.QueryTable("hosts")
+	q := scope.filter(o.QueryTable("hosts"), "app_set_id", "")
 	if cond.Id != "" {
 		q = q.Filter("id", cond.Id)
 	}
@@ -176,6 +312,9 @@ func GetHosts(cond *Hosts, limit, index int) ([]*Hosts, error) {
 	if cond.IpAddr != "" {
 		q = q.Filter("ip_addr", cond.IpAddr)
 	}
+	if cond.Status != "" {
+		q = q.Filter("status", cond.Status)
+	}
 	if limit > 0 {
 		q = q.Limit(limit)
 	}
@@ -190,6 +329,7 @@ func GetHosts(cond *Hosts, limit, index int) ([]*Hosts, error) {
 	for _, v := range r {
 		o.LoadRelated(v, "Paths", common.RelDepth+5)
 		o.LoadRelated(v, "ClientJobs", common.RelDepth)
+		o.LoadRelated(v, "Addresses")
 	}
 	return r, nil
 }
@@ -0,0 +1,397 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"moduleab_server/notify"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/pborman/uuid"
+)
+
+const (
+	IncidentStatusOpen     = "open"
+	IncidentStatusResolved = "resolved"
+)
+
+// Types of incidents.
+const IncidentTypeMissedBackup = "missed_backup"
+
+var (
+	ErrorIncidentNotFound = errors.New("Incident not found")
+	ErrorIncidentResolved = errors.New("Incident has been resolved")
+)
+
+// 告警事件，如主机路径的备份超过RPO未完成。同一主机路径同时只有一个
+// 未解决的事件：OpenKey在打开时为 类型/主机/路径，解决后改为Id
+type Incidents struct {
+	Id             string    `orm:"pk;size(36)" json:"id"`
+	Type           string    `orm:"size(32)" json:"type"`
+	Status         string    `orm:"size(16);index" json:"status"`
+	OpenKey        string    `orm:"size(128);unique" json:"-"`
+	Host           *Hosts    `orm:"rel(fk);on_delete(cascade)" json:"host"`
+	Path           *Paths    `orm:"rel(fk);on_delete(cascade)" json:"path"`
+	Rpo            int64     `json:"rpo"`       // Second
+	Staleness      int64     `json:"staleness"` // Second, when last checked
+	LastBackupTime time.Time `orm:"type(datetime);null" json:"last_backup_time"`
+	Message        string    `orm:"size(1024);null" json:"message"`
+	Acked          bool      `orm:"default(0)" json:"acked"`
+	AckedBy        *Users    `orm:"rel(fk);null;on_delete(set_null)" json:"-"`
+	OpenedTime     time.Time `orm:"type(datetime);index" json:"opened_time"`
+	UpdatedTime    time.Time `orm:"type(datetime)" json:"updated_time"`
+	ResolvedTime   time.Time `orm:"type(datetime);null" json:"resolved_time"`
+}
+
+// BackupTarget is a path of a host some job backs up, and the
+// strictest RPO of those jobs.
+type BackupTarget struct {
+	Host *Hosts
+	Path *Paths
+	Rpo  time.Duration
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(Incidents))
+	} else {
+		orm.RegisterModel(new(Incidents))
+	}
+}
+
+func (a *Incidents) fields() map[string]string {
+	r := map[string]string{"incident": a.Id, "type": a.Type}
+	if a.Host != nil {
+		r["host"] = a.Host.Name
+	}
+	if a.Path != nil {
+		r["path"] = a.Path.Path
+	}
+	return r
+}
+
+func incidentKey(typ string, host *Hosts, path *Paths) string {
+	return fmt.Sprintf("%s/%s/%s", typ, host.Id, path.Id)
+}
+
+// Staleness is how old the latest backup is at now. A path never backed
+// up is as stale as its first dispatch, and not stale before that.
+func Staleness(last, firstDispatch, now time.Time) time.Duration {
+	if last.IsZero() {
+		last = firstDispatch
+	}
+	if last.IsZero() || last.After(now) {
+		return 0
+	}
+	return now.Sub(last)
+}
+
+// BackupTargets gets what jobs back up, a path of a host in more than
+// one job is there once with the smallest RPO. Pending hosts are left out.
+func BackupTargets(jobs []*ClientJobs) []*BackupTarget {
+	r := make([]*BackupTarget, 0)
+	seen := make(map[string]*BackupTarget)
+	for _, job := range jobs {
+		if job.Period <= 0 {
+			continue
+		}
+		rpo := job.RpoTarget()
+		for _, host := range job.Host {
+			if host.IsPending() {
+				continue
+			}
+			for _, path := range job.Paths {
+				key := host.Id + "/" + path.Id
+				if t, ok := seen[key]; ok {
+					if rpo < t.Rpo {
+						t.Rpo = rpo
+					}
+					continue
+				}
+				t := &BackupTarget{Host: host, Path: path, Rpo: rpo}
+				seen[key] = t
+				r = append(r, t)
+			}
+		}
+	}
+	return r
+}
+
+// lastBackupTime is BackupTime of the latest record of path on host.
+func lastBackupTime(t *BackupTarget) (time.Time, error) {
+	r := make([]*Records, 0)
+	_, err := orm.NewOrm().QueryTable("records").
+		Filter("host_id", t.Host.Id).
+		Filter("path_id", t.Path.Id).
+		OrderBy("-backup_time").
+		Limit(1).All(&r, "BackupTime")
+	if err != nil || len(r) == 0 {
+		return time.Time{}, err
+	}
+	return r[0].BackupTime, nil
+}
+
+// firstDispatchTime is when path on host was dispatched the first time,
+// zero if never.
+func firstDispatchTime(t *BackupTarget) (time.Time, error) {
+	r := make([]*Dispatches, 0)
+	_, err := orm.NewOrm().QueryTable("dispatches").
+		Filter("host_id", t.Host.Id).
+		Filter("path_id", t.Path.Id).
+		OrderBy("dispatched_time").
+		Limit(1).All(&r, "DispatchedTime")
+	if err != nil || len(r) == 0 {
+		return time.Time{}, err
+	}
+	return r[0].DispatchedTime, nil
+}
+
+// missedBackupMessage tells why backup of t is missed, with the error
+// of the last failed run if there is one.
+func missedBackupMessage(t *BackupTarget, last time.Time) string {
+	msg := fmt.Sprintf("%s:%s never backed up", t.Host.Name, t.Path.Path)
+	if !last.IsZero() {
+		msg = fmt.Sprintf("%s:%s last backed up at %s, RPO is %s",
+			t.Host.Name, t.Path.Path, last.Format(time.RFC3339), t.Rpo)
+	}
+	runs, err := GetBackupRuns(&BackupRuns{
+		Host:   t.Host,
+		Path:   t.Path,
+		Status: BackupRunStatusFailed,
+	}, 1, 0, last)
+	if err == nil && len(runs) != 0 && runs[0].Message != "" {
+		msg = fmt.Sprintf("%s, last failed: %s", msg, runs[0].Message)
+	}
+	if len(msg) > 1024 {
+		msg = msg[:1024]
+	}
+	return msg
+}
+
+func getOpenIncident(key string) (*Incidents, error) {
+	r := make([]*Incidents, 0)
+	_, err := orm.NewOrm().QueryTable("incidents").
+		Filter("open_key", key).
+		Limit(1).All(&r)
+	if err != nil || len(r) == 0 {
+		return nil, err
+	}
+	return r[0], nil
+}
+
+// resolveIncident resolves a if it is still open, it fails with
+// ErrorIncidentResolved if another server has done it meanwhile.
+func resolveIncident(a *Incidents, now time.Time, message string) error {
+	if message == "" {
+		message = a.Message
+	}
+	n, err := orm.NewOrm().QueryTable("incidents").
+		Filter("id", a.Id).
+		Filter("status", IncidentStatusOpen).
+		Update(orm.Params{
+			"status":        IncidentStatusResolved,
+			"open_key":      a.Id,
+			"resolved_time": now,
+			"updated_time":  now,
+			"message":       message,
+		})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrorIncidentResolved
+	}
+	a.Status = IncidentStatusResolved
+	a.OpenKey = a.Id
+	a.ResolvedTime = now
+	a.UpdatedTime = now
+	a.Message = message
+	beego.Info("[M] Incident resolved:", a.Id, a.Message)
+	Notify(EventIncidentResolved, notify.SeverityInfo,
+		"Incident resolved", a.Message, a.fields())
+	return nil
+}
+
+// CheckMissedBackups opens an incident for every path of jobs whose
+// latest backup is older than its RPO, and resolves those backed up
+// since, or no longer in any job. It returns how many are opened and
+// resolved.
+func CheckMissedBackups(now time.Time) (int, int, error) {
+	jobs, err := GetClientJobs(&ClientJobs{}, 0, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	o := orm.NewOrm()
+	opened, resolved := 0, 0
+	seen := make(map[string]bool)
+	for _, t := range BackupTargets(jobs) {
+		key := incidentKey(IncidentTypeMissedBackup, t.Host, t.Path)
+		seen[key] = true
+		last, err := lastBackupTime(t)
+		if err != nil {
+			beego.Warn("[M] Got error on getting last backup:", err)
+			continue
+		}
+		var first time.Time
+		if last.IsZero() {
+			first, err = firstDispatchTime(t)
+			if err != nil {
+				beego.Warn("[M] Got error on getting first dispatch:", err)
+				continue
+			}
+		}
+		incident, err := getOpenIncident(key)
+		if err != nil {
+			beego.Warn("[M] Got error on getting incident:", err)
+			continue
+		}
+		staleness := Staleness(last, first, now)
+		if staleness <= t.Rpo {
+			if incident != nil {
+				msg := fmt.Sprintf("%s:%s not due yet", t.Host.Name, t.Path.Path)
+				if !last.IsZero() {
+					msg = fmt.Sprintf("%s:%s backed up at %s", t.Host.Name,
+						t.Path.Path, last.Format(time.RFC3339))
+				}
+				err = resolveIncident(incident, now, msg)
+				if err == ErrorIncidentResolved {
+					beego.Debug("[M] Incident resolved by others:", incident.Id)
+					continue
+				} else if err != nil {
+					beego.Warn("[M] Got error on resolving incident:", err)
+					continue
+				}
+				resolved++
+			}
+			continue
+		}
+
+		isNew := incident == nil
+		if isNew {
+			incident = &Incidents{
+				Id:         uuid.New(),
+				Type:       IncidentTypeMissedBackup,
+				Status:     IncidentStatusOpen,
+				OpenKey:    key,
+				Host:       t.Host,
+				Path:       t.Path,
+				OpenedTime: now,
+			}
+		}
+		incident.Rpo = int64(t.Rpo / time.Second)
+		incident.Staleness = int64(staleness / time.Second)
+		incident.LastBackupTime = last
+		incident.Message = missedBackupMessage(t, last)
+		incident.UpdatedTime = now
+		if isNew {
+			_, err = o.Insert(incident)
+			if err != nil {
+				// Opened by another server meanwhile.
+				beego.Debug("[M] Incident not opened:", key, err)
+				continue
+			}
+			beego.Warn("[M] Incident opened:", incident.Id, incident.Message)
+			Notify(EventIncidentOpened, notify.SeverityCritical,
+				"Backup missed", incident.Message, incident.fields())
+			opened++
+		} else {
+			// Only what is checked here, others may have acked or
+			// resolved it meanwhile.
+			n, err := o.QueryTable("incidents").
+				Filter("id", incident.Id).
+				Filter("status", IncidentStatusOpen).
+				Update(orm.Params{
+					"rpo":              incident.Rpo,
+					"staleness":        incident.Staleness,
+					"last_backup_time": incident.LastBackupTime,
+					"message":          incident.Message,
+					"updated_time":     incident.UpdatedTime,
+				})
+			if err != nil {
+				beego.Warn("[M] Got error on updating incident:", err)
+			} else if n == 0 {
+				beego.Debug("[M] Incident resolved by others:", incident.Id)
+			}
+		}
+	}
+
+	incidents, err := GetIncidents(&Incidents{
+		Type:   IncidentTypeMissedBackup,
+		Status: IncidentStatusOpen,
+	}, 0, 0)
+	if err != nil {
+		return opened, resolved, err
+	}
+	for _, v := range incidents {
+		if seen[v.OpenKey] {
+			continue
+		}
+		err = resolveIncident(v, now, "No longer backed up by any job")
+		if err == ErrorIncidentResolved {
+			beego.Debug("[M] Incident resolved by others:", v.Id)
+			continue
+		} else if err != nil {
+			beego.Warn("[M] Got error on resolving incident:", err)
+			continue
+		}
+		resolved++
+	}
+	return opened, resolved, nil
+}
+
+// AckIncident tells others someone is on it, it is still open
+// until backed up.
+func AckIncident(id, userId string) error {
+	incidents, err := GetIncidents(&Incidents{Id: id}, 1, 0)
+	if err != nil {
+		return err
+	}
+	if len(incidents) == 0 {
+		return ErrorIncidentNotFound
+	}
+	a := incidents[0]
+	if a.Status == IncidentStatusResolved {
+		return ErrorIncidentResolved
+	}
+	a.Acked = true
+	a.AckedBy = &Users{Id: userId}
+	a.UpdatedTime = time.Now()
+	_, err = orm.NewOrm().Update(a, "Acked", "AckedBy", "UpdatedTime")
+	return err
+}
+
+// If get all, just use &Incidents{}, newest first.
+func GetIncidents(cond *Incidents, limit, index int) ([]*Incidents, error) {
+	return GetIncidentsInScope(nil, cond, limit, index)
+}
+
+// GetIncidentsInScope is GetIncidents which only gets those of hosts in scope.
+func GetIncidentsInScope(scope *Scope, cond *Incidents, limit, index int) ([]*Incidents, error) {
+	r := make([]*Incidents, 0)
+	o := orm.NewOrm()
+	q := scope.filter(o.QueryTable("incidents"), "host__app_set__id", "")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Type != "" {
+		q = q.Filter("type", cond.Type)
+	}
+	if cond.Status != "" {
+		q = q.Filter("status", cond.Status)
+	}
+	if cond.Host != nil && cond.Host.Id != "" {
+		q = q.Filter("host_id", cond.Host.Id)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.RelatedSel("Host", "Path").OrderBy("-opened_time").All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,448 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"moduleab_server/common"
+	"moduleab_server/notify"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"github.com/pborman/uuid"
+)
+
+// Events notified, rules match them with patterns like "incident.*".
+const (
+	EventPolicyFailed       = "policy.failed"
+	EventOasJobFailed       = "oasjob.failed"
+	EventAgentDisconnected  = "agent.disconnected"
+	EventSignalDead         = "signal.dead"
+	EventIncidentOpened     = "incident.opened"
+	EventIncidentResolved   = "incident.resolved"
+	EventNotificationTested = "notification.test"
+)
+
+const (
+	NotificationStatusPending = deliveryPending
+	NotificationStatusSent    = deliverySent
+	NotificationStatusFailed  = deliveryFailed
+)
+
+var (
+	ErrorNotificationChannelType = errors.New("Unknown notification channel type")
+	ErrorNotificationNotFound    = errors.New("Notification channel not found")
+	ErrorNotificationNoSecret    = errors.New("Secret is required to sign webhooks")
+)
+
+var severities = map[string]int{
+	notify.SeverityInfo:     0,
+	notify.SeverityWarning:  1,
+	notify.SeverityCritical: 2,
+}
+
+// 通知渠道：smtp、webhook、slack或dingtalk。Target为webhook地址或smtp的
+// host:port，Secret为smtp密码或webhook签名密钥，加密保存
+type NotificationChannels struct {
+	Id          string               `orm:"pk;size(36)" json:"id"`
+	Name        string               `orm:"size(64);unique" json:"name" valid:"Required"`
+	Type        string               `orm:"size(16)" json:"type" valid:"Required"`
+	Target      string               `orm:"size(512)" json:"target" valid:"Required"`
+	From        string               `orm:"size(128);null" json:"from"`
+	Recipients  string               `orm:"size(1024);null" json:"recipients"` // 逗号分隔
+	Username    string               `orm:"size(128);null" json:"username"`
+	Secret      string               `orm:"size(512);null" json:"secret,omitempty"`
+	Enabled     bool                 `orm:"default(1)" json:"enabled"`
+	Rules       []*NotificationRules `orm:"reverse(many)" json:"rules"`
+	CreatedTime time.Time            `orm:"auto_now_add;type(datetime)" json:"created_time"`
+}
+
+// 通知订阅规则，Event为事件名模式，如 incident.* 或 *，
+// 严重程度不低于Severity的事件才发送
+type NotificationRules struct {
+	Id       string                `orm:"pk;size(36)" json:"id"`
+	Channel  *NotificationChannels `orm:"rel(fk);on_delete(cascade)" json:"channel"`
+	Event    string                `orm:"size(64)" json:"event" valid:"Required"`
+	Severity string                `orm:"size(16)" json:"severity"`
+}
+
+// 通知发送记录，失败后按指数退避重试，超过notify::maxattempts次为failed
+type NotificationDeliveries struct {
+	Id          string                `orm:"pk;size(36)" json:"id"`
+	Channel     *NotificationChannels `orm:"rel(fk);on_delete(cascade)" json:"-"`
+	Event       string                `orm:"size(64);index" json:"event"`
+	Payload     string                `orm:"type(text)" json:"payload"`
+	Status      string                `orm:"size(16);index" json:"status"`
+	Attempts    int                   `orm:"default(0)" json:"attempts"`
+	LastError   string                `orm:"size(512);null" json:"last_error"`
+	NextTime    time.Time             `orm:"type(datetime);index" json:"next_time"`
+	CreatedTime time.Time             `orm:"auto_now_add;type(datetime)" json:"created_time"`
+	SentTime    time.Time             `orm:"type(datetime);null" json:"sent_time"`
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix,
+			new(NotificationChannels), new(NotificationRules), new(NotificationDeliveries))
+	} else {
+		orm.RegisterModel(
+			new(NotificationChannels), new(NotificationRules), new(NotificationDeliveries))
+	}
+}
+
+// Matches tells if rule wants event of severity.
+func (a *NotificationRules) Matches(event, severity string) bool {
+	if ok, _ := path.Match(a.Event, event); !ok {
+		return false
+	}
+	return severities[severity] >= severities[a.Severity]
+}
+
+// Sender makes the sender of channel with its secret decrypted.
+func (a *NotificationChannels) Sender() (notify.Sender, error) {
+	secret, err := common.DecryptSecret(a.Secret)
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]string, 0)
+	for _, v := range strings.Split(a.Recipients, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			recipients = append(recipients, v)
+		}
+	}
+	return notify.NewSender(notify.Config{
+		Type:       a.Type,
+		Target:     a.Target,
+		From:       a.From,
+		Recipients: recipients,
+		Username:   a.Username,
+		Secret:     secret,
+	})
+}
+
+// validNotificationChannel checks a, kept is the secret it has saved,
+// webhooks are never sent unsigned.
+func validNotificationChannel(a *NotificationChannels, kept string) error {
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return fmt.Errorf("Bad info: %s", errS)
+	}
+	if !notify.Supports(a.Type) {
+		return ErrorNotificationChannelType
+	}
+	if a.Type == "webhook" && a.Secret == "" && kept == "" {
+		return ErrorNotificationNoSecret
+	}
+	return nil
+}
+
+func AddNotificationChannel(a *NotificationChannels) (string, error) {
+	beego.Debug("[M] Got data:", a.Name, a.Type)
+	a.Id = uuid.New()
+	err := validNotificationChannel(a, "")
+	if err != nil {
+		return "", err
+	}
+	a.Secret, err = common.EncryptSecret(a.Secret)
+	if err != nil {
+		return "", err
+	}
+	_, err = orm.NewOrm().Insert(a)
+	if err != nil {
+		return "", err
+	}
+	return a.Id, nil
+}
+
+func DeleteNotificationChannel(a *NotificationChannels) error {
+	beego.Debug("[M] Got data:", a.Id)
+	_, err := orm.NewOrm().Delete(a)
+	return err
+}
+
+// UpdateNotificationChannel keeps the secret if a has none.
+func UpdateNotificationChannel(a *NotificationChannels) error {
+	beego.Debug("[M] Got data:", a.Id)
+	kept := ""
+	if a.Secret == "" {
+		channels, err := GetNotificationChannels(&NotificationChannels{Id: a.Id}, 1, 0)
+		if err != nil {
+			return err
+		}
+		if len(channels) != 0 {
+			kept = channels[0].Secret
+		}
+	}
+	err := validNotificationChannel(a, kept)
+	if err != nil {
+		return err
+	}
+	fields := []string{"Name", "Type", "Target", "From", "Recipients", "Username", "Enabled"}
+	if a.Secret != "" {
+		a.Secret, err = common.EncryptSecret(a.Secret)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, "Secret")
+	}
+	_, err = orm.NewOrm().Update(a, fields...)
+	return err
+}
+
+// If get all, just use &NotificationChannels{}
+func GetNotificationChannels(cond *NotificationChannels, limit, index int) ([]*NotificationChannels, error) {
+	r := make([]*NotificationChannels, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("notification_channels")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Name != "" {
+		q = q.Filter("name", cond.Name)
+	}
+	if cond.Type != "" {
+		q = q.Filter("type", cond.Type)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.All(&r)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range r {
+		o.LoadRelated(v, "Rules")
+	}
+	return r, nil
+}
+
+func AddNotificationRule(a *NotificationRules) (string, error) {
+	beego.Debug("[M] Got data:", a.Event, a.Severity)
+	if a.Channel == nil || a.Channel.Id == "" {
+		return "", fmt.Errorf("Bad info: Channel:Can not be empty")
+	}
+	if a.Severity == "" {
+		a.Severity = notify.SeverityInfo
+	}
+	if _, ok := severities[a.Severity]; !ok {
+		return "", fmt.Errorf("Bad info: Severity:Unknown %s", a.Severity)
+	}
+	if _, err := path.Match(a.Event, ""); err != nil {
+		return "", fmt.Errorf("Bad info: Event:%s", err)
+	}
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		return "", err
+	}
+	if !valid {
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return "", fmt.Errorf("Bad info: %s", errS)
+	}
+	a.Id = uuid.New()
+	_, err = orm.NewOrm().Insert(a)
+	if err != nil {
+		return "", err
+	}
+	return a.Id, nil
+}
+
+func DeleteNotificationRule(a *NotificationRules) error {
+	beego.Debug("[M] Got data:", a.Id)
+	_, err := orm.NewOrm().Delete(a)
+	return err
+}
+
+// Notify queues message of event to every enabled channel with a
+// rule matching it. It never fails the caller, errors are only logged.
+func Notify(event, severity, title, text string, fields map[string]string) {
+	m := &notify.Message{
+		Id:       uuid.New(),
+		Event:    event,
+		Severity: severity,
+		Title:    title,
+		Text:     text,
+		Fields:   fields,
+		Time:     time.Now(),
+	}
+	err := notifyMessage(m)
+	if err != nil {
+		beego.Warn("[M] Got error on notifying", event, err)
+	}
+}
+
+func notifyMessage(m *notify.Message) error {
+	rules := make([]*NotificationRules, 0)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("notification_rules").
+		Filter("channel__enabled", true).
+		RelatedSel("Channel").All(&rules)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	queued := make(map[string]bool)
+	for _, v := range rules {
+		if queued[v.Channel.Id] || !v.Matches(m.Event, m.Severity) {
+			continue
+		}
+		queued[v.Channel.Id] = true
+		_, err = o.Insert(&NotificationDeliveries{
+			Id:       uuid.New(),
+			Channel:  v.Channel,
+			Event:    m.Event,
+			Payload:  string(b),
+			Status:   NotificationStatusPending,
+			NextTime: m.Time,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	beego.Debug("[M] Notification", m.Event, "queued to channels:", len(queued))
+	return nil
+}
+
+// SendTestNotification sends a message to channel at once, without
+// queueing or retrying, so its config can be checked.
+func SendTestNotification(a *NotificationChannels) error {
+	sender, err := a.Sender()
+	if err != nil {
+		return err
+	}
+	return sender.Send(&notify.Message{
+		Id:       uuid.New(),
+		Event:    EventNotificationTested,
+		Severity: notify.SeverityInfo,
+		Title:    "Test notification",
+		Text:     fmt.Sprintf("Channel %s works.", a.Name),
+		Time:     time.Now(),
+	})
+}
+
+// NotificationBackoff is how long to wait after attempts failed ones,
+// doubled every time from notify::retrybase seconds.
+func NotificationBackoff(attempts int) time.Duration {
+	base := time.Duration(beego.AppConfig.DefaultInt64("notify::retrybase", 30)) * time.Second
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > 16 {
+		attempts = 16
+	}
+	return base << uint(attempts-1)
+}
+
+// Fail counts a failed attempt, the delivery is failed for good
+// after maxAttempts.
+func (a *NotificationDeliveries) Fail(now time.Time, err error, maxAttempts int) {
+	var next time.Time
+	a.LastError, next = retryDelivery(now, err, a.Attempts, maxAttempts)
+	if next.IsZero() {
+		a.Status = NotificationStatusFailed
+		return
+	}
+	a.NextTime = next
+}
+
+func (a *NotificationDeliveries) attempt() (string, int) {
+	return a.Id, a.Attempts
+}
+
+func (a *NotificationDeliveries) done(now time.Time, err error, maxAttempts int) {
+	a.Attempts++
+	if err != nil {
+		beego.Warn("[M] Failed to send notification", a.Id, "to", a.Channel.Name, err)
+		a.Fail(now, err, maxAttempts)
+		return
+	}
+	a.Status = NotificationStatusSent
+	a.SentTime = time.Now()
+}
+
+// DeliverNotifications sends pending deliveries which are due at now,
+// and returns how many are sent.
+func DeliverNotifications(now time.Time) (int, error) {
+	r := make([]*NotificationDeliveries, 0)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("notification_deliveries").
+		Filter("status", NotificationStatusPending).
+		Filter("next_time__lte", now).
+		OrderBy("created_time").
+		Limit(100).
+		RelatedSel("Channel").All(&r)
+	if err != nil {
+		return 0, err
+	}
+	maxAttempts := beego.AppConfig.DefaultInt("notify::maxattempts", 5)
+	n := 0
+	for _, v := range r {
+		if attemptDelivery(o, "notification_deliveries", v, now, maxAttempts) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (a *NotificationDeliveries) send() error {
+	m := new(notify.Message)
+	err := json.Unmarshal([]byte(a.Payload), m)
+	if err != nil {
+		return err
+	}
+	sender, err := a.Channel.Sender()
+	if err != nil {
+		return err
+	}
+	return sender.Send(m)
+}
+
+// If get all, just use &NotificationDeliveries{}, newest first.
+func GetNotificationDeliveries(cond *NotificationDeliveries, limit, index int) ([]*NotificationDeliveries, error) {
+	r := make([]*NotificationDeliveries, 0)
+	q := orm.NewOrm().QueryTable("notification_deliveries")
+	if cond.Channel != nil {
+		q = q.Filter("channel_id", cond.Channel.Id)
+	}
+	if cond.Status != "" {
+		q = q.Filter("status", cond.Status)
+	}
+	if cond.Event != "" {
+		q = q.Filter("event", cond.Event)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.OrderBy("-created_time").All(&r)
+	return r, err
+}
+
+// PurgeNotificationDeliveries deletes sent and failed deliveries
+// created before.
+func PurgeNotificationDeliveries(before time.Time) (int64, error) {
+	return purgeDeliveries("notification_deliveries", before)
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"fmt"
 	"moduleab_server/common"
+	"moduleab_server/storage"
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/orm"
@@ -12,13 +13,40 @@ import (
 
 type Oas struct {
 	Id         string        `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	Type       string        `orm:"size(16);default(aliyun)" json:"type"` // Storage adapter, see package storage
 	Endpoint   string        `json:"endpoint" valid:"Required"`
 	VaultName  string        `orm:"size(32) json:"vaultName" valid:"Required"`
 	VaultId    string        `orm:"size(32) json:"vaultId" valid:"Required"`
 	BackupSets []*BackupSets `orm:"reverse(many)"`
+	Credential *Credentials  `orm:"null;rel(fk);on_delete(set_null)" json:"credential"`
 	Jobs       []*OasJobs    `orm:"reverse(many)"`
 }
 
+func (a *Oas) StorageConfig() (storage.ColdConfig, error) {
+	conf := storage.ColdConfig{
+		Type:      a.Type,
+		Endpoint:  a.Endpoint,
+		VaultName: a.VaultName,
+		VaultId:   a.VaultId,
+	}
+	if a.Credential != nil {
+		var err error
+		// Relation may be loaded with only the id.
+		if a.Credential.AccessKey == "" {
+			err = orm.NewOrm().Read(a.Credential)
+			if err != nil {
+				return conf, err
+			}
+		}
+		conf.AccessKey = a.Credential.AccessKey
+		conf.SecretKey, err = a.Credential.GetSecret()
+		if err != nil {
+			return conf, err
+		}
+	}
+	return conf, nil
+}
+
 func init() {
 	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
 		orm.RegisterModelWithPrefix(prefix, new(Oas))
@@ -143,7 +171,7 @@ func GetOas(cond *Oas, limit, index int) ([]*Oas, error) {
 	if index > 0 {
 		q = q.Offset(index)
 	}
-	_, err := q.All(&r)
+	_, err := q.RelatedSel(common.RelDepth).All(&r)
 
 	if err != nil {
 		return nil, err
@@ -3,6 +3,7 @@ package models
 import (
 	"fmt"
 	"moduleab_server/common"
+	"moduleab_server/storage"
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/orm"
@@ -12,11 +13,42 @@ import (
 
 type Oss struct {
 	Id         string        `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	Type       string        `orm:"size(16);default(aliyun)" json:"type"` // Storage adapter, see package storage
 	Endpoint   string        `json:"endpoint" valid:"Required"`
 	BucketName string        `orm:"size(32);index;unique" json:"bucket" valid:"Required"`
+	Region     string        `orm:"size(32);null" json:"region"`
+	PathStyle  bool          `orm:"default(0)" json:"path_style"`
+	Credential *Credentials  `orm:"null;rel(fk);on_delete(set_null)" json:"credential"`
 	BackupSets []*BackupSets `orm:"reverse(many)"`
 }
 
+func (a *Oss) StorageConfig() (storage.HotConfig, error) {
+	conf := storage.HotConfig{
+		Type:      a.Type,
+		Endpoint:  a.Endpoint,
+		Bucket:    a.BucketName,
+		Region:    a.Region,
+		PathStyle: a.PathStyle,
+	}
+	if a.Credential != nil {
+		var err error
+		// Relation may be loaded with only the id.
+		if a.Credential.AccessKey == "" {
+			err = orm.NewOrm().Read(a.Credential)
+			if err != nil {
+				return conf, err
+			}
+		}
+		conf.AccessKey = a.Credential.AccessKey
+		conf.RoleArn = a.Credential.RoleArn
+		conf.SecretKey, err = a.Credential.GetSecret()
+		if err != nil {
+			return conf, err
+		}
+	}
+	return conf, nil
+}
+
 func init() {
 	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
 		orm.RegisterModelWithPrefix(prefix, new(Oss))
@@ -140,7 +172,7 @@ func GetOss(cond *Oss, limit, index int) ([]*Oss, error) {
 	if index > 0 {
 		q = q.Offset(index)
 	}
-	_, err := q.All(&r)
+	_, err := q.RelatedSel(common.RelDepth).All(&r)
 
 	if err != nil {
 		return nil, err
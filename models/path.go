@@ -153,9 +153,18 @@ func UpdatePath(h *Paths) error {
 
 // If get all, just use &Path{}
 func GetPaths(cond *Paths, limit, index int) ([]*Paths, error) {
+	return GetPathsInScope(nil, cond, limit, index)
+}
+
+// GetPathsInScope is GetPaths which only gets paths in scope.
+func GetPathsInScope(scope *Scope, cond *Paths, limit, index int) ([]*Paths, error) {
 	r := make([]*Paths, 0)
 	o := orm.NewOrm()
-	q := o.QueryTable("paths")
+	q := scope.filter(o.QueryTable("paths"), "app_set__id", "backup_set_id")
+	if scope != nil {
+		// A path in more than one app set of scope is joined more than once.
+		q = q.Distinct()
+	}
 	if cond.Id != "" {
 		q = q.Filter("id", cond.Id)
 	}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"github.com/pborman/uuid"
+)
+
+// Actions of permissions besides special ones like records:recover.
+const (
+	PermissionActionRead   = "read"
+	PermissionActionWrite  = "write"
+	PermissionActionDelete = "delete"
+)
+
+// 权限，格式为 资源:操作，如records:recover，*匹配任意资源或操作
+type Permissions struct {
+	Id    string   `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	Name  string   `orm:"size(64);unique;index" json:"name" valid:"Required;Match(/^([A-Za-z]+|\\*):([A-Za-z]+|\\*)$/)"`
+	Desc  string   `orm:"size(128);null" json:"description"`
+	Roles []*Roles `orm:"reverse(many)" json:"roles"`
+}
+
+// OperatorResources are what operators may write and delete. Users, roles
+// and credentials are left to admins, or an operator could grant himself *:*.
+var OperatorResources = []string{
+	"hosts", "appSets", "backupSets", "clientJobs", "paths", "policies",
+	"records", "oss", "oas", "oasJobs", "dispatches", "backupRuns", "incidents",
+}
+
+// DefaultPermissions are given to roles by RoleFlag when there is no
+// permission at all. Users change their own profile without any.
+var DefaultPermissions = map[int][]string{
+	RoleFlagAdmin:    {"*:*"},
+	RoleFlagOperator: operatorPermissions(),
+	RoleFlagUser:     {"*:read"},
+}
+
+func operatorPermissions() []string {
+	r := []string{"*:read", "records:recover", "hosts:keys", "signals:requeue"}
+	for _, v := range OperatorResources {
+		r = append(r, v+":"+PermissionActionWrite, v+":"+PermissionActionDelete)
+	}
+	return r
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(Permissions))
+	} else {
+		orm.RegisterModel(new(Permissions))
+	}
+}
+
+// MatchPermission tells if granted, which may have *, allows required.
+func MatchPermission(granted, required string) bool {
+	g := strings.SplitN(granted, ":", 2)
+	r := strings.SplitN(required, ":", 2)
+	if len(g) != 2 || len(r) != 2 {
+		return false
+	}
+	return (g[0] == "*" || g[0] == r[0]) && (g[1] == "*" || g[1] == r[1])
+}
+
+// HasPermission tells if any role of user allows required.
+func HasPermission(userId, required string) (bool, error) {
+	users, err := GetUser(&Users{Id: userId}, 1, 0)
+	if err != nil {
+		return false, err
+	}
+	if len(users) == 0 {
+		return false, nil
+	}
+	o := orm.NewOrm()
+	for _, role := range users[0].Roles {
+		_, err = o.LoadRelated(role, "Permissions")
+		if err != nil {
+			return false, err
+		}
+		for _, p := range role.Permissions {
+			if MatchPermission(p.Name, required) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func AddPermission(a *Permissions) (string, error) {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	a.Id = uuid.New()
+	beego.Debug("[M] Got new id:", a.Id)
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return "", fmt.Errorf("Bad info: %s", errS)
+	}
+	_, err = o.Insert(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	beego.Debug("[M] Permission saved")
+	o.Commit()
+	return a.Id, nil
+}
+
+func DeletePermission(a *Permissions) error {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = o.QueryM2M(a, "Roles").Clear()
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	_, err = o.Delete(a)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	o.Commit()
+	return nil
+}
+
+// If get all, just use &Permissions{}
+func GetPermissions(cond *Permissions, limit, index int) ([]*Permissions, error) {
+	r := make([]*Permissions, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("permissions")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Name != "" {
+		q = q.Filter("name", cond.Name)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// InitPermissions gives DefaultPermissions to roles if there is
+// no permission yet, e.g. database made by an older version.
+func InitPermissions() error {
+	o := orm.NewOrm()
+	n, err := o.QueryTable("permissions").Count()
+	if err != nil || n > 0 {
+		return err
+	}
+	beego.Info("[M] No permission found, make default ones.")
+
+	created := make(map[string]*Permissions)
+	roles, err := GetRole(&Roles{}, 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		perms := make([]*Permissions, 0)
+		for _, name := range DefaultPermissions[role.RoleFlag] {
+			p, ok := created[name]
+			if !ok {
+				p = &Permissions{Name: name}
+				_, err = AddPermission(p)
+				if err != nil {
+					return err
+				}
+				created[name] = p
+			}
+			perms = append(perms, p)
+		}
+		if len(perms) == 0 {
+			continue
+		}
+		_, err = o.QueryM2M(role, "Permissions").Add(perms)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -226,9 +226,14 @@ func UpdatePolicy(a *Policies) error {
 
 // If get all, just use &Policies{}
 func GetPolicies(cond *Policies, limit, index int) ([]*Policies, error) {
+	return GetPoliciesInScope(nil, cond, limit, index)
+}
+
+// GetPoliciesInScope is GetPolicies which only gets policies in scope.
+func GetPoliciesInScope(scope *Scope, cond *Policies, limit, index int) ([]*Policies, error) {
 	r := make([]*Policies, 0)
 	o := orm.NewOrm()
-	q := o.QueryTable("policies")
+	q := scope.filter(o.QueryTable("policies"), "", "backup_set_id")
 	if cond.Id != "" {
 		q = q.Filter("id", cond.Id)
 	}
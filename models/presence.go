@@ -0,0 +1,244 @@
+package models
+
+import (
+	"encoding/json"
+	"moduleab_server/common"
+	"os"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/garyburd/redigo/redis"
+	"github.com/pborman/uuid"
+)
+
+// Presence is what is known of the agent of a host, kept in redis so
+// every server sees it and it survives restarts. It is only saved by the
+// connection of ConnectionId, an old connection closing late does not
+// make the host offline.
+type Presence struct {
+	HostId           string    `json:"host_id"`
+	HostName         string    `json:"host_name"`
+	Online           bool      `json:"online"`
+	ConnectionId     string    `json:"connection_id,omitempty"`
+	Server           string    `json:"server,omitempty"`
+	RemoteIp         string    `json:"remote_ip,omitempty"`
+	AgentVersion     string    `json:"agent_version,omitempty"`
+	Os               string    `json:"os,omitempty"`
+	Capabilities     []string  `json:"capabilities,omitempty"`
+	Protocol         string    `json:"protocol,omitempty"`
+	ConnectedTime    time.Time `json:"connected_time"`
+	DisconnectedTime time.Time `json:"disconnected_time"`
+	LastSeenTime     time.Time `json:"last_seen_time"`
+}
+
+// FleetStatus sums up presence of hosts.
+type FleetStatus struct {
+	Total         int            `json:"total"`
+	Online        int            `json:"online"`
+	Offline       int            `json:"offline"`
+	NeverSeen     int            `json:"never_seen"`
+	AgentVersions map[string]int `json:"agent_versions"`
+	Hosts         []*Presence    `json:"hosts"`
+}
+
+var serverName, _ = os.Hostname()
+
+// PresenceStaleTimeout is how long an agent is still online
+// without heartbeat, e.g. when its server is gone.
+func PresenceStaleTimeout() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt64("presence::stale", 30)) * time.Second
+}
+
+func presenceRetention() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt64("presence::retention", 7)) * 24 * time.Hour
+}
+
+func presenceKey(hostId string) string {
+	return common.RedisKey("presence:" + hostId)
+}
+
+// NewPresence is a new connection of agent of host.
+func NewPresence(host *Hosts, remoteIp string, now time.Time) *Presence {
+	return &Presence{
+		HostId:        host.Id,
+		HostName:      host.Name,
+		Online:        true,
+		ConnectionId:  uuid.New(),
+		Server:        serverName,
+		RemoteIp:      remoteIp,
+		ConnectedTime: now,
+		LastSeenTime:  now,
+	}
+}
+
+// IsOnline tells if agent is connected and has been seen within stale.
+func (p *Presence) IsOnline(now time.Time, stale time.Duration) bool {
+	return p.Online && now.Sub(p.LastSeenTime) <= stale
+}
+
+func savePresence(p *Presence) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	c := common.RedisPool.Get()
+	defer c.Close()
+	_, err = c.Do("SET", presenceKey(p.HostId), b,
+		"EX", int64(presenceRetention()/time.Second))
+	return err
+}
+
+// SavePresence is called on connect, p replaces what is known.
+func SavePresence(p *Presence) error {
+	beego.Debug("[M] Got data:", p)
+	return savePresence(p)
+}
+
+// setPresenceIfScript saves presence only if it is still what was read,
+// as one step in redis. KEYS[1] is the key, ARGV is the value read, the
+// new value and its expiry.
+var setPresenceIfScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[2], "EX", ARGV[3])
+return 1
+`)
+
+// updatePresenceAttempts is how many times updatePresence tries when
+// presence is changed meanwhile by the same connection.
+const updatePresenceAttempts = 3
+
+// updatePresence changes presence of connection with f and saves it,
+// unless the host has connected again meanwhile.
+func updatePresence(hostId, connectionId string, f func(p *Presence)) error {
+	c := common.RedisPool.Get()
+	defer c.Close()
+	for i := 0; i < updatePresenceAttempts; i++ {
+		old, err := redis.Bytes(c.Do("GET", presenceKey(hostId)))
+		if err == redis.ErrNil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		p := new(Presence)
+		err = json.Unmarshal(old, p)
+		if err != nil {
+			return err
+		}
+		if p.ConnectionId != connectionId {
+			return nil
+		}
+		f(p)
+		b, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		ok, err := redis.Bool(setPresenceIfScript.Do(c, presenceKey(hostId), old, b,
+			int64(presenceRetention()/time.Second)))
+		if err != nil || ok {
+			return err
+		}
+		beego.Debug("[M] Presence changed meanwhile:", hostId, connectionId)
+	}
+	return nil
+}
+
+func PresenceHeartbeat(hostId, connectionId string) error {
+	return updatePresence(hostId, connectionId, func(p *Presence) {
+		p.Online = true
+		p.LastSeenTime = time.Now()
+	})
+}
+
+func PresenceDisconnected(hostId, connectionId string) error {
+	return updatePresence(hostId, connectionId, func(p *Presence) {
+		now := time.Now()
+		p.Online = false
+		p.LastSeenTime = now
+		p.DisconnectedTime = now
+	})
+}
+
+func getPresence(hostId string) (*Presence, error) {
+	c := common.RedisPool.Get()
+	defer c.Close()
+	b, err := redis.Bytes(c.Do("GET", presenceKey(hostId)))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	p := new(Presence)
+	return p, json.Unmarshal(b, p)
+}
+
+// GetPresence gets presence of host, nil if it has never been seen
+// within presence::retention.
+func GetPresence(hostId string) (*Presence, error) {
+	p, err := getPresence(hostId)
+	if p != nil {
+		p.Online = p.IsOnline(time.Now(), PresenceStaleTimeout())
+	}
+	return p, err
+}
+
+// GetPresences gets presence of hosts by id, those never seen are left out.
+func GetPresences(hostIds []string) (map[string]*Presence, error) {
+	r := make(map[string]*Presence)
+	if len(hostIds) == 0 {
+		return r, nil
+	}
+	keys := make([]interface{}, len(hostIds))
+	for i, v := range hostIds {
+		keys[i] = presenceKey(v)
+	}
+	c := common.RedisPool.Get()
+	defer c.Close()
+	values, err := redis.ByteSlices(c.Do("MGET", keys...))
+	if err != nil {
+		return nil, err
+	}
+	now, stale := time.Now(), PresenceStaleTimeout()
+	for i, b := range values {
+		if b == nil {
+			continue
+		}
+		p := new(Presence)
+		if err := json.Unmarshal(b, p); err != nil {
+			beego.Warn("[M] Bad presence of host:", hostIds[i], err)
+			continue
+		}
+		p.Online = p.IsOnline(now, stale)
+		r[hostIds[i]] = p
+	}
+	return r, nil
+}
+
+// SummarizePresence makes FleetStatus of hosts, presences are by host id
+// with Online already decided.
+func SummarizePresence(hosts []*Hosts, presences map[string]*Presence) *FleetStatus {
+	s := &FleetStatus{
+		Total:         len(hosts),
+		AgentVersions: make(map[string]int),
+		Hosts:         make([]*Presence, 0, len(hosts)),
+	}
+	for _, v := range hosts {
+		p, ok := presences[v.Id]
+		if !ok {
+			s.NeverSeen++
+			s.Hosts = append(s.Hosts, &Presence{HostId: v.Id, HostName: v.Name})
+			continue
+		}
+		if p.Online {
+			s.Online++
+		} else {
+			s.Offline++
+		}
+		if p.AgentVersion != "" {
+			s.AgentVersions[p.AgentVersion]++
+		}
+		s.Hosts = append(s.Hosts, p)
+	}
+	return s
+}
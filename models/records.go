@@ -2,6 +2,7 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"moduleab_server/common"
 	"strings"
@@ -45,13 +46,53 @@ type Records struct {
 	Jobs         []*OasJobs  `orm:"reverse(many);null" json:"jobs"`
 }
 
+var ErrorRecordNotOfHost = errors.New("Record must be under a path and app set of its host")
+
+// BindHost checks r posted by host is under one of paths of host, in app
+// set of host and in backup set of the path, and fills them in.
+func (r *Records) BindHost(host *Hosts) error {
+	if r.Path == nil || host.AppSet == nil {
+		return ErrorRecordNotOfHost
+	}
+	var path *Paths
+	for _, v := range host.Paths {
+		if (r.Path.Id != "" && v.Id == r.Path.Id) ||
+			(r.Path.Id == "" && r.Path.Path != "" && v.Path == r.Path.Path) {
+			path = v
+		}
+	}
+	if path == nil {
+		return ErrorRecordNotOfHost
+	}
+	if r.AppSet != nil && r.AppSet.Id != "" && r.AppSet.Id != host.AppSet.Id {
+		return ErrorRecordNotOfHost
+	}
+	if r.BackupSet != nil && r.BackupSet.Id != "" &&
+		(path.BackupSet == nil || r.BackupSet.Id != path.BackupSet.Id) {
+		return ErrorRecordNotOfHost
+	}
+	r.Host = host
+	r.Path = path
+	r.AppSet = host.AppSet
+	if path.BackupSet != nil {
+		r.BackupSet = path.BackupSet
+	}
+	return nil
+}
+
 func (r *Records) GetFullPath() string {
+	return MakeFullPath(r.AppSet.Name, r.Host.Name, r.Path.Path, r.Filename)
+}
+
+// MakeFullPath is the object key of a backup file, AppSet/Host/Path/Filename.
+// With an empty filename it's the prefix of all backups of the path.
+func MakeFullPath(appSet, host, path, filename string) string {
 	return strings.TrimSpace(
 		fmt.Sprintf("%s/%s%s/%s",
-			r.AppSet.Name,
-			r.Host.Name,
-			r.Path.Path,
-			r.Filename,
+			appSet,
+			host,
+			path,
+			filename,
 		),
 	)
 }
@@ -116,6 +157,9 @@ func AddRecord(record *Records) (string, error) {
 	}
 	beego.Debug("[M] Record data saved")
 	o.Commit()
+	if len(records) == 0 {
+		PublishRecordEvent(EventRecordCreated, record, nil)
+	}
 	return record.Id, nil
 }
 
@@ -182,9 +226,15 @@ func UpdateRecord(h *Records) error {
 // If get all, just use &Record{}
 func GetRecords(cond *Records, limit, index int, orderB, orderA bool,
 	times ...time.Time) ([]*Records, error) {
+	return GetRecordsInScope(nil, cond, limit, index, orderB, orderA, times...)
+}
+
+// GetRecordsInScope is GetRecords which only gets records in scope.
+func GetRecordsInScope(scope *Scope, cond *Records, limit, index int,
+	orderB, orderA bool, times ...time.Time) ([]*Records, error) {
 	r := make([]*Records, 0)
 	o := orm.NewOrm()
-	q := o.QueryTable("records")
+	q := scope.filter(o.QueryTable("records"), "app_set_id", "backup_set_id")
 	if cond.Id != "" {
 		q = q.Filter("id", cond.Id)
 	}
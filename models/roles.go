@@ -24,6 +24,9 @@ type Roles struct {
 	RoleFlag  int      `json:"role_flag" valid:"Required;Min(0)"`
 	Users     []*Users `orm:"reverse(many)"`
 	Removable bool     `orm:"default(1)" json:"removable"`
+	// 该角色的用户必须启用两步验证
+	RequireTotp bool           `orm:"default(0)" json:"require_totp"`
+	Permissions []*Permissions `orm:"rel(m2m)" json:"permissions"`
 }
 
 func init() {
@@ -65,7 +68,13 @@ func AddRole(a *Roles) (string, error) {
 		o.Rollback()
 		return "", err
 	}
-	_, err = o.QueryM2M(a, "Roles").Add(a.Users)
+	if len(a.Permissions) != 0 {
+		_, err = o.QueryM2M(a, "Permissions").Add(a.Permissions)
+		if err != nil {
+			o.Rollback()
+			return "", err
+		}
+	}
 	beego.Debug("[M] Role info saved")
 	o.Commit()
 	return a.Id, nil
@@ -92,6 +101,15 @@ func DeleteRole(a *Roles) error {
 		}
 		return fmt.Errorf("Bad info: %s", errS)
 	}
+	if !a.Removable {
+		o.Rollback()
+		return fmt.Errorf("Role %s is not removable", a.Name)
+	}
+	_, err = o.QueryM2M(a, "Permissions").Clear()
+	if err != nil {
+		o.Rollback()
+		return err
+	}
 	_, err = o.QueryTable("roles").Filter("removable", true).
 		Filter("id", a.Id).Filter("name", a.Name).Delete()
 	if err != nil {
@@ -128,6 +146,20 @@ func UpdateRole(a *Roles) error {
 		o.Rollback()
 		return err
 	}
+	if a.Permissions != nil {
+		_, err = o.QueryM2M(a, "Permissions").Clear()
+		if err != nil {
+			o.Rollback()
+			return err
+		}
+		if len(a.Permissions) != 0 {
+			_, err = o.QueryM2M(a, "Permissions").Add(a.Permissions)
+			if err != nil {
+				o.Rollback()
+				return err
+			}
+		}
+	}
 	o.Commit()
 	return nil
 }
@@ -156,6 +188,7 @@ func GetRole(cond *Roles, limit, index int) ([]*Roles, error) {
 	}
 	for _, v := range r {
 		o.LoadRelated(v, "Users", common.RelDepth)
+		o.LoadRelated(v, "Permissions")
 	}
 	return r, nil
 }
@@ -0,0 +1,252 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"github.com/pborman/uuid"
+)
+
+var (
+	ErrorOutOfScope    = errors.New("Out of your scope")
+	ErrorScopeNoUser   = errors.New("User of scope not found")
+	ErrorBadScopeOwner = errors.New("Bad info: one of role and user is needed")
+	ErrorBadScopeSet   = errors.New("Bad info: one of appset and backupset is needed")
+)
+
+// 资源范围绑定，把角色或用户限定在某些应用集或备份集上
+// 没有任何绑定的用户不受限制
+type ScopeBindings struct {
+	Id        string      `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	Role      *Roles      `orm:"rel(fk);on_delete(cascade);null" json:"role"`
+	User      *Users      `orm:"rel(fk);on_delete(cascade);null" json:"user"`
+	AppSet    *AppSets    `orm:"rel(fk);on_delete(cascade);null" json:"appset"`
+	BackupSet *BackupSets `orm:"rel(fk);on_delete(cascade);null" json:"backupset"`
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(ScopeBindings))
+	} else {
+		orm.RegisterModel(new(ScopeBindings))
+	}
+}
+
+// Scope is what a user is allowed to see, by ids of app sets and
+// backup sets. A nil set allows everything, and so does a nil *Scope.
+type Scope struct {
+	AppSets    map[string]bool
+	BackupSets map[string]bool
+}
+
+// NewScope merges bindings of a user and its roles,
+// it returns nil if there is no binding at all.
+func NewScope(bindings []*ScopeBindings) *Scope {
+	var s *Scope
+	for _, v := range bindings {
+		if s == nil {
+			s = new(Scope)
+		}
+		if v.AppSet != nil && v.AppSet.Id != "" {
+			if s.AppSets == nil {
+				s.AppSets = make(map[string]bool)
+			}
+			s.AppSets[v.AppSet.Id] = true
+		}
+		if v.BackupSet != nil && v.BackupSet.Id != "" {
+			if s.BackupSets == nil {
+				s.BackupSets = make(map[string]bool)
+			}
+			s.BackupSets[v.BackupSet.Id] = true
+		}
+	}
+	return s
+}
+
+func (s *Scope) AllowsAppSet(a *AppSets) bool {
+	if s == nil || s.AppSets == nil {
+		return true
+	}
+	return a != nil && s.AppSets[a.Id]
+}
+
+func (s *Scope) AllowsBackupSet(a *BackupSets) bool {
+	if s == nil || s.BackupSets == nil {
+		return true
+	}
+	return a != nil && s.BackupSets[a.Id]
+}
+
+// AllowsAppSets needs every one of app sets in scope, for what is
+// put into them.
+func (s *Scope) AllowsAppSets(a []*AppSets) bool {
+	for _, v := range a {
+		if !s.AllowsAppSet(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Scope) AllowsRecord(a *Records) bool {
+	return s.AllowsAppSet(a.AppSet) && s.AllowsBackupSet(a.BackupSet)
+}
+
+func (s *Scope) AllowsHost(a *Hosts) bool {
+	return s.AllowsAppSet(a.AppSet)
+}
+
+// AllowsPolicy only checks backup set, since policies
+// with no app set work on all app sets.
+func (s *Scope) AllowsPolicy(a *Policies) bool {
+	return s.AllowsBackupSet(a.BackupSet)
+}
+
+// AllowsPath needs any one of app sets of path in scope.
+func (s *Scope) AllowsPath(a *Paths) bool {
+	if !s.AllowsBackupSet(a.BackupSet) {
+		return false
+	}
+	if s == nil || s.AppSets == nil {
+		return true
+	}
+	for _, v := range a.AppSet {
+		if s.AllowsAppSet(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter limits q by scope, fields are names of the relations
+// to app sets and backup sets, empty if q has none of it.
+func (s *Scope) filter(q orm.QuerySeter, appSetField, backupSetField string) orm.QuerySeter {
+	if s == nil {
+		return q
+	}
+	if s.AppSets != nil && appSetField != "" {
+		q = q.Filter(appSetField+"__in", scopeIds(s.AppSets)...)
+	}
+	if s.BackupSets != nil && backupSetField != "" {
+		q = q.Filter(backupSetField+"__in", scopeIds(s.BackupSets)...)
+	}
+	return q
+}
+
+func scopeIds(m map[string]bool) []interface{} {
+	r := make([]interface{}, 0, len(m))
+	for k := range m {
+		r = append(r, k)
+	}
+	return r
+}
+
+// GetUserScope gets scope of user, nil means no limit,
+// which is always the case for administrators.
+func GetUserScope(userId string) (*Scope, error) {
+	users, err := GetUser(&Users{Id: userId}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrorScopeNoUser
+	}
+	cond := orm.NewCondition().Or("user_id", userId)
+	roleIds := make([]interface{}, 0)
+	for _, v := range users[0].Roles {
+		if v.RoleFlag == RoleFlagAdmin {
+			return nil, nil
+		}
+		roleIds = append(roleIds, v.Id)
+	}
+	if len(roleIds) != 0 {
+		cond = cond.Or("role_id__in", roleIds...)
+	}
+	bindings := make([]*ScopeBindings, 0)
+	o := orm.NewOrm()
+	_, err = o.QueryTable("scope_bindings").SetCond(cond).All(&bindings)
+	if err != nil {
+		return nil, err
+	}
+	return NewScope(bindings), nil
+}
+
+func AddScopeBinding(a *ScopeBindings) (string, error) {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	a.Id = uuid.New()
+	beego.Debug("[M] Got new id:", a.Id)
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return "", fmt.Errorf("Bad info: %s", errS)
+	}
+	if (a.Role == nil) == (a.User == nil) {
+		o.Rollback()
+		return "", ErrorBadScopeOwner
+	}
+	if (a.AppSet == nil) == (a.BackupSet == nil) {
+		o.Rollback()
+		return "", ErrorBadScopeSet
+	}
+	_, err = o.Insert(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	beego.Debug("[M] Scope binding saved")
+	o.Commit()
+	return a.Id, nil
+}
+
+func DeleteScopeBinding(a *ScopeBindings) error {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	_, err := o.Delete(a)
+	return err
+}
+
+// If get all, just use &ScopeBindings{}
+func GetScopeBindings(cond *ScopeBindings, limit, index int) ([]*ScopeBindings, error) {
+	r := make([]*ScopeBindings, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("scope_bindings")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Role != nil {
+		q = q.Filter("role_id", cond.Role.Id)
+	}
+	if cond.User != nil {
+		q = q.Filter("user_id", cond.User.Id)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	// User is not joined, so password hashes never go out with it.
+	_, err := q.RelatedSel("Role", "AppSet", "BackupSet").All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
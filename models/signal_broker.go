@@ -0,0 +1,184 @@
+package models
+
+import (
+	"errors"
+	"moduleab_server/common"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	SignalBrokerLocal = "local"
+	SignalBrokerRedis = "redis"
+)
+
+var ErrorSignalBrokerNotFound = errors.New("Signal broker not found")
+
+// SignalHub wakes up websockets of hosts connected to this server,
+// a host may have more than one.
+type SignalHub struct {
+	lock sync.RWMutex
+	subs map[string]map[chan struct{}]bool
+}
+
+func NewSignalHub() *SignalHub {
+	return &SignalHub{subs: make(map[string]map[chan struct{}]bool)}
+}
+
+// Subscribe returns a channel woken up when host has new signals,
+// and a func to call once it is not read any more.
+func (h *SignalHub) Subscribe(hostId string) (<-chan struct{}, func()) {
+	c := make(chan struct{}, 1)
+	h.lock.Lock()
+	if h.subs[hostId] == nil {
+		h.subs[hostId] = make(map[chan struct{}]bool)
+	}
+	h.subs[hostId][c] = true
+	h.lock.Unlock()
+	return c, func() {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		delete(h.subs[hostId], c)
+		if len(h.subs[hostId]) == 0 {
+			delete(h.subs, hostId)
+		}
+	}
+}
+
+// Wake tells subscribers of host, it never blocks since one
+// wake up is enough for any number of signals.
+func (h *SignalHub) Wake(hostId string) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for c := range h.subs[hostId] {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribers is how many websockets of host are on this server.
+func (h *SignalHub) Subscribers(hostId string) int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.subs[hostId])
+}
+
+// SignalBroker carries "host has new signals" to every server, so
+// the one holding websocket of the host gets it.
+type SignalBroker interface {
+	Publish(hostId string) error
+	// Run calls wake with hosts published by any server,
+	// it blocks until the broker fails.
+	Run(wake func(hostId string)) error
+}
+
+// LocalSignalBroker is for a single server.
+type LocalSignalBroker struct {
+	lock sync.RWMutex
+	wake func(hostId string)
+	done chan struct{}
+}
+
+func NewLocalSignalBroker() *LocalSignalBroker {
+	return &LocalSignalBroker{done: make(chan struct{})}
+}
+
+func (b *LocalSignalBroker) Publish(hostId string) error {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if b.wake != nil {
+		b.wake(hostId)
+	}
+	return nil
+}
+
+func (b *LocalSignalBroker) Run(wake func(hostId string)) error {
+	b.lock.Lock()
+	b.wake = wake
+	b.lock.Unlock()
+	<-b.done
+	return nil
+}
+
+// RedisSignalBroker uses pub/sub of redis, published
+// messages are lost if no server is subscribing.
+type RedisSignalBroker struct {
+	Channel string
+}
+
+func NewRedisSignalBroker() *RedisSignalBroker {
+	return &RedisSignalBroker{Channel: common.RedisKey("signals")}
+}
+
+func (b *RedisSignalBroker) Publish(hostId string) error {
+	c := common.RedisPool.Get()
+	defer c.Close()
+	_, err := c.Do("PUBLISH", b.Channel, hostId)
+	return err
+}
+
+func (b *RedisSignalBroker) Run(wake func(hostId string)) error {
+	// Subscribing connection is never given back to the pool.
+	c, err := common.RedisPool.Dial()
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: c}
+	defer psc.Close()
+	err = psc.Subscribe(b.Channel)
+	if err != nil {
+		return err
+	}
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			wake(string(v.Data))
+		case redis.Subscription:
+			beego.Debug("[M] Signal broker", v.Kind, v.Channel)
+		case error:
+			return v
+		}
+	}
+}
+
+var (
+	signalHub    = NewSignalHub()
+	signalBroker SignalBroker
+	brokerOnce   sync.Once
+)
+
+func getSignalBroker() SignalBroker {
+	brokerOnce.Do(func() {
+		switch name := beego.AppConfig.DefaultString("signal::broker", SignalBrokerRedis); name {
+		case SignalBrokerLocal:
+			signalBroker = NewLocalSignalBroker()
+		case SignalBrokerRedis:
+			signalBroker = NewRedisSignalBroker()
+		default:
+			beego.Warn("[M] Got error:", ErrorSignalBrokerNotFound, name)
+			signalBroker = NewLocalSignalBroker()
+		}
+	})
+	return signalBroker
+}
+
+// SubscribeSignals is for websocket of host, see SignalHub.Subscribe.
+func SubscribeSignals(hostId string) (<-chan struct{}, func()) {
+	return signalHub.Subscribe(hostId)
+}
+
+// RunSignalBroker passes what the broker gets to websockets of this
+// server, and comes back if the broker fails. It never returns.
+func RunSignalBroker() {
+	b := getSignalBroker()
+	for {
+		err := b.Run(signalHub.Wake)
+		beego.Warn("[M] Signal broker stopped:", err)
+		time.Sleep(5 * time.Second)
+	}
+}
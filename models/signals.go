@@ -1,145 +1,487 @@
 package models
 
 import (
-	"bytes"
-	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"moduleab_server/common"
-
+	"moduleab_server/notify"
+	"moduleab_server/storage"
 	"time"
 
 	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
 	"github.com/pborman/uuid"
 )
 
 const (
-	CacheSignalPrefix = "Signal_"
+	SignalTypeNothing = iota
+	SignalTypeDownload
+	// Back up a path now, instead of waiting for the period of the job.
+	SignalTypeBackup
+	// Download a record and check it can be restored.
+	SignalTypeVerify
+	// Delete local backups of a path older than the reserved time.
+	SignalTypeCleanup
+	// Run a hook configured on the agent by name.
+	SignalTypeRunHook
 )
 
+// SignalTypeNames are names of signal types servers dispatch, used in API.
+var SignalTypeNames = map[string]int{
+	"backup":  SignalTypeBackup,
+	"verify":  SignalTypeVerify,
+	"cleanup": SignalTypeCleanup,
+	"runhook": SignalTypeRunHook,
+}
+
 const (
-	SignalTypeNothing = iota
-	SignalTypeDownload
+	// Waiting for delivery, or for redelivery after a nack.
+	SignalStatusPending = "pending"
+	// Sent to agent, redelivered if not acked within visibility timeout.
+	SignalStatusDelivered = "delivered"
+	SignalStatusAcked     = "acked"
+	// Out of attempts, kept for admins to look at or requeue.
+	SignalStatusDead = "dead"
 )
 
 var (
 	ErrorSignalNotFound    = errors.New("Signal Not Found")
 	ErrorSignalBadDataType = errors.New("Bad data type")
-)
-
-var (
-	SignalChannels map[string]chan Signal
+	ErrorSignalNotDead     = errors.New("Only dead signals can be requeued")
 )
 
 func init() {
-	SignalChannels = make(map[string]chan Signal)
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(Signals))
+	} else {
+		orm.RegisterModel(new(Signals))
+	}
 }
 
+// Signal is what agents get, "id" and "attempts" are added on delivery.
 type Signal map[string]interface{}
 
-func AddSignal(hostId string, signal Signal) (string, error) {
-	keyName := fmt.Sprintf("%s%s", common.DefaultRedisKey, hostId)
-	newId := uuid.New()
-	signal["id"] = newId
-	var (
-		buf []byte
-		err error
-	)
-	var v = make([]Signal, 0)
-	if common.DefaultRedisClient.IsExist(keyName) {
-		b := common.DefaultRedisClient.Get(keyName)
-		v, err = fromGob(b.([]byte))
-		if err != nil {
-			return "", err
+// 发给agent的信号队列，每个主机一个队列，agent确认后才算完成
+type Signals struct {
+	Id          string    `orm:"pk;size(36)" json:"id"`
+	Host        *Hosts    `orm:"rel(fk);on_delete(cascade)" json:"-"`
+	Payload     string    `orm:"type(text)" json:"-"`
+	Status      string    `orm:"size(16);index" json:"status"`
+	Attempts    int       `orm:"default(0)" json:"attempts"`
+	LastError   string    `orm:"size(512);null" json:"last_error"`
+	VisibleTime time.Time `orm:"type(datetime);index" json:"visible_time"`
+	CreatedTime time.Time `orm:"auto_now_add;type(datetime)" json:"created_time"`
+	UpdatedTime time.Time `orm:"type(datetime);null;index" json:"updated_time"`
+}
+
+// SignalVisibilityTimeout is how long a delivered signal waits
+// for ack before it is delivered again.
+func SignalVisibilityTimeout() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt64(
+		"signal::visibilitytimeout", 300)) * time.Second
+}
+
+// SignalMaxAttempts is deliveries of a signal before it is dead.
+func SignalMaxAttempts() int {
+	return beego.AppConfig.DefaultInt("signal::maxattempts", 5)
+}
+
+// Signal is the payload with id and attempts, as agents get it.
+func (a *Signals) Signal() (Signal, error) {
+	s := make(Signal)
+	err := json.Unmarshal([]byte(a.Payload), &s)
+	if err != nil {
+		return nil, err
+	}
+	s["id"] = a.Id
+	s["attempts"] = a.Attempts
+	return s, nil
+}
+
+// Deliverable tells if the signal should be sent to agent at now.
+func (a *Signals) Deliverable(now time.Time) bool {
+	return (a.Status == SignalStatusPending || a.Status == SignalStatusDelivered) &&
+		!a.VisibleTime.After(now)
+}
+
+// Deliver counts an attempt and hides the signal for timeout. It returns
+// false and makes the signal dead if it has no attempt left.
+func (a *Signals) Deliver(now time.Time, timeout time.Duration, maxAttempts int) bool {
+	a.UpdatedTime = now
+	if a.Attempts >= maxAttempts {
+		a.Status = SignalStatusDead
+		if a.LastError == "" {
+			a.LastError = "Not acked after all attempts"
 		}
-		beego.Debug("Got from redis:", v)
+		return false
 	}
-	v = append(v, signal)
-	buf, err = toGob(v)
+	a.Attempts++
+	a.Status = SignalStatusDelivered
+	a.VisibleTime = now.Add(timeout)
+	return true
+}
+
+// Nack makes the signal deliverable again at once, or dead if it has
+// no attempt left.
+func (a *Signals) Nack(now time.Time, reason string, maxAttempts int) {
+	a.UpdatedTime = now
+	a.LastError = reason
+	if a.Attempts >= maxAttempts {
+		a.Status = SignalStatusDead
+		return
+	}
+	a.Status = SignalStatusPending
+	a.VisibleTime = now
+}
+
+func AddSignal(hostId string, signal Signal) (string, error) {
+	beego.Debug("[M] Got data:", hostId, signal)
+	return insertSignal(orm.NewOrm(), hostId, signal)
+}
+
+// insertSignal adds signal for host with o, which may be in a transaction.
+func insertSignal(o orm.Ormer, hostId string, signal Signal) (string, error) {
+	delete(signal, "id")
+	delete(signal, "attempts")
+	b, err := json.Marshal(signal)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	a := &Signals{
+		Id:          uuid.New(),
+		Host:        &Hosts{Id: hostId},
+		Payload:     string(b),
+		Status:      SignalStatusPending,
+		VisibleTime: now,
+		UpdatedTime: now,
+	}
+	_, err = o.Insert(a)
 	if err != nil {
 		return "", err
 	}
-	return newId, common.DefaultRedisClient.Put(keyName, buf, 30*time.Minute)
+	return a.Id, nil
+}
+
+// If get all, just use &Signals{}, oldest first.
+func GetSignalRecords(cond *Signals, limit, index int) ([]*Signals, error) {
+	r := make([]*Signals, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("signals")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Host != nil && cond.Host.Id != "" {
+		q = q.Filter("host__id", cond.Host.Id)
+	}
+	if cond.Status != "" {
+		q = q.Filter("status", cond.Status)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.OrderBy("created_time").All(&r)
+	return r, err
+}
+
+// GetSignals gets signals of host not acked yet, or only those
+// with status, e.g. dead ones.
+func GetSignals(hostId, status string) ([]Signal, error) {
+	records := make([]*Signals, 0)
+	var err error
+	if status == "" {
+		_, err = orm.NewOrm().QueryTable("signals").
+			Filter("host__id", hostId).
+			Filter("status__in", SignalStatusPending, SignalStatusDelivered).
+			OrderBy("created_time").All(&records)
+	} else {
+		records, err = GetSignalRecords(&Signals{
+			Host: &Hosts{Id: hostId}, Status: status,
+		}, 0, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := make([]Signal, 0, len(records))
+	for _, v := range records {
+		s, err := v.Signal()
+		if err != nil {
+			beego.Warn("[M] Bad payload of signal:", v.Id, err)
+			continue
+		}
+		s["status"] = v.Status
+		r = append(r, s)
+	}
+	return r, nil
 }
 
-func GetSignals(hostId string) []Signal {
-	keyName := fmt.Sprintf("%s%s", common.DefaultRedisKey, hostId)
-	b := common.DefaultRedisClient.Get(keyName)
-	beego.Debug("Got from redis:", b)
-	v, err := fromGob(b.([]byte))
+func getHostSignal(hostId, id string) (*Signals, error) {
+	r, err := GetSignalRecords(&Signals{Id: id, Host: &Hosts{Id: hostId}}, 1, 0)
 	if err != nil {
-		beego.Warn(err)
-		return nil
+		return nil, err
 	}
-	return v
+	if len(r) == 0 {
+		return nil, ErrorSignalNotFound
+	}
+	return r[0], nil
 }
 
 func GetSignal(hostId, id string) (Signal, error) {
-	signals := GetSignals(hostId)
-	beego.Debug("Signals", signals)
-	for _, v := range signals {
-		if v["id"] == id {
-			return v, nil
-		}
+	a, err := getHostSignal(hostId, id)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("Got nothing")
+	s, err := a.Signal()
+	if err != nil {
+		return nil, err
+	}
+	s["status"] = a.Status
+	return s, nil
 }
 
-func TruncateSignals(hostId string) {
-	keyName := fmt.Sprintf("%s%s", common.DefaultRedisKey, hostId)
-	common.DefaultRedisClient.Delete(keyName)
+// updateSignal saves a unless it has been changed since it was read,
+// so two servers never deliver one signal at the same time.
+func updateSignal(a *Signals, oldStatus string, oldAttempts int) (bool, error) {
+	o := orm.NewOrm()
+	n, err := o.QueryTable("signals").
+		Filter("id", a.Id).
+		Filter("status", oldStatus).
+		Filter("attempts", oldAttempts).
+		Update(orm.Params{
+			"status":       a.Status,
+			"attempts":     a.Attempts,
+			"last_error":   a.LastError,
+			"visible_time": a.VisibleTime,
+			"updated_time": a.UpdatedTime,
+		})
+	return n == 1, err
 }
 
-func DeleteSignal(hostId string, signalId string) error {
-	keyName := fmt.Sprintf("%s%s", common.DefaultRedisKey, hostId)
-	if common.DefaultRedisClient.IsExist(keyName) {
-		v := common.DefaultRedisClient.Get(keyName)
-		n, ok := v.([]Signal)
+// ReceiveSignals takes at most limit deliverable signals of host, oldest
+// first. They come again after the visibility timeout unless acked.
+func ReceiveSignals(hostId string, limit int) ([]Signal, error) {
+	now := time.Now()
+	records := make([]*Signals, 0)
+	q := orm.NewOrm().QueryTable("signals").
+		Filter("host__id", hostId).
+		Filter("status__in", SignalStatusPending, SignalStatusDelivered).
+		Filter("visible_time__lte", now).
+		OrderBy("created_time")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	_, err := q.All(&records)
+	if err != nil {
+		return nil, err
+	}
+	timeout, maxAttempts := SignalVisibilityTimeout(), SignalMaxAttempts()
+	r := make([]Signal, 0, len(records))
+	for _, v := range records {
+		status, attempts := v.Status, v.Attempts
+		ok := v.Deliver(now, timeout, maxAttempts)
+		updated, err := updateSignal(v, status, attempts)
+		if err != nil {
+			return r, err
+		}
+		if !updated {
+			beego.Debug("[M] Signal taken by others:", v.Id)
+			continue
+		}
 		if !ok {
-			return ErrorSignalBadDataType
+			signalDead(hostId, v)
+			continue
 		}
-		var a = make([]Signal, 0)
-		for _, v := range n {
-			if v["id"] != signalId {
-				a = append(a, v)
-			}
+		s, err := v.Signal()
+		if err != nil {
+			beego.Warn("[M] Bad payload of signal:", v.Id, err)
+			continue
 		}
-		return common.DefaultRedisClient.Put(keyName, a, 30*time.Minute)
+		r = append(r, s)
 	}
-	return ErrorSignalNotFound
+	return r, nil
 }
 
-func NotifySignal(hostId, signalId string) error {
-	_, ok := SignalChannels[hostId]
-	if !ok {
-		SignalChannels[hostId] = make(chan Signal, 1024)
+// AckSignal marks signal done, it will never be delivered again.
+func AckSignal(hostId, id string) error {
+	a, err := getHostSignal(hostId, id)
+	if err != nil {
+		return err
+	}
+	if a.Status == SignalStatusAcked {
+		return nil
+	}
+	status, attempts := a.Status, a.Attempts
+	a.Status = SignalStatusAcked
+	a.UpdatedTime = time.Now()
+	updated, err := updateSignal(a, status, attempts)
+	if err == nil && !updated {
+		// Delivered again meanwhile, ack that one instead.
+		return AckSignal(hostId, id)
+	}
+	return err
+}
+
+// NackSignal tells the agent failed with signal, it will be
+// delivered again unless it is out of attempts.
+func NackSignal(hostId, id, reason string) error {
+	a, err := getHostSignal(hostId, id)
+	if err != nil {
+		return err
+	}
+	if a.Status != SignalStatusDelivered {
+		return nil
+	}
+	status, attempts := a.Status, a.Attempts
+	if len(reason) > 512 {
+		reason = reason[:512]
+	}
+	a.Nack(time.Now(), reason, SignalMaxAttempts())
+	_, err = updateSignal(a, status, attempts)
+	if err == nil && a.Status == SignalStatusDead {
+		signalDead(hostId, a)
+	}
+	return err
+}
+
+// signalDead tells admins about dead signal a and fails its runs.
+func signalDead(hostId string, a *Signals) {
+	beego.Warn("[M] Signal is dead:", a.Id, a.LastError)
+	notifySignalDead(hostId, a)
+	err := failSignalRuns(hostId, a)
+	if err != nil {
+		beego.Warn("[M] Got error on failing runs of signal:", a.Id, err)
+	}
+}
+
+func notifySignalDead(hostId string, a *Signals) {
+	Notify(EventSignalDead, notify.SeverityWarning, "Signal is dead",
+		a.LastError, map[string]string{
+			"host":     hostId,
+			"signal":   a.Id,
+			"attempts": fmt.Sprint(a.Attempts),
+		})
+}
+
+// RequeueSignal gives a dead signal all its attempts again.
+func RequeueSignal(hostId, id string) error {
+	a, err := getHostSignal(hostId, id)
+	if err != nil {
+		return err
+	}
+	if a.Status != SignalStatusDead {
+		return ErrorSignalNotDead
 	}
-	signal, err := GetSignal(hostId, signalId)
+	status, attempts := a.Status, a.Attempts
+	now := time.Now()
+	a.Status = SignalStatusPending
+	a.Attempts = 0
+	a.VisibleTime = now
+	a.UpdatedTime = now
+	_, err = updateSignal(a, status, attempts)
+	return err
+}
+
+// PurgeSignals deletes acked and dead signals not updated since before.
+func PurgeSignals(before time.Time) (int64, error) {
+	return orm.NewOrm().QueryTable("signals").
+		Filter("status__in", SignalStatusAcked, SignalStatusDead).
+		Filter("updated_time__lt", before).
+		Delete()
+}
+
+// NotifySignal wakes up websocket of host on whichever server it is,
+// so signals are delivered at once instead of at the next ping.
+func NotifySignal(hostId, signalId string) error {
+	_, err := getHostSignal(hostId, signalId)
 	if err != nil {
 		return err
 	}
-	SignalChannels[hostId] <- signal
-	return nil
+	err = getSignalBroker().Publish(hostId)
+	if err != nil {
+		// At least websockets here need not wait.
+		signalHub.Wake(hostId)
+	}
+	return err
 }
 
-func MakeDownloadSignal(path, endpoint, bucket string) Signal {
+// MakeDownloadSignal tells agent to download path from hot storage oss.
+// If the storage can presign, "url" is all the agent needs,
+// otherwise it should use endpoint and bucket with its own credentials.
+func MakeDownloadSignal(path string, oss *Oss) Signal {
 	s := make(Signal)
 	s["type"] = SignalTypeDownload
 	s["path"] = path
-	s["endpoint"] = endpoint
-	s["bucket"] = bucket
+	s["storage"] = oss.Type
+	s["endpoint"] = oss.Endpoint
+	s["bucket"] = oss.BucketName
+	s["region"] = oss.Region
+	s["path_style"] = oss.PathStyle
+
+	conf, err := oss.StorageConfig()
+	if err != nil {
+		beego.Warn("Bad hot storage config:", err)
+		return s
+	}
+	hot, err := storage.NewHotStorage(conf)
+	if err != nil {
+		beego.Warn("Cannot connect to hot storage:", err)
+		return s
+	}
+	if p, ok := hot.(storage.Presigner); ok {
+		expire := beego.AppConfig.DefaultInt64("storage::presignexpire", 3600)
+		url, err := p.PresignURL(
+			"GET", path, time.Duration(expire)*time.Second)
+		if err != nil {
+			beego.Warn("Cannot presign url:", err)
+			return s
+		}
+		s["url"] = url
+	}
+	return s
+}
+
+// MakeBackupSignal tells agent to back up path, job is nil if it is
+// not of a job. Agent gets credentials with the token API as usual.
+func MakeBackupSignal(path *Paths, job *ClientJobs) Signal {
+	s := make(Signal)
+	s["type"] = SignalTypeBackup
+	s["path"] = path.Path
+	if job != nil {
+		s["job"] = job.Id
+		s["job_type"] = job.Type
+		s["reserved_time"] = job.ReservedTime
+	}
 	return s
 }
 
-func toGob(v interface{}) ([]byte, error) {
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(v)
-	return buf.Bytes(), err
+// MakeVerifySignal tells agent to download record like a download
+// signal, and check it instead of restoring it.
+func MakeVerifySignal(r *Records) Signal {
+	s := MakeDownloadSignal(r.GetFullPath(), r.BackupSet.Oss)
+	s["type"] = SignalTypeVerify
+	s["record"] = r.Id
+	return s
 }
 
-func fromGob(b []byte) ([]Signal, error) {
-	var v = make([]Signal, 0)
-	var buf = bytes.NewBuffer(b)
-	err := gob.NewDecoder(buf).Decode(&v)
-	return v, err
+// MakeCleanupSignal tells agent to delete local backups of path older
+// than reservedTime seconds.
+func MakeCleanupSignal(path string, reservedTime int) Signal {
+	s := make(Signal)
+	s["type"] = SignalTypeCleanup
+	s["path"] = path
+	s["reserved_time"] = reservedTime
+	return s
+}
+
+// MakeRunHookSignal tells agent to run its hook of name with args.
+// Only hooks configured on the agent can run, never a command.
+func MakeRunHookSignal(hook string, args map[string]string) Signal {
+	s := make(Signal)
+	s["type"] = SignalTypeRunHook
+	s["hook"] = hook
+	s["args"] = args
+	return s
 }
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"errors"
+	"moduleab_server/storage"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+var (
+	ErrorHostNoAppSet      = errors.New("Host does not belong to any app set")
+	ErrorTokenNotSupported = errors.New("Hot storage can not issue tokens")
+	ErrorTokenNoFilename   = errors.New("Filename is required for presigned URLs")
+	ErrorTokenBadFilename  = errors.New("Filename must be a plain name, without / \\ or ..")
+)
+
+// ValidTokenFilename tells if name stays in the prefix of path, it must be
+// a plain file name.
+func ValidTokenFilename(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.Contains(name, "..") {
+		return ErrorTokenBadFilename
+	}
+	return nil
+}
+
+// 仅能访问主机自身路径前缀的临时凭据
+type StorageToken struct {
+	BackupSet string `json:"backup_set"`
+	Path      string `json:"path"`
+	Storage   string `json:"storage"`
+	Endpoint  string `json:"endpoint"`
+	Bucket    string `json:"bucket"`
+	Region    string `json:"region"`
+	PathStyle bool   `json:"path_style"`
+	// STS-style, for storages implementing storage.TokenIssuer.
+	Token *storage.Token `json:"token,omitempty"`
+	// Presigned, only when a filename is asked for.
+	UploadURL   string    `json:"upload_url,omitempty"`
+	DownloadURL string    `json:"download_url,omitempty"`
+	Expiration  time.Time `json:"expiration"`
+}
+
+// IssueStorageTokens makes a token for every path of host, or only the one
+// equal to path if it is not empty. The token is scoped to
+// MakeFullPath(AppSet, Host, Path, ""), so a host cannot touch others' backups.
+// Storages that cannot issue a token get presigned URLs of filename instead.
+func IssueStorageTokens(host *Hosts, path, filename string) ([]*StorageToken, error) {
+	if host.AppSet == nil || host.AppSet.Name == "" {
+		return nil, ErrorHostNoAppSet
+	}
+	if filename != "" {
+		if err := ValidTokenFilename(filename); err != nil {
+			return nil, err
+		}
+	}
+	expires := time.Duration(
+		beego.AppConfig.DefaultInt64("storage::tokenexpire", 900),
+	) * time.Second
+
+	r := make([]*StorageToken, 0)
+	for _, p := range host.Paths {
+		if p.BackupSet == nil || (path != "" && p.Path != path) {
+			continue
+		}
+		sets, err := GetBackupSets(&BackupSets{Id: p.BackupSet.Id}, 1, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(sets) == 0 || sets[0].Oss == nil {
+			continue
+		}
+		conf, err := sets[0].Oss.StorageConfig()
+		if err != nil {
+			return nil, err
+		}
+		hot, err := storage.NewHotStorage(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := MakeFullPath(host.AppSet.Name, host.Name, p.Path, "")
+		t := &StorageToken{
+			BackupSet:  sets[0].Name,
+			Path:       p.Path,
+			Storage:    conf.Type,
+			Endpoint:   conf.Endpoint,
+			Bucket:     conf.Bucket,
+			Region:     conf.Region,
+			PathStyle:  conf.PathStyle,
+			Expiration: time.Now().Add(expires),
+		}
+
+		issued := false
+		if issuer, ok := hot.(storage.TokenIssuer); ok {
+			t.Token, err = issuer.IssueToken(prefix, expires)
+			if err == nil {
+				t.Expiration = t.Token.Expiration
+				issued = true
+			} else if err != storage.ErrorNotSupported {
+				return nil, err
+			}
+		}
+		presigner, canPresign := hot.(storage.Presigner)
+		if canPresign && filename != "" {
+			key := MakeFullPath(host.AppSet.Name, host.Name, p.Path, filename)
+			t.UploadURL, err = presigner.PresignURL(http.MethodPut, key, expires)
+			if err != nil {
+				return nil, err
+			}
+			t.DownloadURL, err = presigner.PresignURL(http.MethodGet, key, expires)
+			if err != nil {
+				return nil, err
+			}
+			issued = true
+		}
+		if !issued {
+			if canPresign {
+				return nil, ErrorTokenNoFilename
+			}
+			return nil, ErrorTokenNotSupported
+		}
+		r = append(r, t)
+	}
+	return r, nil
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"moduleab_server/common"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+// How many recovery codes a user gets, each can be used once.
+const RecoveryCodeCount = 10
+
+var (
+	ErrorTotpNotEnrolled = errors.New("Two-factor authentication is not enrolled")
+	ErrorTotpRequired    = errors.New("Two-factor authentication is required by role")
+	ErrorBadTotpCode     = errors.New("Bad two-factor code")
+)
+
+// RequiresTotp is true if any role of user requires two-factor authentication.
+// Roles must be loaded, which GetUser does.
+func (u *Users) RequiresTotp() bool {
+	for _, v := range u.Roles {
+		if v.RequireTotp {
+			return true
+		}
+	}
+	return false
+}
+
+// EnrollTotp makes a new secret for user, which is not used for login
+// until EnableTotp verifies a code of it. It returns the secret
+// and its otpauth URI.
+func EnrollTotp(u *Users) (string, string, error) {
+	beego.Debug("[M] Got data:", u.Name)
+	secret, err := common.NewTotpSecret()
+	if err != nil {
+		return "", "", err
+	}
+	u.TotpSecret, err = common.EncryptSecret(secret)
+	if err != nil {
+		return "", "", err
+	}
+	u.TotpEnabled = false
+	u.TotpLastStep = 0
+	_, err = orm.NewOrm().Update(u, "TotpSecret", "TotpEnabled", "TotpLastStep")
+	if err != nil {
+		return "", "", err
+	}
+	issuer := beego.AppConfig.DefaultString("security::totpissuer", "ModuleAB")
+	return secret, common.TotpURI(issuer, u.Name, secret), nil
+}
+
+// EnableTotp turns two-factor authentication on if code is right,
+// and returns new recovery codes.
+func EnableTotp(u *Users, code string) ([]string, error) {
+	beego.Debug("[M] Got data:", u.Name)
+	if u.TotpSecret == "" {
+		return nil, ErrorTotpNotEnrolled
+	}
+	err := verifyTotp(u, code)
+	if err != nil {
+		return nil, err
+	}
+	u.TotpEnabled = true
+	_, err = orm.NewOrm().Update(u, "TotpEnabled")
+	if err != nil {
+		return nil, err
+	}
+	return NewRecoveryCodes(u)
+}
+
+// DisableTotp is refused if any role of user requires it.
+func DisableTotp(u *Users) error {
+	beego.Debug("[M] Got data:", u.Name)
+	if u.RequiresTotp() {
+		return ErrorTotpRequired
+	}
+	u.TotpEnabled = false
+	u.TotpSecret = ""
+	u.TotpLastStep = 0
+	u.RecoveryCodes = ""
+	_, err := orm.NewOrm().Update(u,
+		"TotpEnabled", "TotpSecret", "TotpLastStep", "RecoveryCodes",
+	)
+	return err
+}
+
+// NewRecoveryCodes replaces all recovery codes of user,
+// they are only shown this time.
+func NewRecoveryCodes(u *Users) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = hex.EncodeToString(b)
+		hashes[i], err = common.EncryptPassword(codes[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	u.RecoveryCodes = strings.Join(hashes, ",")
+	_, err := orm.NewOrm().Update(u, "RecoveryCodes")
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor checks code of authenticator app, or a recovery
+// code which can not be used again.
+func VerifySecondFactor(u *Users, code, recoveryCode string) error {
+	if !u.TotpEnabled {
+		return ErrorTotpNotEnrolled
+	}
+	if code != "" {
+		return verifyTotp(u, code)
+	}
+	recoveryCode = strings.ToLower(strings.TrimSpace(recoveryCode))
+	if recoveryCode == "" || u.RecoveryCodes == "" {
+		return ErrorBadTotpCode
+	}
+	hashes := strings.Split(u.RecoveryCodes, ",")
+	for i, v := range hashes {
+		if ok, _ := common.VerifyPassword(v, recoveryCode); ok {
+			beego.Info("[M] Recovery code used by:", u.Name)
+			u.RecoveryCodes = strings.Join(append(hashes[:i], hashes[i+1:]...), ",")
+			_, err := orm.NewOrm().Update(u, "RecoveryCodes")
+			return err
+		}
+	}
+	return ErrorBadTotpCode
+}
+
+func verifyTotp(u *Users, code string) error {
+	secret, err := common.DecryptSecret(u.TotpSecret)
+	if err != nil {
+		return err
+	}
+	step := common.VerifyTotp(secret, code, time.Now(), u.TotpLastStep)
+	if step == 0 {
+		return ErrorBadTotpCode
+	}
+	u.TotpLastStep = step
+	_, err = orm.NewOrm().Update(u, "TotpLastStep")
+	return err
+}
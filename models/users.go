@@ -1,6 +1,7 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"moduleab_server/common"
 
@@ -12,14 +13,30 @@ import (
 
 //用户
 type Users struct {
-	Id        string   `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
-	Name      string   `orm:"size(32);unique;index" json:"loginname" valid:"Required"`
-	ShowName  string   `json:"name" valid:"Required"`
-	Password  string   `valid:"Required" json:"password" valid:"Base64"`
-	Roles     []*Roles `orm:"rel(m2m)" valid:"Required"`
-	Removable bool     `orm:"default(1)" json:"removable"`
+	Id          string   `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	Name        string   `orm:"size(32);unique;index" json:"loginname" valid:"Required"`
+	ShowName    string   `json:"name" valid:"Required"`
+	Password    string   `valid:"Required" json:"-" valid:"Base64"`
+	NewPassword string   `orm:"-" json:"password,omitempty"` // 只写，保存时哈希为Password
+	Roles       []*Roles `orm:"rel(m2m)" valid:"Required"`
+	Removable   bool     `orm:"default(1)" json:"removable"`
+	// 两步验证，密钥加密保存，恢复码只保存bcrypt哈希
+	TotpEnabled   bool   `orm:"default(0)" json:"totp_enabled"`
+	TotpSecret    string `orm:"size(512);null" json:"-"`
+	TotpLastStep  int64  `orm:"default(0)" json:"-"`
+	RecoveryCodes string `orm:"type(text);null" json:"-"`
+	// 用户来源，local或ldap等，目录用户在第一次登录时创建
+	Source string `orm:"size(16);default(local)" json:"source"`
+	// 用户在目录中不变的标识，OIDC为"iss sub"，登录名可以改，按它找到用户
+	Subject *string `orm:"size(255);null;unique" json:"-"`
 }
 
+var ErrorBadCredentials = errors.New("Bad user name or password")
+
+// Hash of nothing, compared with when user is not found,
+// so that it takes as long as a wrong password.
+var dummyPassword, _ = common.EncryptPassword("")
+
 func init() {
 	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
 		orm.RegisterModelWithPrefix(prefix, new(Users))
@@ -29,7 +46,7 @@ func init() {
 }
 
 func AddUser(a *Users) (string, error) {
-	beego.Debug("[M] Got data:", a)
+	beego.Debug("[M] Got data:", a.Name)
 	o := orm.NewOrm()
 	err := o.Begin()
 	if err != nil {
@@ -38,7 +55,13 @@ func AddUser(a *Users) (string, error) {
 
 	a.Id = uuid.New()
 	beego.Debug("[M] Got new id:", a.Id)
-	a.Password = common.EncryptPassword(a.Password)
+	if a.NewPassword != "" {
+		a.Password, err = common.EncryptPassword(a.NewPassword)
+		if err != nil {
+			o.Rollback()
+			return "", err
+		}
+	}
 	validator := new(validation.Validation)
 	valid, err := validator.Valid(a)
 	if err != nil {
@@ -53,7 +76,6 @@ func AddUser(a *Users) (string, error) {
 		}
 		return "", fmt.Errorf("Bad info: %s", errS)
 	}
-	beego.Debug("[M] Got new data:", a)
 	_, err = o.Insert(a)
 	if err != nil {
 		o.Rollback()
@@ -105,13 +127,33 @@ func DeleteUser(a *Users) error {
 	return nil
 }
 
+// UpdateUserProfile only changes what users may change of themselves.
+func UpdateUserProfile(a *Users) error {
+	beego.Debug("[M] Got data:", a.Id)
+	if a.ShowName == "" || a.Password == "" {
+		return fmt.Errorf("Bad info: name and password can not be empty")
+	}
+	_, err := orm.NewOrm().Update(a, "ShowName", "Password")
+	return err
+}
+
+// UpdateUser keeps the password if a has no NewPassword.
 func UpdateUser(a *Users) error {
-	beego.Debug("[M] Got data:", a)
+	beego.Debug("[M] Got data:", a.Name)
 	o := orm.NewOrm()
 	err := o.Begin()
 	if err != nil {
 		return err
 	}
+	fields := []string{"Name", "ShowName", "Removable"}
+	if a.NewPassword != "" {
+		a.Password, err = common.EncryptPassword(a.NewPassword)
+		if err != nil {
+			o.Rollback()
+			return err
+		}
+		fields = append(fields, "Password")
+	}
 	validator := new(validation.Validation)
 	valid, err := validator.Valid(a)
 	if err != nil {
@@ -126,7 +168,8 @@ func UpdateUser(a *Users) error {
 		}
 		return fmt.Errorf("Bad info: %s", errS)
 	}
-	_, err = o.Update(a)
+	// Two-factor fields are only changed by their own functions.
+	_, err = o.Update(a, fields...)
 	if err != nil {
 		o.Rollback()
 		return err
@@ -161,8 +204,8 @@ func GetUser(cond *Users, limit, index int) ([]*Users, error) {
 	if cond.ShowName != "" {
 		q = q.Filter("show_name", cond.ShowName)
 	}
-	if cond.Password != "" {
-		q = q.Filter("password", common.EncryptPassword(cond.Password))
+	if cond.Subject != nil {
+		q = q.Filter("subject", *cond.Subject)
 	}
 	if limit > 0 {
 		q = q.Limit(limit)
@@ -180,3 +223,44 @@ func GetUser(cond *Users, limit, index int) ([]*Users, error) {
 	}
 	return r, nil
 }
+
+// IsLocal tells if password of user is kept in database.
+func (a *Users) IsLocal() bool {
+	return a.Source == "" || a.Source == UserSourceLocal
+}
+
+// LocalAuthenticator checks passwords in database. Legacy SHA1 hashes
+// are replaced with bcrypt once the user logs in successfully.
+type LocalAuthenticator struct{}
+
+func (LocalAuthenticator) Authenticate(name, password string) (*Users, error) {
+	if name == "" || password == "" {
+		return nil, ErrorBadCredentials
+	}
+	users, err := GetUser(&Users{Name: name}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 || !users[0].IsLocal() {
+		common.VerifyPassword(dummyPassword, password)
+		return nil, ErrorBadCredentials
+	}
+	user := users[0]
+	ok, rehash := common.VerifyPassword(user.Password, password)
+	if !ok {
+		return nil, ErrorBadCredentials
+	}
+	if rehash {
+		beego.Info("[M] Rehash password of user:", user.Name)
+		hash, err := common.EncryptPassword(password)
+		if err == nil {
+			user.Password = hash
+			_, err = orm.NewOrm().Update(user, "Password")
+		}
+		if err != nil {
+			// Login still works with the old hash.
+			beego.Warn("[M] Failed to rehash password:", err)
+		}
+	}
+	return user, nil
+}
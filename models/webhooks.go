@@ -0,0 +1,416 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"moduleab_server/common"
+	"moduleab_server/notify"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"github.com/pborman/uuid"
+)
+
+const (
+	WebhookStatusPending = deliveryPending
+	WebhookStatusSent    = deliverySent
+	WebhookStatusFailed  = deliveryFailed
+)
+
+var (
+	ErrorWebhookUrl     = errors.New("Url of webhook must be http or https")
+	ErrorWebhookPending = errors.New("Only sent or failed deliveries can be redelivered")
+)
+
+// Webhook订阅：事件发送到Url，Events为逗号分隔的事件名模式，如 record.*，
+// 为空则订阅全部事件。Secret用于签名，加密保存
+type WebhookSubscriptions struct {
+	Id          string    `orm:"pk;size(36)" json:"id"`
+	Name        string    `orm:"size(64);unique" json:"name" valid:"Required"`
+	Url         string    `orm:"size(512)" json:"url" valid:"Required"`
+	Events      string    `orm:"size(512);null" json:"events"`
+	Secret      string    `orm:"size(512)" json:"secret,omitempty"`
+	Enabled     bool      `orm:"default(1)" json:"enabled"`
+	CreatedTime time.Time `orm:"auto_now_add;type(datetime)" json:"created_time"`
+}
+
+// Webhook发送记录。同一订阅下同一资源的事件按Sequence顺序发送，前一个
+// 未发送成功时后面的等待；重试超过events::maxattempts次为failed，
+// 不再阻塞后面的事件，可以重新发送
+type WebhookDeliveries struct {
+	Id           string                `orm:"pk;size(36)" json:"id"`
+	Subscription *WebhookSubscriptions `orm:"rel(fk);on_delete(cascade)" json:"-"`
+	EventId      string                `orm:"size(36);index" json:"event_id"`
+	Event        string                `orm:"size(64);index" json:"event"`
+	Resource     string                `orm:"size(128);index" json:"resource"`
+	Sequence     int64                 `orm:"index" json:"sequence"`
+	Payload      string                `orm:"type(text)" json:"payload"`
+	Status       string                `orm:"size(16);index" json:"status"`
+	Attempts     int                   `orm:"default(0)" json:"attempts"`
+	LastError    string                `orm:"size(512);null" json:"last_error"`
+	RedeliveryOf string                `orm:"size(36);null" json:"redelivery_of"`
+	NextTime     time.Time             `orm:"type(datetime);index" json:"next_time"`
+	CreatedTime  time.Time             `orm:"auto_now_add;type(datetime)" json:"created_time"`
+	SentTime     time.Time             `orm:"type(datetime);null" json:"sent_time"`
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix,
+			new(WebhookSubscriptions), new(WebhookDeliveries))
+	} else {
+		orm.RegisterModel(
+			new(WebhookSubscriptions), new(WebhookDeliveries))
+	}
+	SubscribeEvents(queueWebhooks)
+}
+
+// Wants tells if subscription gets event.
+func (a *WebhookSubscriptions) Wants(event string) bool {
+	if strings.TrimSpace(a.Events) == "" {
+		return true
+	}
+	for _, v := range strings.Split(a.Events, ",") {
+		if ok, _ := path.Match(strings.TrimSpace(v), event); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validWebhookSubscription(a *WebhookSubscriptions) error {
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return fmt.Errorf("Bad info: %s", errS)
+	}
+	u, err := url.Parse(a.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrorWebhookUrl
+	}
+	for _, v := range strings.Split(a.Events, ",") {
+		if _, err := path.Match(strings.TrimSpace(v), ""); err != nil {
+			return fmt.Errorf("Bad info: Events:%s", err)
+		}
+	}
+	return nil
+}
+
+func AddWebhookSubscription(a *WebhookSubscriptions) (string, error) {
+	beego.Debug("[M] Got data:", a.Name, a.Url)
+	a.Id = uuid.New()
+	err := validWebhookSubscription(a)
+	if err != nil {
+		return "", err
+	}
+	if a.Secret == "" {
+		return "", fmt.Errorf("Bad info: Secret:Can not be empty")
+	}
+	a.Secret, err = common.EncryptSecret(a.Secret)
+	if err != nil {
+		return "", err
+	}
+	_, err = orm.NewOrm().Insert(a)
+	if err != nil {
+		return "", err
+	}
+	return a.Id, nil
+}
+
+func DeleteWebhookSubscription(a *WebhookSubscriptions) error {
+	beego.Debug("[M] Got data:", a.Id)
+	_, err := orm.NewOrm().Delete(a)
+	return err
+}
+
+// UpdateWebhookSubscription keeps the secret if a has none.
+func UpdateWebhookSubscription(a *WebhookSubscriptions) error {
+	beego.Debug("[M] Got data:", a.Id)
+	err := validWebhookSubscription(a)
+	if err != nil {
+		return err
+	}
+	fields := []string{"Name", "Url", "Events", "Enabled"}
+	if a.Secret != "" {
+		a.Secret, err = common.EncryptSecret(a.Secret)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, "Secret")
+	}
+	_, err = orm.NewOrm().Update(a, fields...)
+	return err
+}
+
+// If get all, just use &WebhookSubscriptions{}
+func GetWebhookSubscriptions(cond *WebhookSubscriptions, limit, index int) ([]*WebhookSubscriptions, error) {
+	r := make([]*WebhookSubscriptions, 0)
+	q := orm.NewOrm().QueryTable("webhook_subscriptions")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Name != "" {
+		q = q.Filter("name", cond.Name)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.All(&r)
+	return r, err
+}
+
+// queueWebhooks queues e to every enabled subscription wanting it.
+// Errors are only logged, publishers never fail because of webhooks.
+func queueWebhooks(e *Event) {
+	err := queueWebhookDeliveries(e)
+	if err != nil {
+		beego.Warn("[M] Got error on queueing event", e.Type, err)
+	}
+}
+
+func queueWebhookDeliveries(e *Event) error {
+	subs := make([]*WebhookSubscriptions, 0)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("webhook_subscriptions").Filter("enabled", true).All(&subs)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, v := range subs {
+		if !v.Wants(e.Type) {
+			continue
+		}
+		_, err = o.Insert(&WebhookDeliveries{
+			Id:           uuid.New(),
+			Subscription: v,
+			EventId:      e.Id,
+			Event:        e.Type,
+			Resource:     e.Resource,
+			Sequence:     e.Sequence,
+			Payload:      string(b),
+			Status:       WebhookStatusPending,
+			NextTime:     e.Time,
+		})
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	beego.Debug("[M] Event", e.Type, "of", e.Resource, "queued to webhooks:", n)
+	return nil
+}
+
+// Fail counts a failed attempt, the delivery is failed for good after
+// maxAttempts. It is retried with the backoff of notifications.
+func (a *WebhookDeliveries) Fail(now time.Time, err error, maxAttempts int) {
+	var next time.Time
+	a.LastError, next = retryDelivery(now, err, a.Attempts, maxAttempts)
+	if next.IsZero() {
+		a.Status = WebhookStatusFailed
+		return
+	}
+	a.NextTime = next
+}
+
+func (a *WebhookDeliveries) attempt() (string, int) {
+	return a.Id, a.Attempts
+}
+
+func (a *WebhookDeliveries) done(now time.Time, err error, maxAttempts int) {
+	a.Attempts++
+	if err != nil {
+		beego.Warn("[M] Failed to send event", a.EventId, "to", a.Subscription.Name, err)
+		a.Fail(now, err, maxAttempts)
+		return
+	}
+	a.Status = WebhookStatusSent
+	a.SentTime = time.Now()
+}
+
+func (a *WebhookDeliveries) send() error {
+	secret, err := common.DecryptSecret(a.Subscription.Secret)
+	if err != nil {
+		return err
+	}
+	header := make(http.Header)
+	header.Set(notify.HeaderDelivery, a.Id)
+	return notify.PostSigned(a.Subscription.Url, secret, a.Event, []byte(a.Payload), header)
+}
+
+// queue is what deliveries are ordered in, a resource of a subscription.
+func (a *WebhookDeliveries) queue() string {
+	return a.Subscription.Id + "/" + a.Resource
+}
+
+// NextWebhookDeliveries picks from pending, which is ordered by
+// sequence, those to be sent at now: only the first one of each queue,
+// and only if it is due. Queues in seen are skipped, those met in
+// pending are added to it, so pending may come in pages.
+func NextWebhookDeliveries(pending []*WebhookDeliveries, seen map[string]bool, now time.Time) []*WebhookDeliveries {
+	r := make([]*WebhookDeliveries, 0)
+	for _, v := range pending {
+		if seen[v.queue()] {
+			continue
+		}
+		seen[v.queue()] = true
+		if v.Status == WebhookStatusPending && !v.NextTime.After(now) {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+// Pending deliveries of a subscription are read in pages of
+// webhookPageSize, up to webhookPages of them every round.
+const (
+	webhookPageSize = 200
+	webhookPages    = 10
+)
+
+// DeliverWebhooks sends due deliveries in order, and returns how many
+// are sent. A queue waits while its first delivery is being retried.
+// Every subscription is paged on its own, so one which is down only
+// holds back its own queues.
+func DeliverWebhooks(now time.Time) (int, error) {
+	subs := make([]*WebhookSubscriptions, 0)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("webhook_subscriptions").Filter("enabled", true).All(&subs)
+	if err != nil {
+		return 0, err
+	}
+	maxAttempts := beego.AppConfig.DefaultInt("events::maxattempts", 8)
+	n := 0
+	for _, sub := range subs {
+		// A sent delivery lets the next one of its queue go in the next
+		// round, so go on until nothing is sent.
+		for round := 0; round < 10; round++ {
+			next, err := nextWebhookDeliveries(o, sub, now)
+			if err != nil {
+				return n, err
+			}
+			sent := 0
+			for _, v := range next {
+				if attemptDelivery(o, "webhook_deliveries", v, now, maxAttempts) {
+					sent++
+				}
+			}
+			n += sent
+			if sent == 0 {
+				break
+			}
+		}
+	}
+	return n, nil
+}
+
+// nextWebhookDeliveries gets the due first deliveries of queues of sub,
+// those behind a long queue are found in later pages.
+func nextWebhookDeliveries(o orm.Ormer, sub *WebhookSubscriptions, now time.Time) ([]*WebhookDeliveries, error) {
+	r := make([]*WebhookDeliveries, 0)
+	seen := make(map[string]bool)
+	for page := 0; page < webhookPages; page++ {
+		pending := make([]*WebhookDeliveries, 0)
+		_, err := o.QueryTable("webhook_deliveries").
+			Filter("subscription_id", sub.Id).
+			Filter("status", WebhookStatusPending).
+			OrderBy("sequence", "created_time").
+			Limit(webhookPageSize).
+			Offset(page * webhookPageSize).
+			All(&pending)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range pending {
+			v.Subscription = sub
+		}
+		r = append(r, NextWebhookDeliveries(pending, seen, now)...)
+		if len(pending) < webhookPageSize {
+			break
+		}
+	}
+	return r, nil
+}
+
+// RedeliverWebhook queues a sent or failed delivery again as a new one,
+// it keeps the sequence so it is sent before later events of its queue.
+func RedeliverWebhook(a *WebhookDeliveries) (string, error) {
+	beego.Debug("[M] Got data:", a.Id)
+	if a.Status == WebhookStatusPending {
+		return "", ErrorWebhookPending
+	}
+	d := &WebhookDeliveries{
+		Id:           uuid.New(),
+		Subscription: a.Subscription,
+		EventId:      a.EventId,
+		Event:        a.Event,
+		Resource:     a.Resource,
+		Sequence:     a.Sequence,
+		Payload:      a.Payload,
+		Status:       WebhookStatusPending,
+		RedeliveryOf: a.Id,
+		NextTime:     time.Now(),
+	}
+	_, err := orm.NewOrm().Insert(d)
+	if err != nil {
+		return "", err
+	}
+	return d.Id, nil
+}
+
+// If get all, just use &WebhookDeliveries{}, newest first.
+func GetWebhookDeliveries(cond *WebhookDeliveries, limit, index int) ([]*WebhookDeliveries, error) {
+	r := make([]*WebhookDeliveries, 0)
+	q := orm.NewOrm().QueryTable("webhook_deliveries")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Subscription != nil {
+		q = q.Filter("subscription_id", cond.Subscription.Id)
+	}
+	if cond.Status != "" {
+		q = q.Filter("status", cond.Status)
+	}
+	if cond.Event != "" {
+		q = q.Filter("event", cond.Event)
+	}
+	if cond.Resource != "" {
+		q = q.Filter("resource", cond.Resource)
+	}
+	if cond.EventId != "" {
+		q = q.Filter("event_id", cond.EventId)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.OrderBy("-sequence", "-created_time").All(&r)
+	return r, err
+}
+
+// PurgeWebhookDeliveries deletes sent and failed deliveries created before.
+func PurgeWebhookDeliveries(before time.Time) (int64, error) {
+	return purgeDeliveries("webhook_deliveries", before)
+}
@@ -0,0 +1,103 @@
+// Package notify sends messages about what happens in the server, like
+// failed policies or missed backups, to people and other systems.
+//
+// Each kind of channel is a sender registered by name, like adapters of
+// package storage: "smtp" mails, "webhook" posts the message as JSON
+// signed with HMAC-SHA256, "slack" and "dingtalk" post to incoming
+// webhooks of those chats.
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+var (
+	ErrorSenderNotFound = errors.New("Notification channel type not found")
+	ErrorNoTarget       = errors.New("Target of channel is required")
+	ErrorNoRecipients   = errors.New("Recipients of channel are required")
+)
+
+// Message is what is sent, Event is like "incident.opened".
+type Message struct {
+	Id       string            `json:"id"`
+	Event    string            `json:"event"`
+	Severity string            `json:"severity"`
+	Title    string            `json:"title"`
+	Text     string            `json:"text"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Time     time.Time         `json:"time"`
+}
+
+// String is the message as plain text, for mails and chats.
+func (m *Message) String() string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "[%s] %s\n%s\n", strings.ToUpper(m.Severity), m.Title, m.Text)
+	for k, v := range m.Fields {
+		fmt.Fprintf(b, "%s: %s\n", k, v)
+	}
+	fmt.Fprintf(b, "Event: %s, Time: %s", m.Event, m.Time.Format(time.RFC3339))
+	return b.String()
+}
+
+// Config of a channel, it is usually made from models.NotificationChannels.
+type Config struct {
+	Type string
+	// URL of webhooks, or host:port of smtp server.
+	Target string
+	// Mail addresses, only used by smtp.
+	From       string
+	Recipients []string
+	Username   string
+	// Password of smtp, or key to sign webhooks with.
+	Secret string
+}
+
+type Sender interface {
+	Send(m *Message) error
+}
+
+type Adapter func(conf Config) (Sender, error)
+
+var adapters = make(map[string]Adapter)
+
+// Register makes a sender available by name.
+// It panics if called twice with the same name, like storage.RegisterHot.
+func Register(name string, adapter Adapter) {
+	if adapter == nil {
+		panic("notify: Register adapter is nil")
+	}
+	if _, ok := adapters[name]; ok {
+		panic("notify: Register called twice for adapter " + name)
+	}
+	adapters[name] = adapter
+}
+
+// Supports tells if there is a sender of typ.
+func Supports(typ string) bool {
+	_, ok := adapters[typ]
+	return ok
+}
+
+func NewSender(conf Config) (Sender, error) {
+	adapter, ok := adapters[conf.Type]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", ErrorSenderNotFound, conf.Type)
+	}
+	if conf.Target == "" {
+		return nil, ErrorNoTarget
+	}
+	return adapter(conf)
+}
+
+// HTTPClient is used by webhooks, replaced in tests.
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
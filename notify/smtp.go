@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("smtp", NewSMTPSender)
+}
+
+// SMTPSender mails messages through Target, which is host:port. It
+// authenticates with PLAIN if there is a username, which net/smtp only
+// does over TLS or to localhost.
+type SMTPSender struct {
+	conf Config
+}
+
+func NewSMTPSender(conf Config) (Sender, error) {
+	if len(conf.Recipients) == 0 {
+		return nil, ErrorNoRecipients
+	}
+	if conf.From == "" {
+		conf.From = "moduleab@localhost"
+	}
+	return &SMTPSender{conf: conf}, nil
+}
+
+func (s *SMTPSender) mail(m *Message) []byte {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "From: %s\r\n", s.conf.From)
+	fmt.Fprintf(b, "To: %s\r\n", strings.Join(s.conf.Recipients, ", "))
+	fmt.Fprintf(b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8",
+		fmt.Sprintf("[ModuleAB][%s] %s", m.Severity, m.Title)))
+	fmt.Fprintf(b, "Date: %s\r\n", m.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.Replace(m.String(), "\n", "\r\n", -1))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+func (s *SMTPSender) Send(m *Message) error {
+	var auth smtp.Auth
+	if s.conf.Username != "" {
+		host, _, err := net.SplitHostPort(s.conf.Target)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.conf.Username, s.conf.Secret, host)
+	}
+	return smtp.SendMail(s.conf.Target, auth, s.conf.From, s.conf.Recipients, s.mail(m))
+}
@@ -0,0 +1,165 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Headers of generic webhooks. Signature is "sha256=<hex>" of
+// HMAC-SHA256 of "<timestamp>.<body>" with the secret of channel.
+const (
+	HeaderSignature = "X-ModuleAB-Signature"
+	HeaderTimestamp = "X-ModuleAB-Timestamp"
+	HeaderEvent     = "X-ModuleAB-Event"
+	HeaderDelivery  = "X-ModuleAB-Delivery"
+)
+
+func init() {
+	Register("webhook", NewWebhookSender)
+	Register("slack", NewSlackSender)
+	Register("dingtalk", NewDingTalkSender)
+}
+
+// Sign is the signature of body sent at timestamp, see HeaderSignature.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// post sends body to u, any status but 2xx is an error.
+func post(u string, body []byte, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return b, fmt.Errorf("%s: %s", resp.Status, b)
+	}
+	return b, nil
+}
+
+// PostSigned posts body of event to u with the headers of generic
+// webhooks, it is signed if there is a secret.
+func PostSigned(u, secret, event string, body []byte, header http.Header) error {
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set(HeaderEvent, event)
+	if secret != "" {
+		now := time.Now().Unix()
+		header.Set(HeaderTimestamp, strconv.FormatInt(now, 10))
+		header.Set(HeaderSignature, Sign(secret, now, body))
+	}
+	_, err := post(u, body, header)
+	return err
+}
+
+type WebhookSender struct {
+	conf Config
+}
+
+func NewWebhookSender(conf Config) (Sender, error) {
+	return &WebhookSender{conf: conf}, nil
+}
+
+func (w *WebhookSender) Send(m *Message) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return PostSigned(w.conf.Target, w.conf.Secret, m.Event, body, nil)
+}
+
+// SlackSender posts to a Slack incoming webhook, or anything
+// taking {"text": "..."} like Mattermost.
+type SlackSender struct {
+	conf Config
+}
+
+func NewSlackSender(conf Config) (Sender, error) {
+	return &SlackSender{conf: conf}, nil
+}
+
+func (s *SlackSender) Send(m *Message) error {
+	body, err := json.Marshal(map[string]string{"text": m.String()})
+	if err != nil {
+		return err
+	}
+	_, err = post(s.conf.Target, body, nil)
+	return err
+}
+
+// DingTalkSender posts to a DingTalk robot, signed if it has a secret.
+type DingTalkSender struct {
+	conf Config
+}
+
+func NewDingTalkSender(conf Config) (Sender, error) {
+	return &DingTalkSender{conf: conf}, nil
+}
+
+// DingTalkSign is the sign of a robot with secret at timestamp in ms.
+func DingTalkSign(secret string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d\n%s", timestamp, secret)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (d *DingTalkSender) Send(m *Message) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"msgtype": "text",
+		"text":    map[string]string{"content": m.String()},
+	})
+	if err != nil {
+		return err
+	}
+	u := d.conf.Target
+	if d.conf.Secret != "" {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		q := parsed.Query()
+		q.Set("timestamp", strconv.FormatInt(now, 10))
+		q.Set("sign", DingTalkSign(d.conf.Secret, now))
+		parsed.RawQuery = q.Encode()
+		u = parsed.String()
+	}
+	b, err := post(u, body, nil)
+	if err != nil {
+		return err
+	}
+	// DingTalk answers 200 even if it fails.
+	var r struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if json.Unmarshal(b, &r) == nil && r.ErrCode != 0 {
+		return fmt.Errorf("DingTalk error %d: %s", r.ErrCode, r.ErrMsg)
+	}
+	return nil
+}
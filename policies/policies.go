@@ -1,8 +1,9 @@
 package policies
 
 import (
-	"moduleab_server/common"
 	"moduleab_server/models"
+	"moduleab_server/notify"
+	"moduleab_server/storage"
 	"os"
 	"time"
 
@@ -92,22 +93,14 @@ func RunPolicies() {
 
 					baseLine := records[0]
 					for _, r := range records {
-						oas, err := common.NewOasClient(r.BackupSet.Oas.Endpoint)
+						cold, err := r.BackupSet.GetColdStorage()
 						if err != nil {
-							beego.Warn("Cannot connect to OAS Service:", err)
+							beego.Warn("Cannot connect to cold storage:", err)
 							continue
 						}
-						oss, err := common.NewOssClient(r.BackupSet.Oss.Endpoint)
+						hot, err := r.BackupSet.GetHotStorage()
 						if err != nil {
-							beego.Warn("Cannot connect to OSS Service:", err)
-							continue
-						}
-						bucket, err := oss.Bucket(r.BackupSet.Oss.BucketName)
-						if err != nil {
-							beego.Warn(
-								"Cannot get bucket:", r.BackupSet.Oss.BucketName,
-								"error:", err,
-							)
+							beego.Warn("Cannot connect to hot storage:", err)
 							continue
 						}
 
@@ -128,34 +121,25 @@ func RunPolicies() {
 									beego.Debug("New baseline is:", r.Id)
 									baseLine = r
 
-									var reqId, jobId string
-									beego.Debug(
-										"ArchiveToOas:",
-										r.BackupSet.Oas.VaultId,
-										common.ConvertOssAddrToInternal(
-											r.BackupSet.Oss.Endpoint,
-										),
-										r.BackupSet.Oss.BucketName,
-										r.GetFullPath(),
-									)
-									reqId, jobId, err = oas.ArchiveToOas(
-										r.BackupSet.Oas.VaultId,
-										common.ConvertOssAddrToInternal(
-											r.BackupSet.Oss.Endpoint,
-										),
-										r.BackupSet.Oss.BucketName,
-										r.GetFullPath(),
+									hotConf, err := r.BackupSet.Oss.StorageConfig()
+									if err != nil {
+										beego.Warn("Bad hot storage config:", err)
+										continue
+									}
+									job, err := cold.Archive(
+										hotConf,
 										r.GetFullPath(),
 									)
 									if err != nil {
 										beego.Warn("Cannot make job to archive:", err)
+										notifyPolicyFailed(p, r, "archive", err)
 										continue
 									}
 									_, err = models.AddOasJobs(
 										&models.OasJobs{
 											Vault:     r.BackupSet.Oas,
-											RequestId: reqId,
-											JobId:     jobId,
+											RequestId: job.RequestId,
+											JobId:     job.Id,
 											JobType:   models.OasJobTypePullFromOSS,
 											Status:    models.OasJobStatusIncomplete,
 											Records:   r,
@@ -163,7 +147,13 @@ func RunPolicies() {
 									)
 									if err != nil {
 										beego.Warn("Cannot make oas job:", err)
+										continue
 									}
+									models.PublishRecordEvent(models.EventOasJobCreated, r, map[string]string{
+										"job":      job.Id,
+										"job_type": "archive",
+										"policy":   p.Id,
+									})
 								}
 
 							case models.RecordTypeArchive:
@@ -182,12 +172,13 @@ func RunPolicies() {
 									baseLine = r
 									continue
 								}
-								err = bucket.DeleteObject(r.GetFullPath())
+								err = hot.DeleteObject(r.GetFullPath())
 								if err != nil {
 									beego.Warn(
 										"Cannot delete backup:", r.GetFullPath(),
 										"error:", err,
 									)
+									notifyPolicyFailed(p, r, "delete backup", err)
 									continue
 								}
 
@@ -200,7 +191,9 @@ func RunPolicies() {
 											"Cannot delete record:", r.Id,
 											"error:", err,
 										)
+										continue
 									}
+									publishRecordDeleted(p, r)
 								} else {
 									r.Type = models.RecordTypeArchive
 									err = models.UpdateRecord(r)
@@ -224,12 +217,10 @@ func RunPolicies() {
 								}
 
 								beego.Debug("Will delete archive:", r.Id)
-								_, err = oas.DeleteArchive(
-									r.BackupSet.Oas.VaultId,
-									r.ArchiveId,
-								)
+								err = cold.DeleteArchive(r.ArchiveId)
 								if err != nil {
 									beego.Warn("Cannot make job to delete archive:", err)
+									notifyPolicyFailed(p, r, "delete archive", err)
 									continue
 								}
 
@@ -239,7 +230,9 @@ func RunPolicies() {
 										"Cannot delete record:", r.Id,
 										"error:", err,
 									)
+									continue
 								}
+								publishRecordDeleted(p, r)
 							}
 						}
 					}
@@ -250,6 +243,21 @@ func RunPolicies() {
 	}
 }
 
+func notifyPolicyFailed(p *models.Policies, r *models.Records, action string, err error) {
+	models.Notify(models.EventPolicyFailed, notify.SeverityWarning,
+		"Policy failed to "+action, err.Error(), map[string]string{
+			"policy": p.Id,
+			"record": r.Id,
+			"path":   r.GetFullPath(),
+		})
+}
+
+func publishRecordDeleted(p *models.Policies, r *models.Records) {
+	models.PublishRecordEvent(models.EventRecordDeleted, r, map[string]string{
+		"policy": p.Id,
+	})
+}
+
 func InitDb() {
 	o := orm.NewOrm()
 
@@ -282,11 +290,17 @@ func InitDb() {
 	}
 	o.Commit()
 
+	err = models.InitPermissions()
+	if err != nil {
+		beego.Alert("Error on inserting permissions:", err)
+		os.Exit(1)
+	}
+
 	user := &models.Users{
-		Id:       uuid.New(),
-		Name:     "admin",
-		ShowName: "Administrator",
-		Password: "admin",
+		Id:          uuid.New(),
+		Name:        "admin",
+		ShowName:    "Administrator",
+		NewPassword: "admin",
 		Roles: []*models.Roles{
 			&role[0],
 		},
@@ -341,9 +355,14 @@ func CheckOasJob() {
 			}
 			for _, v := range oas {
 				beego.Debug("Got oas:", v)
-				o, err := common.NewOasClient(v.Endpoint)
+				conf, err := v.StorageConfig()
 				if err != nil {
-					beego.Warn("Got error on connecting to OAS:", err)
+					beego.Warn("Bad cold storage config:", err)
+					continue
+				}
+				cold, err := storage.NewColdStorage(conf)
+				if err != nil {
+					beego.Warn("Got error on connecting to cold storage:", err)
 					continue
 				}
 
@@ -358,17 +377,31 @@ func CheckOasJob() {
 
 				for _, job := range jobs {
 					beego.Debug("Got job:", job)
-					_, jl, err := o.GetJobInfo(
-						job.Vault.VaultId,
-						job.JobId,
-					)
+					jl, err := cold.GetJob(job.JobId)
 					if err != nil {
 						beego.Warn("Got error on retrieving job info:", err)
 						continue
 					}
 					if jl.Completed && !job.Status {
-						if jl.StatusCode == "Failed" {
-							beego.Warn("Oas job failed:", jl.StatusMessage)
+						if jl.Failed {
+							beego.Warn("Oas job failed:", jl.Message)
+							// Done with it, so it is told only once.
+							job.Status = jl.Completed
+							err = models.UpdateOasJobs(job)
+							if err != nil {
+								beego.Warn("Got error on update oas jobs:", err)
+								continue
+							}
+							models.Notify(models.EventOasJobFailed, notify.SeverityCritical,
+								"Archive job failed", jl.Message, map[string]string{
+									"job":    job.JobId,
+									"record": job.Records.Id,
+									"vault":  v.VaultName,
+								})
+							models.PublishRecordEvent(models.EventOasJobFailed, job.Records, map[string]string{
+								"job":     job.JobId,
+								"message": jl.Message,
+							})
 							continue
 						}
 						job.Status = jl.Completed
@@ -378,6 +411,9 @@ func CheckOasJob() {
 							continue
 						}
 						record := job.Records
+						models.PublishRecordEvent(models.EventOasJobCompleted, record, map[string]string{
+							"job": job.JobId,
+						})
 						switch job.JobType {
 						case models.OasJobTypePushToOSS:
 							beego.Debug("Job type: Push to OSS")
@@ -386,8 +422,7 @@ func CheckOasJob() {
 
 							signal := models.MakeDownloadSignal(
 								job.Records.GetFullPath(),
-								job.Records.BackupSet.Oss.Endpoint,
-								job.Records.BackupSet.Oss.BucketName,
+								job.Records.BackupSet.Oss,
 							)
 							id, err := models.AddSignal(
 								job.Records.Host.Id, signal)
@@ -406,7 +441,12 @@ func CheckOasJob() {
 									"Cannot update record:", record.Id,
 									"error:", err,
 								)
+								continue
 							}
+							models.PublishRecordEvent(models.EventRecordRecovered, record, map[string]string{
+								"job":    job.JobId,
+								"signal": id,
+							})
 
 						case models.OasJobTypePullFromOSS:
 							beego.Debug("Job type: Pull from OSS")
@@ -420,7 +460,11 @@ func CheckOasJob() {
 									"Cannot update record:", record.Id,
 									"error:", err,
 								)
+								continue
 							}
+							models.PublishRecordEvent(models.EventRecordArchived, record, map[string]string{
+								"job": job.JobId,
+							})
 						}
 
 					} else if job.Status {
@@ -436,3 +480,150 @@ func CheckOasJob() {
 		}
 	}
 }
+
+// PurgeSignals deletes acked and dead signals older than
+// signal::retention days, once an hour.
+func PurgeSignals() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	beego.Debug("PurgeSignals() running...")
+	defer beego.Debug("PurgeSignals() STOPPED!")
+	for {
+		select {
+		case <-ticker.C:
+			retention := beego.AppConfig.DefaultInt64("signal::retention", 7)
+			n, err := models.PurgeSignals(
+				time.Now().Add(-time.Duration(retention*24) * time.Hour))
+			if err != nil {
+				beego.Warn("Got error on purging signals:", err)
+				continue
+			}
+			beego.Info("Purged signals:", n)
+		}
+	}
+}
+
+// ScheduleClientJobs dispatches backups of client jobs every
+// scheduler::tick seconds, which should be less than their periods.
+func ScheduleClientJobs() {
+	tick := beego.AppConfig.DefaultInt64("scheduler::tick", 10)
+	ticker := time.NewTicker(time.Duration(tick) * time.Second)
+	defer ticker.Stop()
+	beego.Debug("ScheduleClientJobs() running...")
+	defer beego.Debug("ScheduleClientJobs() STOPPED!")
+	for {
+		select {
+		case now := <-ticker.C:
+			n, err := models.ScheduleClientJobs(now)
+			if err != nil {
+				beego.Warn("Got error on scheduling client jobs:", err)
+				continue
+			}
+			if n > 0 {
+				beego.Info("Dispatched backups:", n)
+			}
+		}
+	}
+}
+
+// CheckMissedBackups opens and resolves incidents of missed backups
+// every sla::checkperiod minutes.
+func CheckMissedBackups() {
+	period := beego.AppConfig.DefaultInt64("sla::checkperiod", 5)
+	ticker := time.NewTicker(time.Duration(period) * time.Minute)
+	defer ticker.Stop()
+	beego.Debug("CheckMissedBackups() running...")
+	defer beego.Debug("CheckMissedBackups() STOPPED!")
+	for {
+		select {
+		case now := <-ticker.C:
+			opened, resolved, err := models.CheckMissedBackups(now)
+			if err != nil {
+				beego.Warn("Got error on checking missed backups:", err)
+				continue
+			}
+			if opened > 0 || resolved > 0 {
+				beego.Info("Missed backup incidents opened:", opened, "resolved:", resolved)
+			}
+		}
+	}
+}
+
+// ExpireBackupRuns fails backup runs not reported for longer than
+// signal::visibilitytimeout, once a minute.
+func ExpireBackupRuns() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	beego.Debug("ExpireBackupRuns() running...")
+	defer beego.Debug("ExpireBackupRuns() STOPPED!")
+	for {
+		select {
+		case now := <-ticker.C:
+			n, err := models.ExpireBackupRuns(now)
+			if err != nil {
+				beego.Warn("Got error on expiring backup runs:", err)
+				continue
+			}
+			if n > 0 {
+				beego.Info("Expired backup runs:", n)
+			}
+		}
+	}
+}
+
+// DeliverNotifications sends notifications every notify::period seconds,
+// and deletes those sent or failed more than notify::retention days ago.
+func DeliverNotifications() {
+	beego.Debug("DeliverNotifications() running...")
+	defer beego.Debug("DeliverNotifications() STOPPED!")
+	deliverLoop("notify", 10, "notifications",
+		models.DeliverNotifications, models.PurgeNotificationDeliveries)
+}
+
+// DeliverWebhooks sends events to webhook subscriptions every
+// events::period seconds, and deletes deliveries sent or failed, and
+// numbers of events, more than events::retention days ago.
+func DeliverWebhooks() {
+	beego.Debug("DeliverWebhooks() running...")
+	defer beego.Debug("DeliverWebhooks() STOPPED!")
+	deliverLoop("events", 5, "webhooks", models.DeliverWebhooks,
+		func(before time.Time) (int64, error) {
+			n, err := models.PurgeWebhookDeliveries(before)
+			if err != nil {
+				return n, err
+			}
+			_, err = models.PurgeEventSequences(before)
+			return n, err
+		})
+}
+
+// deliverLoop calls deliver every period seconds of config section, and
+// purge once an hour with the time retention days of section ago.
+func deliverLoop(section string, period int64, name string,
+	deliver func(now time.Time) (int, error),
+	purge func(before time.Time) (int64, error)) {
+	period = beego.AppConfig.DefaultInt64(section+"::period", period)
+	ticker := time.NewTicker(time.Duration(period) * time.Second)
+	defer ticker.Stop()
+	lastPurge := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			n, err := deliver(now)
+			if err != nil {
+				beego.Warn("Got error on delivering "+name+":", err)
+			} else if n > 0 {
+				beego.Debug("Sent "+name+":", n)
+			}
+			if now.Sub(lastPurge) < time.Hour {
+				continue
+			}
+			lastPurge = now
+			retention := beego.AppConfig.DefaultInt64(section+"::retention", 30)
+			_, err = purge(now.Add(-time.Duration(retention*24) * time.Hour))
+			if err != nil {
+				beego.Warn("Got error on purging "+name+":", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+)
+
+// Codec turns envelopes into websocket frames and back.
+type Codec interface {
+	Name() string
+	// MessageType is websocket.TextMessage or websocket.BinaryMessage.
+	MessageType() int
+	Encode(e *Envelope) ([]byte, error)
+	// Decode returns a validated envelope.
+	Decode(b []byte) (*Envelope, error)
+}
+
+var codecs = map[string]Codec{
+	EncodingJSON:     JSONCodec{},
+	EncodingProtobuf: ProtobufCodec{},
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string     { return EncodingJSON }
+func (JSONCodec) MessageType() int { return websocket.TextMessage }
+
+func (JSONCodec) Encode(e *Envelope) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func (JSONCodec) Decode(b []byte) (*Envelope, error) {
+	e := new(Envelope)
+	err := json.Unmarshal(b, e)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrorBadFrame, err)
+	}
+	return e, e.Validate()
+}
+
+// ProtobufCodec encodes message Envelope of envelope.proto,
+// body is kept as JSON in it.
+type ProtobufCodec struct{}
+
+const (
+	fieldVersion protowire.Number = iota + 1
+	fieldType
+	fieldId
+	fieldRef
+	fieldTime
+	fieldBody
+)
+
+func (ProtobufCodec) Name() string     { return EncodingProtobuf }
+func (ProtobufCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (ProtobufCodec) Encode(e *Envelope) ([]byte, error) {
+	b := make([]byte, 0, 64+len(e.Body))
+	b = protowire.AppendTag(b, fieldVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(e.Version))
+	b = protowire.AppendTag(b, fieldType, protowire.BytesType)
+	b = protowire.AppendString(b, e.Type)
+	if e.Id != "" {
+		b = protowire.AppendTag(b, fieldId, protowire.BytesType)
+		b = protowire.AppendString(b, e.Id)
+	}
+	if e.Ref != "" {
+		b = protowire.AppendTag(b, fieldRef, protowire.BytesType)
+		b = protowire.AppendString(b, e.Ref)
+	}
+	if e.Time != 0 {
+		b = protowire.AppendTag(b, fieldTime, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(e.Time))
+	}
+	if len(e.Body) > 0 {
+		b = protowire.AppendTag(b, fieldBody, protowire.BytesType)
+		b = protowire.AppendBytes(b, e.Body)
+	}
+	return b, nil
+}
+
+func (ProtobufCodec) Decode(b []byte) (*Envelope, error) {
+	e := new(Envelope)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, badProtobuf(n)
+		}
+		b = b[n:]
+		switch {
+		case (num == fieldVersion || num == fieldTime) && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			if num == fieldVersion {
+				e.Version = int(v)
+			} else {
+				e.Time = int64(v)
+			}
+		case num >= fieldType && num <= fieldBody && num != fieldTime &&
+			typ == protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			switch num {
+			case fieldType:
+				e.Type = string(v)
+			case fieldId:
+				e.Id = string(v)
+			case fieldRef:
+				e.Ref = string(v)
+			case fieldBody:
+				e.Body = append(json.RawMessage(nil), v...)
+			}
+		default:
+			// Unknown fields are skipped, as protobuf does.
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, badProtobuf(n)
+		}
+		b = b[n:]
+	}
+	return e, e.Validate()
+}
+
+func badProtobuf(n int) error {
+	return fmt.Errorf("%s: %s", ErrorBadFrame, protowire.ParseError(n))
+}
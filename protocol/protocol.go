@@ -0,0 +1,222 @@
+// Package protocol is what agents and server say to each other over the
+// websocket of /api/v1/client/signal/:name/ws.
+//
+// Every frame is an Envelope. Version and encoding are negotiated with the
+// websocket subprotocol, e.g. "moduleab.v1.json" or "moduleab.v1.protobuf",
+// then the agent says hello and the server answers welcome. After that the
+// server sends signals, and the agent answers each with progress and at
+// last a result. Both sides may send heartbeats, and a malformed frame is
+// answered with an error frame instead of being dropped.
+//
+// The schema is in envelope.schema.json and envelope.proto.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is the newest version of the protocol.
+const Version = 1
+
+// SupportedVersions are versions the server speaks, newest first.
+var SupportedVersions = []int{1}
+
+const (
+	TypeHello     = "hello"
+	TypeWelcome   = "welcome"
+	TypeSignal    = "signal"
+	TypeProgress  = "progress"
+	TypeResult    = "result"
+	TypeError     = "error"
+	TypeHeartbeat = "heartbeat"
+)
+
+// Codes of error frames.
+const (
+	CodeBadFrame           = "bad_frame"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeUnknownType        = "unknown_type"
+	CodeUnexpected         = "unexpected"
+	CodeInternal           = "internal"
+)
+
+var (
+	ErrorBadFrame           = errors.New("Malformed frame")
+	ErrorUnsupportedVersion = errors.New("Unsupported protocol version")
+	ErrorUnknownType        = errors.New("Unknown frame type")
+	ErrorNoRef              = errors.New("Ref is required")
+)
+
+// Envelope is a frame. Ref is id of the signal a frame is about,
+// Body is one of the bodies below according to Type.
+type Envelope struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	Id      string          `json:"id,omitempty"`
+	Ref     string          `json:"ref,omitempty"`
+	Time    int64           `json:"time,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+}
+
+// Hello is the first frame of agent.
+type Hello struct {
+	Agent        string   `json:"agent"`
+	Os           string   `json:"os,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// Welcome answers hello, Heartbeat is in seconds.
+type Welcome struct {
+	Version   int    `json:"version"`
+	Encoding  string `json:"encoding"`
+	Server    string `json:"server"`
+	Heartbeat int64  `json:"heartbeat"`
+}
+
+// Signal body is the signal itself, see models.Signal.
+type Signal map[string]interface{}
+
+// Progress of a signal, Bytes and Files are done so far by backups.
+type Progress struct {
+	Percent int    `json:"percent"`
+	Message string `json:"message,omitempty"`
+	Bytes   int64  `json:"bytes,omitempty"`
+	Files   int    `json:"files,omitempty"`
+}
+
+// Result ends a signal, it is acked if Ok, or delivered again.
+// Record is id of the record a backup has made.
+type Result struct {
+	Ok      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+	Bytes   int64  `json:"bytes,omitempty"`
+	Files   int    `json:"files,omitempty"`
+	Record  string `json:"record,omitempty"`
+}
+
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Heartbeat struct{}
+
+// bodies makes an empty body of every type, to check them.
+var bodies = map[string]func() interface{}{
+	TypeHello:     func() interface{} { return new(Hello) },
+	TypeWelcome:   func() interface{} { return new(Welcome) },
+	TypeSignal:    func() interface{} { return new(Signal) },
+	TypeProgress:  func() interface{} { return new(Progress) },
+	TypeResult:    func() interface{} { return new(Result) },
+	TypeError:     func() interface{} { return new(Error) },
+	TypeHeartbeat: func() interface{} { return new(Heartbeat) },
+}
+
+// needRef are types about a signal.
+var needRef = map[string]bool{
+	TypeSignal:   true,
+	TypeProgress: true,
+	TypeResult:   true,
+}
+
+// NewEnvelope makes a frame of the newest version with body.
+func NewEnvelope(typ, ref string, body interface{}) (*Envelope, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{Version: Version, Type: typ, Ref: ref, Body: b}, nil
+}
+
+// NewError makes an error frame, ref is id of the frame it is about.
+func NewError(code, message, ref string) *Envelope {
+	e, _ := NewEnvelope(TypeError, ref, &Error{Code: code, Message: message})
+	return e
+}
+
+// Validate checks version, type and ref of e, and that body is of its type.
+func (e *Envelope) Validate() error {
+	if !Supports(e.Version) {
+		return ErrorUnsupportedVersion
+	}
+	f, ok := bodies[e.Type]
+	if !ok {
+		return ErrorUnknownType
+	}
+	if needRef[e.Type] && e.Ref == "" {
+		return ErrorNoRef
+	}
+	if len(e.Body) == 0 {
+		return nil
+	}
+	err := json.Unmarshal(e.Body, f())
+	if err != nil {
+		return fmt.Errorf("%s: %s", ErrorBadFrame, err)
+	}
+	return nil
+}
+
+// DecodeBody gets body of e into v, which must be of its type.
+func (e *Envelope) DecodeBody(v interface{}) error {
+	if len(e.Body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(e.Body, v)
+}
+
+// ErrorCode is code of error frame for err of Decode or Validate.
+func ErrorCode(err error) string {
+	switch err {
+	case ErrorUnsupportedVersion:
+		return CodeUnsupportedVersion
+	case ErrorUnknownType:
+		return CodeUnknownType
+	}
+	return CodeBadFrame
+}
+
+func Supports(version int) bool {
+	for _, v := range SupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// Subprotocol is name of websocket subprotocol for version and encoding.
+func Subprotocol(version int, encoding string) string {
+	return fmt.Sprintf("moduleab.v%d.%s", version, encoding)
+}
+
+// Subprotocols are all the server speaks, in the order it prefers.
+func Subprotocols() []string {
+	r := make([]string, 0)
+	for _, v := range SupportedVersions {
+		for _, c := range []string{EncodingProtobuf, EncodingJSON} {
+			r = append(r, Subprotocol(v, c))
+		}
+	}
+	return r
+}
+
+// ParseSubprotocol is the other way of Subprotocol.
+func ParseSubprotocol(name string) (int, Codec, error) {
+	s := strings.Split(name, ".")
+	if len(s) != 3 || s[0] != "moduleab" || !strings.HasPrefix(s[1], "v") {
+		return 0, nil, ErrorUnsupportedVersion
+	}
+	version, err := strconv.Atoi(s[1][1:])
+	if err != nil || !Supports(version) {
+		return 0, nil, ErrorUnsupportedVersion
+	}
+	c, ok := codecs[s[2]]
+	if !ok {
+		return 0, nil, ErrorUnsupportedVersion
+	}
+	return version, c, nil
+}
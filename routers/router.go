@@ -28,6 +28,7 @@ func StaticFileServer(ctx *context.Context) {
 func init() {
 	beego.InsertFilter("/", beego.BeforeRouter, StaticFileServer)
 	beego.InsertFilter("/*", beego.BeforeRouter, StaticFileServer)
+	beego.InsertFilter("/api/v1/*", beego.BeforeExec, controllers.AuthFilter)
 	beego.ErrorController(&controllers.ErrorController{})
 	ns := beego.NewNamespace("/api/v1",
 		beego.NSNamespace("/hosts",
@@ -65,6 +66,11 @@ func init() {
 				&controllers.OasController{},
 			),
 		),
+		beego.NSNamespace("/credentials",
+			beego.NSInclude(
+				&controllers.CredentialsController{},
+			),
+		),
 		beego.NSNamespace("/oasJobs",
 			beego.NSInclude(
 				&controllers.OasJobsController{},
@@ -95,11 +101,61 @@ func init() {
 				&controllers.LoginController{},
 			),
 		),
+		beego.NSNamespace("/authEvents",
+			beego.NSInclude(
+				&controllers.AuthEventsController{},
+			),
+		),
 		beego.NSNamespace("/roles",
 			beego.NSInclude(
 				&controllers.RolesController{},
 			),
 		),
+		beego.NSNamespace("/accessTokens",
+			beego.NSInclude(
+				&controllers.AccessTokensController{},
+			),
+		),
+		beego.NSNamespace("/scopes",
+			beego.NSInclude(
+				&controllers.ScopesController{},
+			),
+		),
+		beego.NSNamespace("/enrollmentTokens",
+			beego.NSInclude(
+				&controllers.EnrollmentTokensController{},
+			),
+		),
+		beego.NSNamespace("/enroll",
+			beego.NSInclude(
+				&controllers.EnrollController{},
+			),
+		),
+		beego.NSNamespace("/dispatches",
+			beego.NSInclude(
+				&controllers.DispatchesController{},
+			),
+		),
+		beego.NSNamespace("/backupRuns",
+			beego.NSInclude(
+				&controllers.BackupRunsController{},
+			),
+		),
+		beego.NSNamespace("/incidents",
+			beego.NSInclude(
+				&controllers.IncidentsController{},
+			),
+		),
+		beego.NSNamespace("/notificationChannels",
+			beego.NSInclude(
+				&controllers.NotificationChannelsController{},
+			),
+		),
+		beego.NSNamespace("/webhookSubscriptions",
+			beego.NSInclude(
+				&controllers.WebhookSubscriptionsController{},
+			),
+		),
 		beego.NSNamespace("/version",
 			beego.NSInclude(
 				&controllers.VersionController{},
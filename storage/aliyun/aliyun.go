@@ -0,0 +1,149 @@
+// Package aliyun is the storage adapter for Aliyun OSS (hot)
+// and OAS (cold).
+package aliyun
+
+import (
+	"io"
+	"moduleab_server/common"
+	"moduleab_server/storage"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/astaxie/beego"
+)
+
+const Name = "aliyun"
+
+func init() {
+	storage.RegisterHot(Name, NewHotStorage)
+	storage.RegisterCold(Name, NewColdStorage)
+}
+
+type HotStorage struct {
+	conf   storage.HotConfig
+	bucket *oss.Bucket
+}
+
+func NewHotStorage(conf storage.HotConfig) (storage.HotStorage, error) {
+	client, err := common.NewOssClient(conf.Endpoint, conf.AccessKey, conf.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := client.Bucket(conf.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &HotStorage{conf: conf, bucket: bucket}, nil
+}
+
+func (h *HotStorage) PresignURL(method, key string, expires time.Duration) (string, error) {
+	return h.bucket.SignURL(key, oss.HTTPMethod(method), int64(expires/time.Second))
+}
+
+func (h *HotStorage) PutObject(key string, r io.Reader) error {
+	return h.bucket.PutObject(key, r)
+}
+
+func (h *HotStorage) GetObject(key string) (io.ReadCloser, error) {
+	return h.bucket.GetObject(key)
+}
+
+func (h *HotStorage) DeleteObject(key string) error {
+	return h.bucket.DeleteObject(key)
+}
+
+func (h *HotStorage) ListObjects(prefix string) ([]storage.Object, error) {
+	r := make([]storage.Object, 0)
+	marker := ""
+	for {
+		l, err := h.bucket.ListObjects(oss.Prefix(prefix), oss.Marker(marker))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range l.Objects {
+			r = append(r, storage.Object{
+				Key:          v.Key,
+				Size:         v.Size,
+				LastModified: v.LastModified,
+			})
+		}
+		if !l.IsTruncated {
+			return r, nil
+		}
+		marker = l.NextMarker
+	}
+}
+
+type ColdStorage struct {
+	client  *common.OasClient
+	vaultId string
+}
+
+func NewColdStorage(conf storage.ColdConfig) (storage.ColdStorage, error) {
+	client, err := common.NewOasClient(conf.Endpoint, conf.AccessKey, conf.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ColdStorage{client: client, vaultId: conf.VaultId}, nil
+}
+
+func (c *ColdStorage) GetVaultId(name string) (string, error) {
+	return c.client.GetOasVaultId(name)
+}
+
+// Archive asks OAS to pull the object from OSS. OAS may not support
+// pulling with VPC address, so the endpoint is converted first.
+func (c *ColdStorage) Archive(hot storage.HotConfig, key string) (*storage.Job, error) {
+	beego.Debug("ArchiveToOas:", c.vaultId, hot.Endpoint, hot.Bucket, key)
+	reqId, jobId, err := c.client.ArchiveToOas(
+		c.vaultId,
+		common.ConvertOssAddrToInternal(hot.Endpoint),
+		hot.Bucket,
+		key,
+		key,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.Job{Id: jobId, RequestId: reqId}, nil
+}
+
+func (c *ColdStorage) Restore(hot storage.HotConfig, archiveId, key string) (*storage.Job, error) {
+	reqId, jobId, err := c.client.RecoverToOss(
+		c.vaultId,
+		archiveId,
+		common.ConvertOssAddrToInternal(hot.Endpoint),
+		hot.Bucket,
+		key,
+		key,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.Job{Id: jobId, RequestId: reqId}, nil
+}
+
+func (c *ColdStorage) DeleteArchive(archiveId string) error {
+	_, err := c.client.DeleteArchive(c.vaultId, archiveId)
+	return err
+}
+
+// ListArchives needs an inventory retrieval job on OAS,
+// which takes hours, so it is not supported here.
+func (c *ColdStorage) ListArchives() ([]storage.Archive, error) {
+	return nil, storage.ErrorNotSupported
+}
+
+func (c *ColdStorage) GetJob(jobId string) (*storage.Job, error) {
+	_, jl, err := c.client.GetJobInfo(c.vaultId, jobId)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.Job{
+		Id:        jobId,
+		Completed: jl.Completed,
+		Failed:    jl.StatusCode == "Failed",
+		Message:   jl.StatusMessage,
+		ArchiveId: jl.ArchiveId,
+	}, nil
+}
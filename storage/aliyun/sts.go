@@ -0,0 +1,174 @@
+package aliyun
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"moduleab_server/storage"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/pborman/uuid"
+)
+
+const (
+	stsVersion    = "2015-04-01"
+	stsTimeFormat = "2006-01-02T15:04:05Z"
+	// STS refuses durations out of this range.
+	stsMinDuration = 15 * time.Minute
+	stsMaxDuration = time.Hour
+)
+
+// stsClient is used to call STS, so a stuck endpoint cannot hang agents.
+var stsClient = &http.Client{Timeout: 10 * time.Second}
+
+type stsPolicy struct {
+	Version   string
+	Statement []stsStatement
+}
+
+type stsStatement struct {
+	Effect    string
+	Action    []string
+	Resource  []string
+	Condition map[string]map[string][]string `json:",omitempty"`
+}
+
+// ScopedPolicy only allows reading and writing objects under prefix,
+// and listing with it, no matter what the role itself is allowed.
+func ScopedPolicy(bucket, prefix string) string {
+	p := stsPolicy{
+		Version: "1",
+		Statement: []stsStatement{
+			{
+				Effect:   "Allow",
+				Action:   []string{"oss:PutObject", "oss:GetObject"},
+				Resource: []string{fmt.Sprintf("acs:oss:*:*:%s/%s*", bucket, prefix)},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"oss:ListObjects"},
+				Resource: []string{fmt.Sprintf("acs:oss:*:*:%s", bucket)},
+				Condition: map[string]map[string][]string{
+					"StringLike": {"oss:Prefix": {prefix + "*"}},
+				},
+			},
+		},
+	}
+	b, _ := json.Marshal(p)
+	return string(b)
+}
+
+// IssueToken asks STS for a temporary credential with ScopedPolicy,
+// the credential of Oss must be allowed to assume RoleArn.
+func (h *HotStorage) IssueToken(prefix string, expires time.Duration) (*storage.Token, error) {
+	roleArn := h.conf.RoleArn
+	if roleArn == "" {
+		roleArn = beego.AppConfig.String("aliapi::rolearn")
+	}
+	if roleArn == "" {
+		return nil, storage.ErrorNotSupported
+	}
+	if expires < stsMinDuration {
+		expires = stsMinDuration
+	}
+	if expires > stsMaxDuration {
+		expires = stsMaxDuration
+	}
+
+	key, secret := h.conf.AccessKey, h.conf.SecretKey
+	if key == "" {
+		key = beego.AppConfig.String("aliapi::apikey")
+		secret = beego.AppConfig.String("aliapi::secret")
+	}
+
+	q := url.Values{}
+	q.Set("Action", "AssumeRole")
+	q.Set("RoleArn", roleArn)
+	q.Set("RoleSessionName", "moduleab")
+	q.Set("Policy", ScopedPolicy(h.conf.Bucket, prefix))
+	q.Set("DurationSeconds", fmt.Sprint(int64(expires/time.Second)))
+	q.Set("Format", "JSON")
+	q.Set("Version", stsVersion)
+	q.Set("AccessKeyId", key)
+	q.Set("SignatureMethod", "HMAC-SHA1")
+	q.Set("SignatureVersion", "1.0")
+	q.Set("SignatureNonce", uuid.New())
+	q.Set("Timestamp", time.Now().UTC().Format(stsTimeFormat))
+	q.Set("Signature", SignRPC(http.MethodGet, q, secret))
+
+	endpoint := beego.AppConfig.DefaultString("aliapi::stsendpoint", "sts.aliyuncs.com")
+	resp, err := stsClient.Get(fmt.Sprintf("https://%s/?%s", endpoint, q.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r := struct {
+		Code        string
+		Message     string
+		Credentials struct {
+			AccessKeyId     string
+			AccessKeySecret string
+			SecurityToken   string
+			Expiration      string
+		}
+	}{}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("STS error: %s: %s", r.Code, r.Message)
+	}
+	expiration, err := time.Parse(stsTimeFormat, r.Credentials.Expiration)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.Token{
+		Prefix:        prefix,
+		AccessKey:     r.Credentials.AccessKeyId,
+		SecretKey:     r.Credentials.AccessKeySecret,
+		SecurityToken: r.Credentials.SecurityToken,
+		Expiration:    expiration,
+	}, nil
+}
+
+// SignRPC makes signature of aliyun RPC style APIs, e.g. STS.
+func SignRPC(method string, q url.Values, secret string) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		if k != "Signature" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, percentEncode(k)+"="+percentEncode(q.Get(k)))
+	}
+	stringToSign := method + "&" + percentEncode("/") + "&" +
+		percentEncode(strings.Join(parts, "&"))
+
+	h := hmac.New(sha1.New, []byte(secret+"&"))
+	h.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func percentEncode(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.Replace(s, "+", "%20", -1)
+	s = strings.Replace(s, "*", "%2A", -1)
+	return strings.Replace(s, "%7E", "~", -1)
+}
@@ -0,0 +1,247 @@
+// Package local is a storage adapter keeping everything on the local
+// filesystem. It lets the server and the policy engine run without any
+// cloud service, e.g. in tests.
+//
+// Layout under storage::localroot:
+//
+//	hot/<bucket>/<key>
+//	cold/<vault>/<archive id>
+//	cold/<vault>/.jobs/<job id>.json
+package local
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"moduleab_server/storage"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/astaxie/beego"
+	"github.com/pborman/uuid"
+)
+
+const Name = "local"
+
+const jobsDir = ".jobs"
+
+func init() {
+	storage.RegisterHot(Name, NewHotStorage)
+	storage.RegisterCold(Name, NewColdStorage)
+}
+
+// Root is where data is kept, tests may change it.
+var Root = beego.AppConfig.DefaultString("storage::localroot", "data")
+
+type HotStorage struct {
+	dir string
+}
+
+func NewHotStorage(conf storage.HotConfig) (storage.HotStorage, error) {
+	dir := filepath.Join(Root, "hot", filepath.Clean("/"+conf.Bucket))
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &HotStorage{dir: dir}, nil
+}
+
+// Keys may look like "AppSet/Host/path/file",
+// never let them get out of the bucket.
+func (h *HotStorage) path(key string) string {
+	return filepath.Join(h.dir, filepath.Clean("/"+key))
+}
+
+func (h *HotStorage) PutObject(key string, r io.Reader) error {
+	p := h.path(key)
+	err := os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), ".upload")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func (h *HotStorage) GetObject(key string) (io.ReadCloser, error) {
+	f, err := os.Open(h.path(key))
+	if os.IsNotExist(err) {
+		return nil, storage.ErrorObjectNotFound
+	}
+	return f, err
+}
+
+func (h *HotStorage) DeleteObject(key string) error {
+	err := os.Remove(h.path(key))
+	if os.IsNotExist(err) {
+		return storage.ErrorObjectNotFound
+	}
+	return err
+}
+
+func (h *HotStorage) ListObjects(prefix string) ([]storage.Object, error) {
+	r := make([]storage.Object, 0)
+	err := filepath.Walk(h.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".upload") {
+			return nil
+		}
+		key, err := filepath.Rel(h.dir, p)
+		if err != nil {
+			return err
+		}
+		key = filepath.ToSlash(key)
+		if strings.HasPrefix(key, prefix) {
+			r = append(r, storage.Object{
+				Key:          key,
+				Size:         info.Size(),
+				LastModified: info.ModTime(),
+			})
+		}
+		return nil
+	})
+	return r, err
+}
+
+// ColdStorage finishes every job at once, but still hands out job ids
+// and keeps them on disk, so callers work the same way as with OAS.
+type ColdStorage struct {
+	dir string
+}
+
+func NewColdStorage(conf storage.ColdConfig) (storage.ColdStorage, error) {
+	vault := conf.VaultId
+	if vault == "" {
+		vault = conf.VaultName
+	}
+	dir := filepath.Join(Root, "cold", filepath.Clean("/"+vault))
+	err := os.MkdirAll(filepath.Join(dir, jobsDir), 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &ColdStorage{dir: dir}, nil
+}
+
+func (c *ColdStorage) GetVaultId(name string) (string, error) {
+	return name, nil
+}
+
+func (c *ColdStorage) Archive(hot storage.HotConfig, key string) (*storage.Job, error) {
+	h, err := storage.NewHotStorage(hot)
+	if err != nil {
+		return nil, err
+	}
+	src, err := h.GetObject(key)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	job := &storage.Job{Id: uuid.New(), RequestId: uuid.New()}
+	archiveId := uuid.New()
+	dst, err := os.OpenFile(
+		filepath.Join(c.dir, archiveId),
+		os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		0600,
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(dst, src)
+	dst.Close()
+	if err != nil {
+		job.Failed = true
+		job.Message = err.Error()
+	} else {
+		job.ArchiveId = archiveId
+	}
+	job.Completed = true
+	return job, c.saveJob(job)
+}
+
+func (c *ColdStorage) Restore(hot storage.HotConfig, archiveId, key string) (*storage.Job, error) {
+	h, err := storage.NewHotStorage(hot)
+	if err != nil {
+		return nil, err
+	}
+	src, err := os.Open(filepath.Join(c.dir, filepath.Base(archiveId)))
+	if os.IsNotExist(err) {
+		return nil, storage.ErrorObjectNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	job := &storage.Job{Id: uuid.New(), RequestId: uuid.New()}
+	err = h.PutObject(key, src)
+	if err != nil {
+		job.Failed = true
+		job.Message = err.Error()
+	}
+	job.Completed = true
+	return job, c.saveJob(job)
+}
+
+func (c *ColdStorage) DeleteArchive(archiveId string) error {
+	err := os.Remove(filepath.Join(c.dir, filepath.Base(archiveId)))
+	if os.IsNotExist(err) {
+		return storage.ErrorObjectNotFound
+	}
+	return err
+}
+
+func (c *ColdStorage) ListArchives() ([]storage.Archive, error) {
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]storage.Archive, 0)
+	for _, v := range infos {
+		if v.IsDir() {
+			continue
+		}
+		r = append(r, storage.Archive{
+			Id:          v.Name(),
+			Size:        v.Size(),
+			CreatedTime: v.ModTime(),
+		})
+	}
+	return r, nil
+}
+
+func (c *ColdStorage) GetJob(jobId string) (*storage.Job, error) {
+	b, err := ioutil.ReadFile(
+		filepath.Join(c.dir, jobsDir, filepath.Base(jobId)+".json"),
+	)
+	if os.IsNotExist(err) {
+		return nil, storage.ErrorJobNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	job := new(storage.Job)
+	err = json.Unmarshal(b, job)
+	return job, err
+}
+
+func (c *ColdStorage) saveJob(job *storage.Job) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(
+		filepath.Join(c.dir, jobsDir, job.Id+".json"),
+		b,
+		0600,
+	)
+}
@@ -0,0 +1,238 @@
+// Package s3 is the hot storage adapter for S3 compatible services,
+// e.g. MinIO or Ceph RGW.
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"moduleab_server/storage"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+const Name = "s3"
+
+// DefaultRegion is used when Oss has none, MinIO accepts it by default.
+const DefaultRegion = "us-east-1"
+
+func init() {
+	storage.RegisterHot(Name, NewHotStorage)
+}
+
+type HotStorage struct {
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	signer    *Signer
+	client    *http.Client
+}
+
+func NewHotStorage(conf storage.HotConfig) (storage.HotStorage, error) {
+	endpoint := conf.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = fmt.Sprintf("http://%s", endpoint)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Bucket == "" {
+		return nil, fmt.Errorf("Bucket is required")
+	}
+	region := conf.Region
+	if region == "" {
+		region = DefaultRegion
+	}
+	accessKey, secretKey := conf.AccessKey, conf.SecretKey
+	if accessKey == "" {
+		accessKey = beego.AppConfig.String("storage::s3accesskey")
+		secretKey = beego.AppConfig.String("storage::s3secretkey")
+	}
+	timeout := beego.AppConfig.DefaultInt64("storage::s3timeout", 0)
+	return &HotStorage{
+		endpoint:  u,
+		bucket:    conf.Bucket,
+		pathStyle: conf.PathStyle,
+		signer: &Signer{
+			AccessKey: accessKey,
+			SecretKey: secretKey,
+			Region:    region,
+		},
+		client: &http.Client{
+			Timeout: time.Duration(timeout) * time.Second,
+		},
+	}, nil
+}
+
+// URL of key in bucket, with path-style it looks like
+// http://endpoint/bucket/key, or http://bucket.endpoint/key.
+func (h *HotStorage) URL(key string) *url.URL {
+	u := *h.endpoint
+	if h.pathStyle {
+		u.Path = "/" + h.bucket
+	} else {
+		u.Host = h.bucket + "." + u.Host
+		u.Path = ""
+	}
+	if key != "" {
+		u.Path += "/" + strings.TrimPrefix(key, "/")
+	} else if u.Path == "" {
+		u.Path = "/"
+	}
+	return &u
+}
+
+func (h *HotStorage) PresignURL(method, key string, expires time.Duration) (string, error) {
+	return h.signer.Presign(method, h.URL(key), expires, time.Now()), nil
+}
+
+func (h *HotStorage) do(method string, u *url.URL, body io.ReadSeeker, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body = ioutil.NopCloser(body)
+		req.ContentLength = size
+	}
+	h.signer.Sign(req, payloadHash, time.Now())
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, storage.ErrorObjectNotFound
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, parseError(resp)
+	}
+	return resp, nil
+}
+
+// PutObject needs size and hash of the body before sending it,
+// so body which cannot seek is spooled to a temporary file first.
+func (h *HotStorage) PutObject(key string, r io.Reader) error {
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		f, err := ioutil.TempFile("", "moduleab-s3")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		_, err = io.Copy(f, r)
+		if err != nil {
+			return err
+		}
+		body = f
+	}
+
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, body)
+	if err != nil {
+		return err
+	}
+	_, err = body.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.do(
+		http.MethodPut, h.URL(key),
+		body, size, hex.EncodeToString(hash.Sum(nil)),
+	)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (h *HotStorage) GetObject(key string) (io.ReadCloser, error) {
+	resp, err := h.do(http.MethodGet, h.URL(key), nil, 0, EmptyPayload)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (h *HotStorage) DeleteObject(key string) error {
+	resp, err := h.do(http.MethodDelete, h.URL(key), nil, 0, EmptyPayload)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+}
+
+func (h *HotStorage) ListObjects(prefix string) ([]storage.Object, error) {
+	r := make([]storage.Object, 0)
+	token := ""
+	for {
+		u := h.URL("")
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+
+		resp, err := h.do(http.MethodGet, u, nil, 0, EmptyPayload)
+		if err != nil {
+			return nil, err
+		}
+		l := new(listBucketResult)
+		err = xml.NewDecoder(resp.Body).Decode(l)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range l.Contents {
+			r = append(r, storage.Object{
+				Key:          v.Key,
+				Size:         v.Size,
+				LastModified: v.LastModified,
+			})
+		}
+		if !l.IsTruncated || l.NextContinuationToken == "" {
+			return r, nil
+		}
+		token = l.NextContinuationToken
+	}
+}
+
+func parseError(resp *http.Response) error {
+	e := struct {
+		Code    string
+		Message string
+	}{}
+	b, _ := ioutil.ReadAll(resp.Body)
+	if xml.Unmarshal(b, &e) != nil || e.Code == "" {
+		return fmt.Errorf("S3 error: %s", resp.Status)
+	}
+	return fmt.Errorf("S3 error: %s: %s", e.Code, e.Message)
+}
@@ -0,0 +1,173 @@
+// Package storage abstracts where a backup set keeps its data.
+//
+// A backup set has a hot storage (agents upload to it and download from it)
+// and a cold storage (policies archive records into it and recover them
+// back). Each kind of service is an adapter registered by name, so
+// BackupSets can choose one per set, e.g. Aliyun OSS/OAS in production
+// and the local filesystem in tests.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DefaultAdapter is used when an Oss/Oas record has no type,
+// which is the case for everything created before adapters existed.
+const DefaultAdapter = "aliyun"
+
+var (
+	ErrorAdapterNotFound = errors.New("Storage adapter not found")
+	ErrorObjectNotFound  = errors.New("Object not found")
+	ErrorJobNotFound     = errors.New("Job not found")
+	ErrorNotSupported    = errors.New("Not supported by this storage")
+)
+
+// HotConfig describes a hot storage, it is usually made from models.Oss.
+type HotConfig struct {
+	Type     string
+	Endpoint string
+	Bucket   string
+	Region   string
+	// Use http://endpoint/bucket/key instead of http://bucket.endpoint/key
+	PathStyle bool
+	// Credentials of this storage, adapter may use its global one if empty.
+	AccessKey string
+	SecretKey string
+	// Role assumed to issue scoped tokens, only used by aliyun STS.
+	RoleArn string
+}
+
+// ColdConfig describes a cold storage, it is usually made from models.Oas.
+type ColdConfig struct {
+	Type      string
+	Endpoint  string
+	VaultName string
+	VaultId   string
+	// Credentials of this storage, adapter may use its global one if empty.
+	AccessKey string
+	SecretKey string
+}
+
+type Object struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+type Archive struct {
+	Id          string    `json:"id"`
+	Description string    `json:"description"`
+	Size        int64     `json:"size"`
+	CreatedTime time.Time `json:"created_time"`
+}
+
+// Job is an asynchronous operation of cold storage.
+// Archive and Restore only start a job, the caller should
+// poll GetJob until Completed is true.
+type Job struct {
+	Id        string `json:"id"`
+	RequestId string `json:"request_id"`
+	Completed bool   `json:"completed"`
+	Failed    bool   `json:"failed"`
+	Message   string `json:"message"`
+	// Set when an archive job is completed.
+	ArchiveId string `json:"archive_id"`
+}
+
+type HotStorage interface {
+	PutObject(key string, r io.Reader) error
+	GetObject(key string) (io.ReadCloser, error)
+	DeleteObject(key string) error
+	ListObjects(prefix string) ([]Object, error)
+}
+
+// Presigner is implemented by hot storages which can make time-limited
+// URLs, so agents can upload or download without any credentials.
+type Presigner interface {
+	PresignURL(method, key string, expires time.Duration) (string, error)
+}
+
+// Token is a temporary credential which is only allowed to
+// access objects under Prefix, until Expiration.
+type Token struct {
+	Prefix        string    `json:"prefix"`
+	AccessKey     string    `json:"access_key"`
+	SecretKey     string    `json:"secret"`
+	SecurityToken string    `json:"security_token"`
+	Expiration    time.Time `json:"expiration"`
+}
+
+// TokenIssuer is implemented by hot storages which can issue
+// temporary credentials scoped to a prefix, e.g. aliyun STS.
+// It returns ErrorNotSupported if the storage is not configured for it.
+type TokenIssuer interface {
+	IssueToken(prefix string, expires time.Duration) (*Token, error)
+}
+
+type ColdStorage interface {
+	// GetVaultId resolves vault name to the id used by the service.
+	GetVaultId(name string) (string, error)
+	// Archive starts a job to copy key of hot storage into the vault.
+	Archive(hot HotConfig, key string) (*Job, error)
+	// Restore starts a job to copy an archive back to hot storage as key.
+	Restore(hot HotConfig, archiveId, key string) (*Job, error)
+	DeleteArchive(archiveId string) error
+	ListArchives() ([]Archive, error)
+	GetJob(jobId string) (*Job, error)
+}
+
+type HotAdapter func(conf HotConfig) (HotStorage, error)
+type ColdAdapter func(conf ColdConfig) (ColdStorage, error)
+
+var (
+	hotAdapters  = make(map[string]HotAdapter)
+	coldAdapters = make(map[string]ColdAdapter)
+)
+
+// RegisterHot makes a hot storage adapter available by name.
+// It panics if called twice with the same name, like beego's cache.Register.
+func RegisterHot(name string, adapter HotAdapter) {
+	if adapter == nil {
+		panic("storage: RegisterHot adapter is nil")
+	}
+	if _, ok := hotAdapters[name]; ok {
+		panic("storage: RegisterHot called twice for adapter " + name)
+	}
+	hotAdapters[name] = adapter
+}
+
+// RegisterCold makes a cold storage adapter available by name.
+func RegisterCold(name string, adapter ColdAdapter) {
+	if adapter == nil {
+		panic("storage: RegisterCold adapter is nil")
+	}
+	if _, ok := coldAdapters[name]; ok {
+		panic("storage: RegisterCold called twice for adapter " + name)
+	}
+	coldAdapters[name] = adapter
+}
+
+func NewHotStorage(conf HotConfig) (HotStorage, error) {
+	if conf.Type == "" {
+		conf.Type = DefaultAdapter
+	}
+	adapter, ok := hotAdapters[conf.Type]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", ErrorAdapterNotFound, conf.Type)
+	}
+	return adapter(conf)
+}
+
+func NewColdStorage(conf ColdConfig) (ColdStorage, error) {
+	if conf.Type == "" {
+		conf.Type = DefaultAdapter
+	}
+	adapter, ok := coldAdapters[conf.Type]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", ErrorAdapterNotFound, conf.Type)
+	}
+	return adapter(conf)
+}
@@ -0,0 +1,128 @@
+package test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"moduleab_server/common"
+	"moduleab_server/controllers"
+	"moduleab_server/models"
+
+	"github.com/astaxie/beego/context"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testSession is a session.Store kept in memory.
+type testSession map[interface{}]interface{}
+
+func (s testSession) Set(k, v interface{}) error           { s[k] = v; return nil }
+func (s testSession) Get(k interface{}) interface{}        { return s[k] }
+func (s testSession) Delete(k interface{}) error           { delete(s, k); return nil }
+func (s testSession) SessionID() string                    { return "test" }
+func (s testSession) SessionRelease(w http.ResponseWriter) {}
+func (s testSession) Flush() error                         { return nil }
+
+func TestAuthFilter(t *testing.T) {
+	resolver, checkNonce := common.HostKeyResolver, common.CheckNonce
+	tokenResolver, checker := controllers.AccessTokenResolver, controllers.PermissionChecker
+	defer func() {
+		common.HostKeyResolver, common.CheckNonce = resolver, checkNonce
+		controllers.AccessTokenResolver, controllers.PermissionChecker = tokenResolver, checker
+	}()
+	common.HostKeyResolver = func(keyId string) (string, string, string, error) {
+		return "host1-id", "host1", "secret1", nil
+	}
+	common.CheckNonce = func(nonce string, ttl time.Duration) (bool, error) {
+		return true, nil
+	}
+	// operator can read and write records, viewer can only read them.
+	granted := map[string]map[string]bool{
+		"operator": {"records:read": true, "records:write": true},
+		"viewer":   {"records:read": true},
+	}
+	controllers.PermissionChecker = func(userId, required string) (bool, error) {
+		return granted[userId][required], nil
+	}
+	controllers.AccessTokenResolver = func(token string) (*models.AccessTokens, error) {
+		if token != models.AccessTokenPrefix+"good" {
+			return nil, models.ErrorAccessTokenInvalid
+		}
+		return &models.AccessTokens{
+			Id:     "token1",
+			User:   &models.Users{Id: "operator"},
+			Scopes: "records:read",
+		}, nil
+	}
+
+	Convey("Subject: Authentication and authorization of every API\n", t, func() {
+		for _, c := range []struct {
+			name    string
+			method  string
+			url     string
+			user    string // user of session
+			token   string
+			secret  string // host signs request with it
+			status  int    // 0 if the request goes on
+			subject string
+		}{
+			{"public API", "POST", "/api/v1/auth/login", "", "", "", 0, ""},
+			{"anonymous", "GET", "/api/v1/records", "", "", "", http.StatusUnauthorized, ""},
+			{"session with permission", "POST", "/api/v1/records", "operator", "", "", 0, "operator"},
+			{"session without permission", "POST", "/api/v1/records", "viewer", "", "", http.StatusForbidden, ""},
+			{"token in scope", "GET", "/api/v1/records", "", "good", "", 0, "operator"},
+			{"token out of scope", "POST", "/api/v1/records", "", "good", "", http.StatusForbidden, ""},
+			{"token managing tokens", "GET", "/api/v1/accessTokens", "", "good", "", http.StatusForbidden, ""},
+			{"bad token", "GET", "/api/v1/records", "", "bad", "", http.StatusUnauthorized, ""},
+			{"signed by host", "POST", "/api/v1/records", "", "", "secret1", 0, "host1-id"},
+			{"host on user only API", "GET", "/api/v1/authEvents", "", "", "secret1", http.StatusForbidden, ""},
+			{"host adding user", "POST", "/api/v1/users", "", "", "secret1", http.StatusForbidden, ""},
+			{"host changing role", "PUT", "/api/v1/roles/Administrator", "", "", "secret1", http.StatusForbidden, ""},
+			{"host granting permissions", "POST", "/api/v1/roles/permissions", "", "", "secret1", http.StatusForbidden, ""},
+			{"host deleting record", "DELETE", "/api/v1/records/r1", "", "", "secret1", http.StatusForbidden, ""},
+			{"host recovering record", "GET", "/api/v1/records/r1/recover", "", "", "secret1", http.StatusForbidden, ""},
+			{"host getting own token", "GET", "/api/v1/client/token/host1", "", "", "secret1", 0, "host1-id"},
+			{"host getting token of other", "GET", "/api/v1/client/token/host2", "", "", "secret1", http.StatusForbidden, ""},
+			{"host taking signals of other", "GET", "/api/v1/client/signal/host2/ws", "", "", "secret1", http.StatusForbidden, ""},
+			{"host on fleet status", "GET", "/api/v1/client/config/status", "", "", "secret1", http.StatusForbidden, ""},
+			{"user getting token of host", "GET", "/api/v1/client/token/host1", "operator", "", "", http.StatusForbidden, ""},
+			{"user taking signals of host", "GET", "/api/v1/client/signal/host1/ws", "operator", "", "", http.StatusForbidden, ""},
+			{"bad signature", "POST", "/api/v1/records", "", "", "wrong", http.StatusForbidden, ""},
+		} {
+			Convey(c.name, func() {
+				body := []byte("{}")
+				r, _ := http.NewRequest(c.method, c.url, bytes.NewReader(body))
+				r.Header.Set("Date", time.Now().UTC().Format(time.RFC1123))
+				w := httptest.NewRecorder()
+				ctx := context.NewContext()
+				ctx.Reset(w, r)
+				ctx.Input.RequestBody = body
+				ctx.Input.CruSession = testSession{}
+				if c.user != "" {
+					ctx.Input.CruSession.Set("id", c.user)
+				}
+				if c.token != "" {
+					r.Header.Set("Authorization", "Bearer "+models.AccessTokenPrefix+c.token)
+				}
+				if c.secret != "" {
+					signV2(ctx, "key1", c.secret, "nonce-"+c.name)
+				}
+
+				controllers.AuthFilter(ctx)
+				if c.status == 0 {
+					So(ctx.ResponseWriter.Started, ShouldBeFalse)
+				} else {
+					So(ctx.ResponseWriter.Started, ShouldBeTrue)
+					So(w.Code, ShouldEqual, c.status)
+				}
+				if c.subject != "" {
+					p, err := controllers.Authenticate(ctx)
+					So(err, ShouldBeNil)
+					So(p.Id, ShouldEqual, c.subject)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"moduleab_server/common"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newAuthContext(method, url, name string, body []byte) *context.Context {
+	r, _ := http.NewRequest(method, url, bytes.NewReader(body))
+	r.Header.Set("Date", time.Now().UTC().Format(time.RFC1123))
+	ctx := context.NewContext()
+	ctx.Reset(nil, r)
+	ctx.Input.RequestBody = body
+	ctx.Input.SetParam(":name", name)
+	return ctx
+}
+
+func signV1(ctx *context.Context, keyId, secret string) {
+	h := hmac.New(sha1.New, []byte(secret))
+	h.Write([]byte(ctx.Input.Header("Date") + ctx.Input.URL()))
+	ctx.Request.Header.Set("KeyId", keyId)
+	ctx.Request.Header.Set("Signature", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+}
+
+func signV2(ctx *context.Context, keyId, secret, nonce string) {
+	ctx.Request.Header.Set("KeyId", keyId)
+	ctx.Request.Header.Set("Signature-Version", common.SignatureV2)
+	ctx.Request.Header.Set("Nonce", nonce)
+	ctx.Request.Header.Set("Signature", common.SignV2(secret, common.StringToSignV2(
+		ctx.Input.Method(),
+		ctx.Input.URL(),
+		ctx.Request.URL.Query(),
+		ctx.Input.RequestBody,
+		ctx.Input.Header("Date"),
+		nonce,
+	)))
+}
+
+func TestAuthWithHostKey(t *testing.T) {
+	resolver, checkNonce := common.HostKeyResolver, common.CheckNonce
+	defer func() {
+		common.HostKeyResolver, common.CheckNonce = resolver, checkNonce
+	}()
+	common.HostKeyResolver = func(keyId string) (string, string, string, error) {
+		return "host1-id", "host1", "secret1", nil
+	}
+	nonces := make(map[string]bool)
+	common.CheckNonce = func(nonce string, ttl time.Duration) (bool, error) {
+		if nonces[nonce] {
+			return false, nil
+		}
+		nonces[nonce] = true
+		return true, nil
+	}
+
+	Convey("Subject: Agents sign requests with their own key\n", t, func() {
+		Convey("Key of host works on its own URL", func() {
+			ctx := newAuthContext("GET", "/api/v1/client/signal/host1", "host1", nil)
+			signV2(ctx, "key1", "secret1", "nonce1")
+			So(common.AuthWithKey(ctx), ShouldBeNil)
+			id, name := common.AuthedHost(ctx)
+			So(id, ShouldEqual, "host1-id")
+			So(name, ShouldEqual, "host1")
+		})
+		Convey("Key of host cannot be used for another host", func() {
+			ctx := newAuthContext("GET", "/api/v1/client/signal/host2", "host2", nil)
+			signV2(ctx, "key1", "secret1", "nonce2")
+			So(common.AuthWithKey(ctx), ShouldEqual, common.ErrorHostNotMatch)
+		})
+		Convey("Signature with wrong secret is refused", func() {
+			ctx := newAuthContext("GET", "/api/v1/client/signal/host1", "host1", nil)
+			signV2(ctx, "key1", "secret2", "nonce3")
+			So(common.AuthWithKey(ctx), ShouldNotBeNil)
+		})
+		Convey("Shared loginkey is disabled by default", func() {
+			ctx := newAuthContext("GET", "/api/v1/client/signal/host1", "host1", nil)
+			signV2(ctx, "", "secret1", "nonce4")
+			So(common.AuthWithKey(ctx), ShouldEqual, common.ErrorLoginKeyDisabled)
+		})
+	})
+
+	Convey("Subject: Signature version 2 refuses replays\n", t, func() {
+		body := []byte(`{"filename":"a.tar.gz"}`)
+		// Convey runs this again for every case, so nonce must be new.
+		ctx := newAuthContext("POST", "/api/v1/records?x=1", "", body)
+		signV2(ctx, "key1", "secret1", fmt.Sprint("replay", len(nonces)))
+		sign := ctx.Input.Header("Signature")
+		So(common.AuthWithKey(ctx), ShouldBeNil)
+
+		Convey("The same nonce cannot be used twice", func() {
+			So(common.AuthWithKey(ctx), ShouldEqual, common.ErrorNonceUsed)
+		})
+		Convey("Signature is bound to method", func() {
+			ctx := newAuthContext("DELETE", "/api/v1/records?x=1", "", body)
+			signV2(ctx, "key1", "secret1", "nonce6")
+			ctx.Request.Header.Set("Signature", sign)
+			So(common.AuthWithKey(ctx), ShouldNotBeNil)
+		})
+		Convey("Signature is bound to body", func() {
+			ctx := newAuthContext("POST", "/api/v1/records?x=1", "", []byte(`{}`))
+			signV2(ctx, "key1", "secret1", "nonce7")
+			ctx.Request.Header.Set("Signature", sign)
+			So(common.AuthWithKey(ctx), ShouldNotBeNil)
+		})
+		Convey("Signature is bound to query", func() {
+			ctx := newAuthContext("POST", "/api/v1/records?x=2", "", body)
+			signV2(ctx, "key1", "secret1", "nonce8")
+			ctx.Request.Header.Set("Signature", sign)
+			So(common.AuthWithKey(ctx), ShouldNotBeNil)
+		})
+		Convey("Version 1 is disabled by default", func() {
+			ctx := newAuthContext("GET", "/api/v1/client/signal/host1", "host1", nil)
+			signV1(ctx, "key1", "secret1")
+			So(common.AuthWithKey(ctx), ShouldEqual, common.ErrorSignV1Disabled)
+		})
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	Convey("Subject: X-Forwarded-For is only honoured from trusted proxies\n", t, func() {
+		ctx := newAuthContext("POST", "/api/v1/login", "", nil)
+		ctx.Request.RemoteAddr = "10.0.0.2:51234"
+		ctx.Request.Header.Set("X-Forwarded-For", "1.2.3.4, 192.0.2.7")
+		defer beego.AppConfig.Set("security::trustedproxies", "")
+
+		Convey("Anyone else gets the IP of its connection", func() {
+			beego.AppConfig.Set("security::trustedproxies", "")
+			So(common.ClientIP(ctx), ShouldEqual, "10.0.0.2")
+		})
+		Convey("Proxy adds the IP it got the request from", func() {
+			beego.AppConfig.Set("security::trustedproxies", "10.0.0.0/8")
+			So(common.ClientIP(ctx), ShouldEqual, "192.0.2.7")
+		})
+		Convey("Chain of trusted proxies is walked back", func() {
+			beego.AppConfig.Set("security::trustedproxies", "10.0.0.0/8,192.0.2.7")
+			So(common.ClientIP(ctx), ShouldEqual, "1.2.3.4")
+		})
+	})
+}
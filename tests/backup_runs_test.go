@@ -0,0 +1,46 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"moduleab_server/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBackupRuns(t *testing.T) {
+	Convey("Subject: Backup runs follow progress and result reports\n", t, func() {
+		start := time.Now()
+		run := &models.BackupRuns{Status: models.BackupRunStatusRunning, StartedTime: start}
+
+		So(run.Apply(&models.BackupReport{Percent: 40, Bytes: 1024, Files: 3}, start.Add(time.Minute)), ShouldBeNil)
+		So(run.Percent, ShouldEqual, 40)
+		So(run.Bytes, ShouldEqual, 1024)
+		So(run.Finished(), ShouldBeFalse)
+
+		// Percent never goes back.
+		So(run.Apply(&models.BackupReport{Percent: 10}, start.Add(2*time.Minute)), ShouldBeNil)
+		So(run.Percent, ShouldEqual, 40)
+		So(run.Bytes, ShouldEqual, 1024)
+
+		ok := false
+		So(run.Apply(&models.BackupReport{Ok: &ok, Message: "disk full"}, start.Add(5*time.Minute)), ShouldBeNil)
+		So(run.Status, ShouldEqual, models.BackupRunStatusFailed)
+		So(run.Message, ShouldEqual, "disk full")
+		So(run.Duration, ShouldEqual, 300)
+		So(run.Finished(), ShouldBeTrue)
+
+		So(run.Apply(&models.BackupReport{Percent: 50}, start.Add(6*time.Minute)),
+			ShouldEqual, models.ErrorBackupRunFinished)
+	})
+
+	Convey("Subject: Succeeded runs are done\n", t, func() {
+		start := time.Now()
+		run := &models.BackupRuns{Status: models.BackupRunStatusRunning, StartedTime: start}
+		ok := true
+		So(run.Apply(&models.BackupReport{Ok: &ok}, start.Add(time.Second)), ShouldBeNil)
+		So(run.Status, ShouldEqual, models.BackupRunStatusSucceeded)
+		So(run.Percent, ShouldEqual, 100)
+	})
+}
@@ -0,0 +1,40 @@
+package test
+
+import (
+	"testing"
+
+	"moduleab_server/common"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncryptSecret(t *testing.T) {
+	Convey("Subject: Secrets are encrypted with master key\n", t, func() {
+		enc, err := common.EncryptSecret("TestAAA")
+		So(err, ShouldBeNil)
+		So(enc, ShouldNotContainSubstring, "TestAAA")
+		So(common.IsEncryptedSecret(enc), ShouldBeTrue)
+
+		Convey("Encrypted secret can be decrypted", func() {
+			dec, err := common.DecryptSecret(enc)
+			So(err, ShouldBeNil)
+			So(dec, ShouldEqual, "TestAAA")
+		})
+		Convey("Encrypting twice gives different results", func() {
+			enc2, _ := common.EncryptSecret("TestAAA")
+			So(enc2, ShouldNotEqual, enc)
+		})
+		Convey("What looks encrypted is encrypted again", func() {
+			planted := "enc:v1:" + "AAAA"
+			enc3, err := common.EncryptSecret(planted)
+			So(err, ShouldBeNil)
+			So(enc3, ShouldNotEqual, planted)
+			dec, _ := common.DecryptSecret(enc3)
+			So(dec, ShouldEqual, planted)
+		})
+		Convey("Plain text is not accepted as encrypted", func() {
+			_, err := common.DecryptSecret("TestAAA")
+			So(err, ShouldEqual, common.ErrorBadSecretFormat)
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"moduleab_server/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDispatches(t *testing.T) {
+	Convey("Subject: Backups of a job are dispatched once a period\n", t, func() {
+		job := &models.ClientJobs{Id: "j1", Period: 3600}
+		host := &models.Hosts{Id: "h1"}
+		path := &models.Paths{Id: "p1", Path: "/data"}
+		now := time.Unix(7200, 0)
+
+		slot := models.DispatchSlot(job, host, path, now)
+		So(slot, ShouldEqual, "j1/h1/p1/2")
+		So(models.DispatchSlot(job, host, path, now.Add(59*time.Minute)), ShouldEqual, slot)
+		So(models.DispatchSlot(job, host, path, now.Add(time.Hour)), ShouldNotEqual, slot)
+	})
+
+	Convey("Subject: Dispatched signals tell agents what to do\n", t, func() {
+		job := &models.ClientJobs{Id: "j1", Period: 3600, ReservedTime: 86400}
+		s := models.MakeBackupSignal(&models.Paths{Path: "/data"}, job)
+		So(s["type"], ShouldEqual, models.SignalTypeBackup)
+		So(s["path"], ShouldEqual, "/data")
+		So(s["job"], ShouldEqual, "j1")
+		So(s["reserved_time"], ShouldEqual, 86400)
+
+		s = models.MakeBackupSignal(&models.Paths{Path: "/data"}, nil)
+		So(s, ShouldNotContainKey, "job")
+
+		s = models.MakeCleanupSignal("/data", 600)
+		So(s["type"], ShouldEqual, models.SignalTypeCleanup)
+		So(s["reserved_time"], ShouldEqual, 600)
+
+		host := &models.Hosts{Id: "h1"}
+		path := &models.Paths{Id: "p1"}
+		jobs := []*models.ClientJobs{
+			{ReservedTime: 600, Host: []*models.Hosts{host}, Paths: []*models.Paths{path}},
+			{ReservedTime: 86400, Host: []*models.Hosts{host}, Paths: []*models.Paths{path}},
+			{ReservedTime: 604800, Host: []*models.Hosts{{Id: "h2"}}, Paths: []*models.Paths{path}},
+		}
+		So(models.ReservedTimeOf(jobs, host, path), ShouldEqual, 86400)
+		So(models.ReservedTimeOf(jobs, host, &models.Paths{Id: "p2"}), ShouldEqual, 0)
+
+		s = models.MakeRunHookSignal("flush", map[string]string{"db": "app"})
+		So(s["type"], ShouldEqual, models.SignalTypeRunHook)
+		So(s["hook"], ShouldEqual, "flush")
+
+		for name, typ := range models.SignalTypeNames {
+			So(typ, ShouldBeGreaterThan, models.SignalTypeDownload)
+			So(name, ShouldNotBeEmpty)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"moduleab_server/models"
+
+	"github.com/astaxie/beego/validation"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEnrollment(t *testing.T) {
+	Convey("Subject: Enrollment tokens are used only once before expiry\n", t, func() {
+		now := time.Now()
+		token := &models.EnrollmentTokens{ExpiresTime: now.Add(time.Hour)}
+		So(token.Usable(now), ShouldBeNil)
+		So(token.Usable(now.Add(2*time.Hour)), ShouldEqual, models.ErrorEnrollmentTokenExpired)
+		token.Used = true
+		So(token.Usable(now), ShouldEqual, models.ErrorEnrollmentTokenUsed)
+
+		_, _, err := models.EnrollHost("not-a-token", "host1", []string{"10.0.0.1"})
+		So(err, ShouldEqual, models.ErrorEnrollmentTokenInvalid)
+	})
+
+	Convey("Subject: Hosts may have IPv4 and IPv6 addresses\n", t, func() {
+		for _, c := range []struct {
+			ip    string
+			addrs []string
+			ok    bool
+		}{
+			{"10.0.0.1", nil, true},
+			{"2001:db8::1", []string{"10.0.0.1", "fe80::1"}, true},
+			{"10.0.0.256", nil, false},
+			{"10.0.0.1", []string{"example.com"}, false},
+		} {
+			host := &models.Hosts{
+				Id:     "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+				Name:   "host1",
+				IpAddr: c.ip,
+			}
+			for _, v := range c.addrs {
+				host.Addresses = append(host.Addresses, &models.HostAddresses{Addr: v})
+			}
+			valid, err := new(validation.Validation).Valid(host)
+			So(err, ShouldBeNil)
+			So(valid, ShouldEqual, c.ok)
+		}
+		So((&models.Hosts{Status: models.HostStatusPending}).IsPending(), ShouldBeTrue)
+	})
+
+	Convey("Subject: Host names are plain names\n", t, func() {
+		for name, ok := range map[string]bool{
+			"host1":                 true,
+			"web-01.prod_a":         true,
+			"":                      false,
+			"-host":                 false,
+			".hidden":               false,
+			"../etc":                false,
+			"a/b":                   false,
+			"a\\b":                  false,
+			"host 1":                false,
+			strings.Repeat("a", 64): true,
+			strings.Repeat("a", 65): false,
+		} {
+			if ok {
+				So(models.ValidHostName(name), ShouldBeNil)
+			} else {
+				So(models.ValidHostName(name), ShouldEqual, models.ErrorHostBadName)
+			}
+		}
+
+		_, _, err := models.EnrollHost(models.EnrollmentTokenPrefix+"x", "../etc", []string{"10.0.0.1"})
+		So(err, ShouldEqual, models.ErrorHostBadName)
+		_, err = models.AddHost(&models.Hosts{Name: "a/b", IpAddr: "10.0.0.1"})
+		So(err, ShouldEqual, models.ErrorHostBadName)
+	})
+}
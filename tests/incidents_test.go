@@ -0,0 +1,42 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"moduleab_server/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIncidents(t *testing.T) {
+	Convey("Subject: RPO of a job defaults to its period times rpofactor\n", t, func() {
+		So((&models.ClientJobs{Period: 3600}).RpoTarget(), ShouldEqual, 2*time.Hour)
+		So((&models.ClientJobs{Period: 3600, Rpo: 600}).RpoTarget(), ShouldEqual, 10*time.Minute)
+	})
+
+	Convey("Subject: A path in many jobs is checked once with the strictest RPO\n", t, func() {
+		h1 := &models.Hosts{Id: "h1", Status: models.HostStatusApproved}
+		h2 := &models.Hosts{Id: "h2", Status: models.HostStatusPending}
+		p1 := &models.Paths{Id: "p1"}
+		p2 := &models.Paths{Id: "p2"}
+		targets := models.BackupTargets([]*models.ClientJobs{
+			{Period: 3600, Host: []*models.Hosts{h1, h2}, Paths: []*models.Paths{p1}},
+			{Period: 600, Host: []*models.Hosts{h1}, Paths: []*models.Paths{p1, p2}},
+			{Period: 0, Host: []*models.Hosts{h1}, Paths: []*models.Paths{p2}},
+		})
+		So(len(targets), ShouldEqual, 2)
+		So(targets[0].Host, ShouldEqual, h1)
+		So(targets[0].Path, ShouldEqual, p1)
+		So(targets[0].Rpo, ShouldEqual, 20*time.Minute)
+		So(targets[1].Path, ShouldEqual, p2)
+	})
+
+	Convey("Subject: Paths never backed up are stale since their first dispatch\n", t, func() {
+		now := time.Now()
+		So(models.Staleness(now.Add(-time.Hour), time.Time{}, now), ShouldEqual, time.Hour)
+		So(models.Staleness(now.Add(-time.Hour), now.Add(-48*time.Hour), now), ShouldEqual, time.Hour)
+		So(models.Staleness(time.Time{}, now.Add(-2*time.Hour), now), ShouldEqual, 2*time.Hour)
+		So(models.Staleness(time.Time{}, time.Time{}, now), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,189 @@
+package test
+
+import (
+	"net"
+	"testing"
+
+	"moduleab_server/common"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type ldapTestEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// ldapTestServer is just enough of a directory for LdapAuthenticate:
+// simple bind, and search with a filter like (uid=name).
+type ldapTestServer struct {
+	listener net.Listener
+	entries  []ldapTestEntry
+}
+
+func newLdapTestServer(entries ...ldapTestEntry) (*ldapTestServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &ldapTestServer{listener: l, entries: entries}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, nil
+}
+
+func (s *ldapTestServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapTestServer) Close() {
+	s.listener.Close()
+}
+
+func ldapTestResult(tag ber.Tag, code int64) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return p
+}
+
+func (s *ldapTestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		req, err := ber.ReadPacket(conn)
+		if err != nil || len(req.Children) < 2 {
+			return
+		}
+		id := req.Children[0].Value
+		op := req.Children[1]
+		replies := make([]*ber.Packet, 0)
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Data.String()
+			password := op.Children[2].Data.String()
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			for _, e := range s.entries {
+				if e.dn == dn && e.password == password {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			replies = append(replies, ldapTestResult(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			for _, e := range s.entries {
+				if len(e.attrs["uid"]) == 0 || filter != "(uid="+e.attrs["uid"][0]+")" {
+					continue
+				}
+				p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+				p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+				attrs := ber.NewSequence("")
+				for k, values := range e.attrs {
+					attr := ber.NewSequence("")
+					attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, k, ""))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					for _, v := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+					}
+					attr.AppendChild(set)
+					attrs.AppendChild(attr)
+				}
+				p.AppendChild(attrs)
+				replies = append(replies, p)
+			}
+			replies = append(replies, ldapTestResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		default:
+			return
+		}
+		for _, v := range replies {
+			resp := ber.NewSequence("")
+			resp.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+			resp.AppendChild(v)
+			_, err = conn.Write(resp.Bytes())
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestLdapAuthenticate(t *testing.T) {
+	server, err := newLdapTestServer(
+		ldapTestEntry{
+			dn:       "cn=reader,dc=example,dc=com",
+			password: "readerpass",
+		},
+		ldapTestEntry{
+			dn:       "uid=alice,ou=people,dc=example,dc=com",
+			password: "alicepass",
+			attrs: map[string][]string{
+				"uid":      {"alice"},
+				"cn":       {"Alice"},
+				"memberOf": {"CN=Ops,ou=groups,dc=example,dc=com", "cn=dev,ou=groups,dc=example,dc=com"},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	conf := &common.LdapConfig{
+		URL:          server.URL(),
+		BindDN:       "cn=reader,dc=example,dc=com",
+		BindPassword: "readerpass",
+		BaseDN:       "dc=example,dc=com",
+		UserFilter:   "(uid=%s)",
+		NameAttr:     "cn",
+		GroupAttr:    "memberOf",
+		GroupMap: map[string]string{
+			"cn=ops,ou=groups,dc=example,dc=com": "Operator",
+		},
+		DefaultRole: "User",
+	}
+
+	Convey("Subject: Users are found with search account and bound as themselves\n", t, func() {
+		entry, err := common.LdapAuthenticate(conf, "alice", "alicepass")
+		So(err, ShouldBeNil)
+		So(entry.DN, ShouldEqual, "uid=alice,ou=people,dc=example,dc=com")
+		So(entry.ShowName, ShouldEqual, "Alice")
+		So(len(entry.Groups), ShouldEqual, 2)
+		So(conf.RoleNames(entry.Groups), ShouldResemble, []string{"Operator"})
+	})
+
+	Convey("Subject: Wrong password, unknown user and empty password are refused\n", t, func() {
+		_, err := common.LdapAuthenticate(conf, "alice", "wrong")
+		So(err, ShouldEqual, common.ErrorLdapBadUser)
+		_, err = common.LdapAuthenticate(conf, "bob", "alicepass")
+		So(err, ShouldEqual, common.ErrorLdapBadUser)
+		_, err = common.LdapAuthenticate(conf, "alice", "")
+		So(err, ShouldEqual, common.ErrorLdapBadUser)
+		_, err = common.LdapAuthenticate(conf, "*", "alicepass")
+		So(err, ShouldEqual, common.ErrorLdapBadUser)
+	})
+
+	Convey("Subject: Bad search account is an error, not a bad user\n", t, func() {
+		bad := *conf
+		bad.BindPassword = "wrong"
+		_, err := common.LdapAuthenticate(&bad, "alice", "alicepass")
+		So(err, ShouldNotBeNil)
+		So(err, ShouldNotEqual, common.ErrorLdapBadUser)
+	})
+
+	Convey("Subject: Users in no mapped group get the default role\n", t, func() {
+		So(conf.RoleNames([]string{"cn=dev,ou=groups,dc=example,dc=com"}), ShouldResemble, []string{"User"})
+		noDefault := *conf
+		noDefault.DefaultRole = ""
+		So(noDefault.RoleNames(nil), ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,185 @@
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"moduleab_server/models"
+	"moduleab_server/notify"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeSMTP accepts one mail and sends what it got to mails.
+func fakeSMTP(t *testing.T, mails chan<- string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		reply := func(s string) { c.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var data []string
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if inData {
+				if line == "." {
+					inData = false
+					reply("250 OK")
+					mails <- strings.Join(data, "\n")
+					continue
+				}
+				data = append(data, line)
+				continue
+			}
+			switch strings.ToUpper(strings.SplitN(line, " ", 2)[0]) {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "DATA":
+				inData = true
+				reply("354 Go ahead")
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestNotify(t *testing.T) {
+	m := &notify.Message{
+		Id:       "m1",
+		Event:    models.EventIncidentOpened,
+		Severity: notify.SeverityCritical,
+		Title:    "Backup missed",
+		Text:     "host1:/data never backed up",
+		Time:     time.Now(),
+	}
+
+	Convey("Subject: Webhooks are signed JSON\n", t, func() {
+		var got notify.Message
+		var header http.Header
+		var body []byte
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			body, _ = ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &got)
+		}))
+		defer ts.Close()
+
+		s, err := notify.NewSender(notify.Config{Type: "webhook", Target: ts.URL, Secret: "s3cret"})
+		So(err, ShouldBeNil)
+		So(s.Send(m), ShouldBeNil)
+		So(got.Id, ShouldEqual, "m1")
+		So(header.Get(notify.HeaderEvent), ShouldEqual, models.EventIncidentOpened)
+		timestamp, _ := strconv.ParseInt(header.Get(notify.HeaderTimestamp), 10, 64)
+		So(header.Get(notify.HeaderSignature), ShouldEqual, notify.Sign("s3cret", timestamp, body))
+	})
+
+	Convey("Subject: Failed webhooks are errors\n", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer ts.Close()
+		s, _ := notify.NewSender(notify.Config{Type: "slack", Target: ts.URL})
+		So(s.Send(m), ShouldNotBeNil)
+
+		_, err := notify.NewSender(notify.Config{Type: "pager", Target: ts.URL})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Subject: DingTalk robots are signed in query\n", t, func() {
+		var content, sign string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var b struct {
+				Text struct {
+					Content string `json:"content"`
+				} `json:"text"`
+			}
+			json.NewDecoder(r.Body).Decode(&b)
+			content = b.Text.Content
+			timestamp, _ := strconv.ParseInt(r.URL.Query().Get("timestamp"), 10, 64)
+			if r.URL.Query().Get("sign") == notify.DingTalkSign("s3cret", timestamp) {
+				sign = "ok"
+			}
+			w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+		}))
+		defer ts.Close()
+		s, _ := notify.NewSender(notify.Config{Type: "dingtalk", Target: ts.URL, Secret: "s3cret"})
+		So(s.Send(m), ShouldBeNil)
+		So(content, ShouldContainSubstring, "Backup missed")
+		So(sign, ShouldEqual, "ok")
+	})
+
+	Convey("Subject: Mails are sent through smtp\n", t, func() {
+		mails := make(chan string, 1)
+		addr := fakeSMTP(t, mails)
+		s, err := notify.NewSender(notify.Config{
+			Type:       "smtp",
+			Target:     addr,
+			From:       "moduleab@example.com",
+			Recipients: []string{"ops@example.com"},
+		})
+		So(err, ShouldBeNil)
+		So(s.Send(m), ShouldBeNil)
+		mail := <-mails
+		So(mail, ShouldContainSubstring, "To: ops@example.com")
+		So(mail, ShouldContainSubstring, "host1:/data never backed up")
+	})
+
+	Convey("Subject: Rules match events by pattern and severity\n", t, func() {
+		rule := &models.NotificationRules{Event: "incident.*", Severity: notify.SeverityWarning}
+		So(rule.Matches(models.EventIncidentOpened, notify.SeverityCritical), ShouldBeTrue)
+		So(rule.Matches(models.EventIncidentResolved, notify.SeverityInfo), ShouldBeFalse)
+		So(rule.Matches(models.EventPolicyFailed, notify.SeverityCritical), ShouldBeFalse)
+		So((&models.NotificationRules{Event: "*"}).Matches(models.EventAgentDisconnected, notify.SeverityWarning), ShouldBeTrue)
+	})
+
+	Convey("Subject: Webhook channels must have a secret\n", t, func() {
+		_, err := models.AddNotificationChannel(&models.NotificationChannels{
+			Name:   "ops",
+			Type:   "webhook",
+			Target: "https://example.com/hook",
+		})
+		So(err, ShouldEqual, models.ErrorNotificationNoSecret)
+	})
+
+	Convey("Subject: Failed deliveries are retried with backoff\n", t, func() {
+		So(models.NotificationBackoff(1), ShouldEqual, 30*time.Second)
+		So(models.NotificationBackoff(3), ShouldEqual, 2*time.Minute)
+
+		now := time.Now()
+		d := &models.NotificationDeliveries{Status: models.NotificationStatusPending, Attempts: 2}
+		d.Fail(now, errors.New("timeout"), 5)
+		So(d.Status, ShouldEqual, models.NotificationStatusPending)
+		So(d.NextTime, ShouldResemble, now.Add(time.Minute))
+		d.Attempts = 5
+		d.Fail(now, errors.New("timeout"), 5)
+		So(d.Status, ShouldEqual, models.NotificationStatusFailed)
+		So(d.LastError, ShouldEqual, "timeout")
+	})
+}
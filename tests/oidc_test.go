@@ -0,0 +1,225 @@
+package test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"moduleab_server/common"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// oidcTestProvider is a mock OpenID provider, which logs in alice
+// at once on /authorize, and checks PKCE on /token.
+type oidcTestProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+	lock   sync.Mutex
+	// code -> challenge and nonce of its /authorize
+	codes map[string][2]string
+}
+
+func newOidcTestProvider() (*oidcTestProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	p := &oidcTestProvider{
+		key:   key,
+		codes: make(map[string][2]string),
+		claims: map[string]interface{}{
+			"sub":                "1001",
+			"preferred_username": "alice",
+			"name":               "Alice",
+			"groups":             []string{"backup-ops", "dev"},
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "k1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "PKCE is required", http.StatusBadRequest)
+			return
+		}
+		code, _ := common.NewOidcRandom()
+		p.lock.Lock()
+		p.codes[code] = [2]string{q.Get("code_challenge"), q.Get("nonce")}
+		p.lock.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{
+			"code":  {code},
+			"state": {q.Get("state")},
+		}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.lock.Lock()
+		c, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.lock.Unlock()
+		if !ok || common.PkceChallenge(r.PostForm.Get("code_verifier")) != c[0] {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":   p.URL,
+			"aud":   r.PostForm.Get("client_id"),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": c[1],
+		}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "at",
+			"id_token":     p.sign(claims),
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p, nil
+}
+
+func (p *oidcTestProvider) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	payload, _ := json.Marshal(claims)
+	s := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(s))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	return s + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOidc(t *testing.T) {
+	provider, err := newOidcTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer provider.Close()
+
+	conf := &common.OidcConfig{
+		Issuer:      provider.URL,
+		ClientId:    "moduleab",
+		RedirectURL: "http://console.example.com/api/v1/auth/oidc/callback",
+		Scopes:      []string{"openid", "profile"},
+		UserClaim:   "preferred_username",
+		NameClaim:   "name",
+		RolesClaim:  "groups",
+		RoleMap:     map[string]string{"backup-ops": "Operator"},
+	}
+	noRedirect := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	// login goes through /authorize, and returns code of the callback.
+	login := func(p *common.OidcProvider, state, nonce, verifier string) (string, string) {
+		resp, err := noRedirect.Get(conf.AuthCodeURL(p, state, nonce, verifier))
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+		u, err := url.Parse(resp.Header.Get("Location"))
+		So(err, ShouldBeNil)
+		return u.Query().Get("code"), u.Query().Get("state")
+	}
+
+	Convey("Subject: Authorization code flow with PKCE\n", t, func() {
+		p, err := common.OidcDiscover(conf.Issuer)
+		So(err, ShouldBeNil)
+		So(p.TokenEndpoint, ShouldEqual, provider.URL+"/token")
+
+		verifier, _ := common.NewOidcRandom()
+		code, state := login(p, "state1", "nonce1", verifier)
+		So(state, ShouldEqual, "state1")
+		idToken, err := conf.Exchange(p, code, verifier)
+		So(err, ShouldBeNil)
+
+		claims, err := conf.VerifyIdToken(p, idToken, "nonce1", time.Now())
+		So(err, ShouldBeNil)
+		name, showName, roles, err := conf.UserOf(claims)
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, "alice")
+		So(showName, ShouldEqual, "Alice")
+		So(roles, ShouldResemble, []string{"Operator"})
+		subject, err := conf.SubjectOf(claims)
+		So(err, ShouldBeNil)
+		So(subject, ShouldEqual, provider.URL+" 1001")
+
+		Convey("Id token is refused with another nonce, audience or after expiry", func() {
+			_, err = conf.VerifyIdToken(p, idToken, "nonce2", time.Now())
+			So(err, ShouldNotBeNil)
+			_, err = conf.VerifyIdToken(p, idToken, "nonce1", time.Now().Add(2*time.Hour))
+			So(err, ShouldNotBeNil)
+			other := *conf
+			other.ClientId = "other"
+			_, err = other.VerifyIdToken(p, idToken, "nonce1", time.Now())
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Id token with changed claims is refused", func() {
+			parts := strings.Split(idToken, ".")
+			payload, _ := json.Marshal(map[string]interface{}{
+				"iss": provider.URL, "aud": "moduleab", "nonce": "nonce1",
+				"exp": time.Now().Add(time.Hour).Unix(), "preferred_username": "admin",
+			})
+			parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+			_, err = conf.VerifyIdToken(p, strings.Join(parts, "."), "nonce1", time.Now())
+			So(err, ShouldEqual, common.ErrorOidcBadToken)
+		})
+	})
+
+	Convey("Subject: Code is refused without the right verifier\n", t, func() {
+		p, err := common.OidcDiscover(conf.Issuer)
+		So(err, ShouldBeNil)
+		verifier, _ := common.NewOidcRandom()
+		code, _ := login(p, "state2", "nonce2", verifier)
+		_, err = conf.Exchange(p, code, "wrong-verifier")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Subject: Users without mapped roles get the default one\n", t, func() {
+		_, _, roles, err := conf.UserOf(map[string]interface{}{
+			"preferred_username": "bob",
+			"groups":             "dev",
+		})
+		So(err, ShouldBeNil)
+		So(roles, ShouldBeEmpty)
+		withDefault := *conf
+		withDefault.DefaultRole = "User"
+		_, _, roles, _ = withDefault.UserOf(map[string]interface{}{"preferred_username": "bob"})
+		So(roles, ShouldResemble, []string{"User"})
+		_, _, _, err = conf.UserOf(map[string]interface{}{})
+		So(err, ShouldEqual, common.ErrorOidcNoUser)
+		_, err = conf.SubjectOf(map[string]interface{}{"preferred_username": "bob"})
+		So(err, ShouldEqual, common.ErrorOidcNoSubject)
+	})
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"moduleab_server/common"
+	"moduleab_server/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPassword(t *testing.T) {
+	Convey("Subject: Passwords are hashed with bcrypt\n", t, func() {
+		hash, err := common.EncryptPassword("admin")
+		So(err, ShouldBeNil)
+		So(common.IsLegacyPassword(hash), ShouldBeFalse)
+
+		Convey("Same password gets different hash with its own salt", func() {
+			hash2, _ := common.EncryptPassword("admin")
+			So(hash2, ShouldNotEqual, hash)
+		})
+		Convey("Right password is verified without rehash", func() {
+			ok, rehash := common.VerifyPassword(hash, "admin")
+			So(ok, ShouldBeTrue)
+			So(rehash, ShouldBeFalse)
+		})
+		Convey("Wrong password is refused", func() {
+			ok, _ := common.VerifyPassword(hash, "Admin")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Legacy SHA1 hash still works but needs rehash", func() {
+			// SHA1 of "admin" in base64
+			legacy := "0DPiKuNIrrVmD8IUCuw1hQxNqZc="
+			So(common.IsLegacyPassword(legacy), ShouldBeTrue)
+			ok, rehash := common.VerifyPassword(legacy, "admin")
+			So(ok, ShouldBeTrue)
+			So(rehash, ShouldBeTrue)
+			ok, rehash = common.VerifyPassword(legacy, "Admin")
+			So(ok, ShouldBeFalse)
+			So(rehash, ShouldBeFalse)
+		})
+		Convey("Hash never leaves the server, password is write-only", func() {
+			b, err := json.Marshal(&models.Users{Name: "admin", Password: hash})
+			So(err, ShouldBeNil)
+			So(string(b), ShouldNotContainSubstring, hash)
+			So(string(b), ShouldNotContainSubstring, "password")
+
+			user := new(models.Users)
+			So(json.Unmarshal([]byte(`{"loginname":"admin","password":"s3cret"}`), user), ShouldBeNil)
+			So(user.NewPassword, ShouldEqual, "s3cret")
+			So(user.Password, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestLockoutDuration(t *testing.T) {
+	Convey("Subject: Login lockout grows with failures\n", t, func() {
+		So(common.LockoutDuration(4), ShouldEqual, 0)
+		So(common.LockoutDuration(5), ShouldEqual, 30*time.Second)
+		So(common.LockoutDuration(6), ShouldEqual, 60*time.Second)
+		So(common.LockoutDuration(8), ShouldEqual, 240*time.Second)
+		So(common.LockoutDuration(100), ShouldEqual, time.Hour)
+	})
+}
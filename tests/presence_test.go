@@ -0,0 +1,45 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"moduleab_server/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPresence(t *testing.T) {
+	now := time.Now()
+	stale := 30 * time.Second
+
+	Convey("Subject: Agents are online until heartbeats stop\n", t, func() {
+		p := models.NewPresence(&models.Hosts{Id: "h1", Name: "host1"}, "10.0.0.1", now)
+		So(p.ConnectionId, ShouldNotBeEmpty)
+		So(p.IsOnline(now.Add(stale), stale), ShouldBeTrue)
+		So(p.IsOnline(now.Add(stale+time.Second), stale), ShouldBeFalse)
+		p.Online = false
+		So(p.IsOnline(now, stale), ShouldBeFalse)
+	})
+
+	Convey("Subject: Fleet summary counts hosts by presence\n", t, func() {
+		hosts := []*models.Hosts{
+			{Id: "h1", Name: "host1"},
+			{Id: "h2", Name: "host2"},
+			{Id: "h3", Name: "host3"},
+		}
+		presences := map[string]*models.Presence{
+			"h1": {HostId: "h1", Online: true, AgentVersion: "1.2.0"},
+			"h2": {HostId: "h2", Online: false, AgentVersion: "1.1.0"},
+		}
+		s := models.SummarizePresence(hosts, presences)
+		So(s.Total, ShouldEqual, 3)
+		So(s.Online, ShouldEqual, 1)
+		So(s.Offline, ShouldEqual, 1)
+		So(s.NeverSeen, ShouldEqual, 1)
+		So(s.AgentVersions, ShouldResemble, map[string]int{"1.2.0": 1, "1.1.0": 1})
+		So(len(s.Hosts), ShouldEqual, 3)
+		So(s.Hosts[2].HostName, ShouldEqual, "host3")
+		So(s.Hosts[2].Online, ShouldBeFalse)
+	})
+}
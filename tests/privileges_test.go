@@ -0,0 +1,84 @@
+package test
+
+import (
+	"testing"
+
+	"moduleab_server/controllers"
+	"moduleab_server/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequiredPermission(t *testing.T) {
+	Convey("Subject: Permission required by requests\n", t, func() {
+		for _, c := range []struct {
+			method, url, permission string
+		}{
+			{"GET", "/api/v1/records", "records:read"},
+			{"GET", "/api/v1/records/abc", "records:read"},
+			{"DELETE", "/api/v1/records/abc", "records:delete"},
+			{"GET", "/api/v1/records/abc/recover", "records:recover"},
+			{"POST", "/api/v1/policies", "policies:write"},
+			{"PUT", "/api/v1/policies/p1", "policies:write"},
+			{"POST", "/api/v1/users/admin/unlock", "users:unlock"},
+			{"PUT", "/api/v1/users/admin/profile", ""},
+			{"PUT", "/api/v1/users/admin", "users:write"},
+			{"POST", "/api/v1/hosts/host1/keys/rotate", "hosts:keys"},
+			{"DELETE", "/api/v1/hosts/host1/keys/k1", "hosts:keys"},
+			{"DELETE", "/api/v1/hosts/host1", "hosts:delete"},
+			{"GET", "/api/v1/authEvents", "authEvents:admin"},
+			{"POST", "/api/v1/scopes", "scopes:admin"},
+			{"POST", "/api/v1/enrollmentTokens", "enrollmentTokens:admin"},
+			{"POST", "/api/v1/notificationChannels/abc/test", "notificationChannels:admin"},
+			{"POST", "/api/v1/webhookSubscriptions/abc/deliveries/d1/redeliver", "webhookSubscriptions:admin"},
+			{"POST", "/api/v1/hosts/h1/approve", "hosts:approve"},
+			{"POST", "/api/v1/auth/login", ""},
+			{"GET", "/api/v1/version", ""},
+			{"GET", "/index.html", ""},
+		} {
+			So(controllers.RequiredPermission(c.method, c.url), ShouldEqual, c.permission)
+		}
+	})
+
+	Convey("Subject: Granted permissions match required ones\n", t, func() {
+		So(models.MatchPermission("records:recover", "records:recover"), ShouldBeTrue)
+		So(models.MatchPermission("records:*", "records:recover"), ShouldBeTrue)
+		So(models.MatchPermission("*:read", "records:read"), ShouldBeTrue)
+		So(models.MatchPermission("*:*", "users:unlock"), ShouldBeTrue)
+		So(models.MatchPermission("*:read", "records:recover"), ShouldBeFalse)
+		So(models.MatchPermission("records:read", "policies:read"), ShouldBeFalse)
+		So(models.MatchPermission("records", "records:read"), ShouldBeFalse)
+	})
+
+	Convey("Subject: Operators can not do everything by default\n", t, func() {
+		allowed := func(flag int, required string) bool {
+			for _, p := range models.DefaultPermissions[flag] {
+				if models.MatchPermission(p, required) {
+					return true
+				}
+			}
+			return false
+		}
+		So(allowed(models.RoleFlagAdmin, "users:unlock"), ShouldBeTrue)
+		So(allowed(models.RoleFlagOperator, "records:recover"), ShouldBeTrue)
+		So(allowed(models.RoleFlagOperator, "users:unlock"), ShouldBeFalse)
+		So(allowed(models.RoleFlagUser, "records:read"), ShouldBeTrue)
+		So(allowed(models.RoleFlagUser, "records:delete"), ShouldBeFalse)
+		So(allowed(models.RoleFlagUser, "users:write"), ShouldBeFalse)
+		So(allowed(models.RoleFlagOperator, "records:delete"), ShouldBeTrue)
+		for _, v := range []string{"users:write", "roles:write", "roles:delete", "credentials:write"} {
+			So(allowed(models.RoleFlagOperator, v), ShouldBeFalse)
+		}
+	})
+}
+
+func TestAccessTokenScopes(t *testing.T) {
+	Convey("Subject: Access tokens only allow their scopes\n", t, func() {
+		token := &models.AccessTokens{Scopes: "records:read, hosts:*"}
+		So(token.ScopeList(), ShouldResemble, []string{"records:read", "hosts:*"})
+		So(token.Allows("records:read"), ShouldBeTrue)
+		So(token.Allows("hosts:keys"), ShouldBeTrue)
+		So(token.Allows("records:recover"), ShouldBeFalse)
+		So((&models.AccessTokens{}).Allows("records:recover"), ShouldBeTrue)
+	})
+}
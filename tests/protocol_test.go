@@ -0,0 +1,65 @@
+package test
+
+import (
+	"testing"
+
+	"moduleab_server/protocol"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProtocol(t *testing.T) {
+	Convey("Subject: Envelopes are the same after encoding\n", t, func() {
+		e, err := protocol.NewEnvelope(protocol.TypeResult, "s1", &protocol.Result{
+			Ok: false, Message: "disk full",
+		})
+		So(err, ShouldBeNil)
+		e.Id = "m1"
+		e.Time = 1500000000
+		for _, c := range []protocol.Codec{protocol.JSONCodec{}, protocol.ProtobufCodec{}} {
+			b, err := c.Encode(e)
+			So(err, ShouldBeNil)
+			got, err := c.Decode(b)
+			So(err, ShouldBeNil)
+			So(got.Version, ShouldEqual, protocol.Version)
+			So(got.Type, ShouldEqual, protocol.TypeResult)
+			So(got.Id, ShouldEqual, "m1")
+			So(got.Ref, ShouldEqual, "s1")
+			So(got.Time, ShouldEqual, 1500000000)
+			var r protocol.Result
+			So(got.DecodeBody(&r), ShouldBeNil)
+			So(r.Message, ShouldEqual, "disk full")
+		}
+	})
+
+	Convey("Subject: Malformed frames are refused with a code\n", t, func() {
+		c := protocol.JSONCodec{}
+		for _, v := range []struct {
+			frame, code string
+		}{
+			{`not json`, protocol.CodeBadFrame},
+			{`{"v":9,"type":"hello"}`, protocol.CodeUnsupportedVersion},
+			{`{"v":1,"type":"shutdown"}`, protocol.CodeUnknownType},
+			{`{"v":1,"type":"result","body":{"ok":true}}`, protocol.CodeBadFrame},
+			{`{"v":1,"type":"progress","ref":"s1","body":{"percent":"half"}}`, protocol.CodeBadFrame},
+		} {
+			_, err := c.Decode([]byte(v.frame))
+			So(err, ShouldNotBeNil)
+			So(protocol.ErrorCode(err), ShouldEqual, v.code)
+		}
+		_, err := protocol.ProtobufCodec{}.Decode([]byte{0x0a, 0xff})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Subject: Subprotocols tell version and encoding\n", t, func() {
+		So(protocol.Subprotocols(), ShouldContain, "moduleab.v1.json")
+		v, c, err := protocol.ParseSubprotocol("moduleab.v1.protobuf")
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, 1)
+		So(c.Name(), ShouldEqual, protocol.EncodingProtobuf)
+		_, _, err = protocol.ParseSubprotocol("moduleab.v2.json")
+		So(err, ShouldEqual, protocol.ErrorUnsupportedVersion)
+		_, _, err = protocol.ParseSubprotocol("moduleab.v1.xml")
+		So(err, ShouldEqual, protocol.ErrorUnsupportedVersion)
+	})
+}
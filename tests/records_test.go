@@ -0,0 +1,33 @@
+package test
+
+import (
+	"testing"
+
+	"moduleab_server/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordBindHost(t *testing.T) {
+	Convey("Subject: Hosts only post records under their own paths\n", t, func() {
+		app1 := &models.AppSets{Id: "app1"}
+		bs1 := &models.BackupSets{Id: "bs1"}
+		data := &models.Paths{Id: "p1", Path: "/data", BackupSet: bs1}
+		host := &models.Hosts{Id: "h1", AppSet: app1, Paths: []*models.Paths{data}}
+
+		r := &models.Records{Path: &models.Paths{Path: "/data"}}
+		So(r.BindHost(host), ShouldBeNil)
+		So(r.Path, ShouldEqual, data)
+		So(r.AppSet, ShouldEqual, app1)
+		So(r.BackupSet, ShouldEqual, bs1)
+
+		for _, r := range []*models.Records{
+			{},
+			{Path: &models.Paths{Id: "p2"}},
+			{Path: &models.Paths{Id: "p1"}, AppSet: &models.AppSets{Id: "app2"}},
+			{Path: &models.Paths{Id: "p1"}, BackupSet: &models.BackupSets{Id: "bs2"}},
+		} {
+			So(r.BindHost(host), ShouldEqual, models.ErrorRecordNotOfHost)
+		}
+	})
+}
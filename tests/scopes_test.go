@@ -0,0 +1,64 @@
+package test
+
+import (
+	"testing"
+
+	"moduleab_server/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScope(t *testing.T) {
+	app1 := &models.AppSets{Id: "app1"}
+	app2 := &models.AppSets{Id: "app2"}
+	bs1 := &models.BackupSets{Id: "bs1"}
+	bs2 := &models.BackupSets{Id: "bs2"}
+
+	Convey("Subject: No binding means no limit\n", t, func() {
+		var s *models.Scope
+		So(models.NewScope(nil), ShouldBeNil)
+		So(s.AllowsRecord(&models.Records{AppSet: app1, BackupSet: bs1}), ShouldBeTrue)
+		So(s.AllowsHost(&models.Hosts{}), ShouldBeTrue)
+	})
+
+	Convey("Subject: Bindings of user and roles are merged\n", t, func() {
+		s := models.NewScope([]*models.ScopeBindings{
+			{User: &models.Users{Id: "u"}, AppSet: app1},
+			{Role: &models.Roles{Id: "r"}, BackupSet: bs1},
+		})
+		So(s.AllowsRecord(&models.Records{AppSet: app1, BackupSet: bs1}), ShouldBeTrue)
+		So(s.AllowsRecord(&models.Records{AppSet: app2, BackupSet: bs1}), ShouldBeFalse)
+		So(s.AllowsRecord(&models.Records{AppSet: app1, BackupSet: bs2}), ShouldBeFalse)
+		So(s.AllowsHost(&models.Hosts{AppSet: app1}), ShouldBeTrue)
+		So(s.AllowsHost(&models.Hosts{}), ShouldBeFalse)
+		So(s.AllowsPolicy(&models.Policies{BackupSet: bs2}), ShouldBeFalse)
+		So(s.AllowsPath(&models.Paths{
+			BackupSet: bs1,
+			AppSet:    []*models.AppSets{app2, app1},
+		}), ShouldBeTrue)
+		So(s.AllowsPath(&models.Paths{
+			BackupSet: bs1,
+			AppSet:    []*models.AppSets{app2},
+		}), ShouldBeFalse)
+	})
+
+	Convey("Subject: Binding only app sets leaves backup sets open\n", t, func() {
+		s := models.NewScope([]*models.ScopeBindings{
+			{User: &models.Users{Id: "u"}, AppSet: app1},
+		})
+		So(s.AllowsRecord(&models.Records{AppSet: app1, BackupSet: bs2}), ShouldBeTrue)
+		So(s.AllowsPolicy(&models.Policies{BackupSet: bs2}), ShouldBeTrue)
+	})
+
+	Convey("Subject: What is put into app sets needs all of them in scope\n", t, func() {
+		s := models.NewScope([]*models.ScopeBindings{
+			{User: &models.Users{Id: "u"}, AppSet: app1},
+		})
+		So(s.AllowsAppSets([]*models.AppSets{app1}), ShouldBeTrue)
+		So(s.AllowsAppSets([]*models.AppSets{app1, app2}), ShouldBeFalse)
+		So(s.AllowsHost(&models.Hosts{AppSet: app2}), ShouldBeFalse)
+		So(s.AllowsHost(&models.Hosts{}), ShouldBeFalse)
+		var all *models.Scope
+		So(all.AllowsAppSets([]*models.AppSets{app2}), ShouldBeTrue)
+	})
+}
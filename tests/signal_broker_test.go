@@ -0,0 +1,76 @@
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"moduleab_server/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func woken(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+func TestSignalBroker(t *testing.T) {
+	Convey("Subject: Hub wakes every websocket of the host only\n", t, func() {
+		hub := models.NewSignalHub()
+		c1, cancel1 := hub.Subscribe("host1")
+		c2, cancel2 := hub.Subscribe("host1")
+		c3, cancel3 := hub.Subscribe("host2")
+		defer cancel3()
+		So(hub.Subscribers("host1"), ShouldEqual, 2)
+
+		hub.Wake("host1")
+		// Waking twice must not block.
+		hub.Wake("host1")
+		So(woken(c1), ShouldBeTrue)
+		So(woken(c2), ShouldBeTrue)
+		So(len(c3), ShouldEqual, 0)
+
+		cancel1()
+		cancel2()
+		So(hub.Subscribers("host1"), ShouldEqual, 0)
+		hub.Wake("host1")
+	})
+
+	Convey("Subject: Hub can be used by many goroutines\n", t, func() {
+		hub := models.NewSignalHub()
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c, cancel := hub.Subscribe("host1")
+				hub.Wake("host1")
+				<-c
+				cancel()
+			}()
+		}
+		wg.Wait()
+		So(hub.Subscribers("host1"), ShouldEqual, 0)
+	})
+
+	Convey("Subject: Local broker passes published hosts to the hub\n", t, func() {
+		hub := models.NewSignalHub()
+		broker := models.NewLocalSignalBroker()
+		c, cancel := hub.Subscribe("host1")
+		defer cancel()
+		So(broker.Publish("host1"), ShouldBeNil)
+		So(len(c), ShouldEqual, 0)
+
+		go broker.Run(hub.Wake)
+		for i := 0; i < 100 && len(c) == 0; i++ {
+			broker.Publish("host1")
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(woken(c), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,64 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"moduleab_server/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSignalQueue(t *testing.T) {
+	now := time.Now()
+	timeout := 5 * time.Minute
+
+	Convey("Subject: Signals are delivered until acked or out of attempts\n", t, func() {
+		s := &models.Signals{
+			Id:          "s1",
+			Payload:     `{"type":1,"path":"/a"}`,
+			Status:      models.SignalStatusPending,
+			VisibleTime: now,
+		}
+		So(s.Deliverable(now), ShouldBeTrue)
+
+		So(s.Deliver(now, timeout, 2), ShouldBeTrue)
+		So(s.Status, ShouldEqual, models.SignalStatusDelivered)
+		So(s.Attempts, ShouldEqual, 1)
+		So(s.Deliverable(now.Add(time.Minute)), ShouldBeFalse)
+		So(s.Deliverable(now.Add(timeout)), ShouldBeTrue)
+
+		signal, err := s.Signal()
+		So(err, ShouldBeNil)
+		So(signal["id"], ShouldEqual, "s1")
+		So(signal["attempts"], ShouldEqual, 1)
+		So(signal["path"], ShouldEqual, "/a")
+
+		Convey("Nack makes it deliverable at once", func() {
+			later := now.Add(time.Minute)
+			s.Nack(later, "disk full", 2)
+			So(s.Status, ShouldEqual, models.SignalStatusPending)
+			So(s.LastError, ShouldEqual, "disk full")
+			So(s.Deliverable(later), ShouldBeTrue)
+		})
+
+		Convey("It is dead after all attempts", func() {
+			So(s.Deliver(now.Add(timeout), timeout, 2), ShouldBeTrue)
+			So(s.Deliver(now.Add(2*timeout), timeout, 2), ShouldBeFalse)
+			So(s.Status, ShouldEqual, models.SignalStatusDead)
+			So(s.Attempts, ShouldEqual, 2)
+			So(s.Deliverable(now.Add(3*timeout)), ShouldBeFalse)
+		})
+
+		Convey("Nack of the last attempt makes it dead", func() {
+			So(s.Deliver(now.Add(timeout), timeout, 2), ShouldBeTrue)
+			s.Nack(now.Add(timeout), "disk full", 2)
+			So(s.Status, ShouldEqual, models.SignalStatusDead)
+		})
+
+		Convey("Acked signals are never delivered again", func() {
+			s.Status = models.SignalStatusAcked
+			So(s.Deliverable(now.Add(time.Hour)), ShouldBeFalse)
+		})
+	})
+}
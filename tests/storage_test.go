@@ -0,0 +1,78 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"moduleab_server/storage"
+	"moduleab_server/storage/local"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLocalStorage(t *testing.T) {
+	root, _ := ioutil.TempDir("", "moduleab")
+	defer os.RemoveAll(root)
+	local.Root = root
+
+	hotConf := storage.HotConfig{Type: local.Name, Bucket: "bucket"}
+	coldConf := storage.ColdConfig{Type: local.Name, VaultName: "vault"}
+	key := "Default/host1/var/log/app.tar.gz"
+
+	Convey("Subject: Local storage adapter\n", t, func() {
+		hot, err := storage.NewHotStorage(hotConf)
+		So(err, ShouldBeNil)
+		cold, err := storage.NewColdStorage(coldConf)
+		So(err, ShouldBeNil)
+
+		Convey("Objects can be put, listed and read back", func() {
+			So(hot.PutObject(key, strings.NewReader("backup")), ShouldBeNil)
+			objects, err := hot.ListObjects("Default/host1")
+			So(err, ShouldBeNil)
+			So(len(objects), ShouldEqual, 1)
+			So(objects[0].Key, ShouldEqual, key)
+
+			r, err := hot.GetObject(key)
+			So(err, ShouldBeNil)
+			b, _ := ioutil.ReadAll(r)
+			r.Close()
+			So(string(b), ShouldEqual, "backup")
+		})
+
+		Convey("Keys cannot escape the bucket", func() {
+			So(hot.PutObject("../../escape", strings.NewReader("x")), ShouldBeNil)
+			_, err := os.Stat(root + "/escape")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("Archive and restore go through jobs", func() {
+			So(hot.PutObject(key, strings.NewReader("backup")), ShouldBeNil)
+			job, err := cold.Archive(hotConf, key)
+			So(err, ShouldBeNil)
+
+			job, err = cold.GetJob(job.Id)
+			So(err, ShouldBeNil)
+			So(job.Completed, ShouldBeTrue)
+			So(job.Failed, ShouldBeFalse)
+			So(job.ArchiveId, ShouldNotBeEmpty)
+
+			So(hot.DeleteObject(key), ShouldBeNil)
+			_, err = hot.GetObject(key)
+			So(err, ShouldEqual, storage.ErrorObjectNotFound)
+
+			job, err = cold.Restore(hotConf, job.ArchiveId, key)
+			So(err, ShouldBeNil)
+			So(job.Completed, ShouldBeTrue)
+			r, err := hot.GetObject(key)
+			So(err, ShouldBeNil)
+			r.Close()
+		})
+
+		Convey("Unknown adapters are reported", func() {
+			_, err := storage.NewHotStorage(storage.HotConfig{Type: "nothing"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
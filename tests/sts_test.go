@@ -0,0 +1,48 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"moduleab_server/models"
+	"moduleab_server/storage/aliyun"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAliyunSTS(t *testing.T) {
+	Convey("Subject: Aliyun STS tokens\n", t, func() {
+		Convey("RPC signature matches the example of aliyun docs", func() {
+			q := url.Values{}
+			q.Set("AccessKeyId", "testid")
+			q.Set("Action", "DescribeRegions")
+			q.Set("Format", "XML")
+			q.Set("SignatureMethod", "HMAC-SHA1")
+			q.Set("SignatureNonce", "3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf")
+			q.Set("SignatureVersion", "1.0")
+			q.Set("Timestamp", "2016-02-23T12:46:24Z")
+			q.Set("Version", "2014-05-26")
+			So(aliyun.SignRPC(http.MethodGet, q, "testsecret"),
+				ShouldEqual, "OLeaidS1JvxuMvnyHOwuJ+uX5qY=")
+		})
+		Convey("Policy only allows the prefix of host", func() {
+			p := aliyun.ScopedPolicy("bucket", "Default/host1/var/log/")
+			So(p, ShouldContainSubstring, `"acs:oss:*:*:bucket/Default/host1/var/log/*"`)
+			So(p, ShouldContainSubstring, `"oss:Prefix":["Default/host1/var/log/*"]`)
+			So(p, ShouldNotContainSubstring, `"oss:DeleteObject"`)
+		})
+	})
+}
+
+func TestStorageTokenFilename(t *testing.T) {
+	Convey("Subject: Presigned files stay in the prefix of host\n", t, func() {
+		So(models.ValidTokenFilename("2026-10-18.tar.gz"), ShouldBeNil)
+		for _, name := range []string{"", "../../host2/var/x", "a/b", `..\x`, ".."} {
+			So(models.ValidTokenFilename(name), ShouldEqual, models.ErrorTokenBadFilename)
+		}
+		host := &models.Hosts{Name: "host1", AppSet: &models.AppSets{Name: "Default"}}
+		_, err := models.IssueStorageTokens(host, "", "../../host2/var/x")
+		So(err, ShouldEqual, models.ErrorTokenBadFilename)
+	})
+}
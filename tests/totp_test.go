@@ -0,0 +1,48 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"moduleab_server/common"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTotp(t *testing.T) {
+	// "12345678901234567890" of RFC 6238 test vectors
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	Convey("Subject: TOTP two-factor codes\n", t, func() {
+		Convey("Codes match RFC 6238 test vectors", func() {
+			for unix, code := range map[int64]string{
+				59:         "287082",
+				1111111109: "081804",
+				1234567890: "005924",
+				2000000000: "279037",
+			} {
+				c, err := common.TotpCode(secret, common.TotpStep(time.Unix(unix, 0)))
+				So(err, ShouldBeNil)
+				So(c, ShouldEqual, code)
+			}
+		})
+		Convey("Code of last step is accepted, but only once", func() {
+			now := time.Unix(1234567890, 0)
+			code, _ := common.TotpCode(secret, common.TotpStep(now)-1)
+			step := common.VerifyTotp(secret, code, now, 0)
+			So(step, ShouldEqual, common.TotpStep(now)-1)
+			So(common.VerifyTotp(secret, code, now, step), ShouldEqual, 0)
+		})
+		Convey("Old code is refused", func() {
+			now := time.Unix(1234567890, 0)
+			code, _ := common.TotpCode(secret, common.TotpStep(now)-5)
+			So(common.VerifyTotp(secret, code, now, 0), ShouldEqual, 0)
+		})
+		Convey("URI can be scanned by authenticator apps", func() {
+			uri := common.TotpURI("ModuleAB", "admin", secret)
+			So(strings.HasPrefix(uri, "otpauth://totp/ModuleAB:admin?"), ShouldBeTrue)
+			So(uri, ShouldContainSubstring, "secret="+secret)
+		})
+	})
+}
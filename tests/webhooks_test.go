@@ -0,0 +1,90 @@
+package test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"moduleab_server/models"
+	"moduleab_server/notify"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebhooks(t *testing.T) {
+	Convey("Subject: Subscriptions match events by patterns\n", t, func() {
+		sub := &models.WebhookSubscriptions{Events: "record.*, oasjob.completed"}
+		So(sub.Wants(models.EventRecordArchived), ShouldBeTrue)
+		So(sub.Wants(models.EventOasJobCompleted), ShouldBeTrue)
+		So(sub.Wants(models.EventOasJobFailed), ShouldBeFalse)
+		So((&models.WebhookSubscriptions{}).Wants(models.EventOasJobFailed), ShouldBeTrue)
+	})
+
+	Convey("Subject: Events of a resource are sent in order\n", t, func() {
+		now := time.Now()
+		sub := &models.WebhookSubscriptions{Id: "s1"}
+		other := &models.WebhookSubscriptions{Id: "s2"}
+		d := func(id, resource, status string, next time.Time) *models.WebhookDeliveries {
+			return &models.WebhookDeliveries{
+				Id: id, Subscription: sub, Resource: resource,
+				Status: status, NextTime: next,
+			}
+		}
+		pending := []*models.WebhookDeliveries{
+			d("r1-created", "records/r1", models.WebhookStatusPending, now.Add(time.Minute)),
+			d("r1-archived", "records/r1", models.WebhookStatusPending, now),
+			d("r2-created", "records/r2", models.WebhookStatusPending, now),
+			d("r2-deleted", "records/r2", models.WebhookStatusPending, now),
+		}
+		pending = append(pending, &models.WebhookDeliveries{
+			Id: "r1-other", Subscription: other, Resource: "records/r1",
+			Status: models.WebhookStatusPending, NextTime: now,
+		})
+
+		seen := make(map[string]bool)
+		next := models.NextWebhookDeliveries(pending[:2], seen, now)
+		So(next, ShouldBeEmpty)
+		next = models.NextWebhookDeliveries(pending[2:], seen, now)
+		ids := make([]string, 0)
+		for _, v := range next {
+			ids = append(ids, v.Id)
+		}
+		So(ids, ShouldResemble, []string{"r2-created", "r1-other"})
+	})
+
+	Convey("Subject: Failed deliveries are retried, then given up\n", t, func() {
+		now := time.Now()
+		d := &models.WebhookDeliveries{Status: models.WebhookStatusPending, Attempts: 1}
+		d.Fail(now, errors.New("503 Service Unavailable"), 8)
+		So(d.Status, ShouldEqual, models.WebhookStatusPending)
+		So(d.NextTime, ShouldResemble, now.Add(models.NotificationBackoff(1)))
+		d.Attempts = 8
+		d.Fail(now, errors.New("503 Service Unavailable"), 8)
+		So(d.Status, ShouldEqual, models.WebhookStatusFailed)
+	})
+
+	Convey("Subject: Deliveries are signed\n", t, func() {
+		var header http.Header
+		var body []byte
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			body, _ = ioutil.ReadAll(r.Body)
+		}))
+		defer ts.Close()
+
+		payload := []byte(`{"id":"e1","type":"record.archived","resource":"records/r1"}`)
+		h := make(http.Header)
+		h.Set(notify.HeaderDelivery, "d1")
+		err := notify.PostSigned(ts.URL, "s3cret", models.EventRecordArchived, payload, h)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, string(payload))
+		So(header.Get(notify.HeaderDelivery), ShouldEqual, "d1")
+		So(header.Get(notify.HeaderEvent), ShouldEqual, models.EventRecordArchived)
+		timestamp, _ := strconv.ParseInt(header.Get(notify.HeaderTimestamp), 10, 64)
+		So(header.Get(notify.HeaderSignature), ShouldEqual, notify.Sign("s3cret", timestamp, payload))
+	})
+}